@@ -2,6 +2,8 @@ package gos3
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"mime/multipart"
 
 	"github.com/the-lanky/go-utils/gologger"
@@ -22,6 +24,15 @@ type GoS3Config struct {
 	ProjectName     string
 }
 
+// ErrInvalidConfig is returned by Connect when the AWS configuration cannot be loaded.
+var ErrInvalidConfig = errors.New("error loading aws configuration")
+
+// GoS3 is an interface that defines the methods for the GoS3 client.
+type GoS3 interface {
+	Client() *s3.Client
+	UploadFile(ctx context.Context, bucket string, key string, file multipart.File) (*GoS3UploadFileResult, error)
+}
+
 type gos3 struct {
 	client *s3.Client
 	log    *logrus.Logger
@@ -30,25 +41,59 @@ type gos3 struct {
 
 var GoS3Client *gos3
 
+// InitGoS3 initializes GoS3Client.
+// It exits the process when the AWS configuration cannot be loaded, see Connect for the error-returning version.
 func InitGoS3(
 	ctx context.Context,
 	conf GoS3Config,
 	log *logrus.Logger,
 ) {
 	if log == nil {
-		gologger.New(
-			gologger.SetServiceName("GoS3"),
-		)
-		log = gologger.Logger
+		log = newLogger()
+	}
+
+	g, er := newClient(ctx, conf, log)
+	if er != nil {
+		log.Fatalf("[GoS3] Error loading default config: %+v", er)
+	}
+
+	GoS3Client = g
+	log.Info("[GoS3] Initialized successfully...")
+}
+
+// Connect creates a new GoS3 client.
+// It returns an error wrapping ErrInvalidConfig when the AWS configuration cannot be loaded.
+// Unlike InitGoS3, it does not set GoS3Client.
+func Connect(
+	ctx context.Context,
+	conf GoS3Config,
+	log *logrus.Logger,
+) (GoS3, error) {
+	if log == nil {
+		log = newLogger()
+	}
+
+	g, err := newClient(ctx, conf, log)
+	if err != nil {
+		return nil, err
 	}
 
-	cnf, er := config.LoadDefaultConfig(
+	log.Info("[GoS3] Initialized successfully...")
+	return g, nil
+}
+
+func newClient(
+	ctx context.Context,
+	conf GoS3Config,
+	log *logrus.Logger,
+) (*gos3, error) {
+	cnf, err := config.LoadDefaultConfig(
 		ctx,
 		config.WithRegion(conf.Region),
 		config.WithBaseEndpoint(conf.BaseEndpoint),
 	)
-	if er != nil {
-		log.Fatalf("[GoS3] Error loading default config: %+v", er)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
 	}
 
 	client := s3.NewFromConfig(cnf, func(o *s3.Options) {
@@ -60,12 +105,18 @@ func InitGoS3(
 		)
 	})
 
-	GoS3Client = &gos3{
+	return &gos3{
 		client: client,
 		log:    log,
 		conf:   conf,
-	}
-	log.Info("[GoS3] Initialized successfully...")
+	}, nil
+}
+
+func newLogger() *logrus.Logger {
+	gologger.New(
+		gologger.SetServiceName("GoS3"),
+	)
+	return gologger.Logger
 }
 
 func (g *gos3) Client() *s3.Client {
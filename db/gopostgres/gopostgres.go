@@ -1,6 +1,7 @@
 package gopostgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -17,6 +18,13 @@ import (
 	grmlog "gorm.io/gorm/logger"
 )
 
+var (
+	// ErrConnectionFailed is returned by Connect when the database cannot be reached.
+	ErrConnectionFailed = errors.New("error connecting to database")
+	// ErrConnectionNotFound is returned by GetPostgreConnection when no connection has the name.
+	ErrConnectionNotFound = errors.New("connection not found")
+)
+
 type GoPostgres interface {
 	Database() *gorm.DB
 	SQL() *sql.DB
@@ -46,18 +54,38 @@ type postgre struct {
 	log *logrus.Logger
 }
 
+// New is a function that creates a new GoPostgres.
+// It exits the process when the connection fails, see Connect for the error-returning version.
 func New(
 	isProduction bool,
 	config GoPostgresConfiguration,
 	log *logrus.Logger,
 ) GoPostgres {
 	if log == nil {
-		gologger.New(
-			gologger.SetIsProduction(isProduction),
-			gologger.SetServiceName("GoPostgres Database"),
-			gologger.SetPrettyPrint(true),
-		)
-		log = gologger.Logger
+		log = newLogger(isProduction)
+	}
+
+	pg, err := Connect(context.Background(), isProduction, config, log)
+	if err != nil {
+		log.Fatalf("[GoPostgres] Error: %v", err)
+	}
+
+	return pg
+}
+
+// Connect is a function that creates a new GoPostgres.
+// It takes a context, whether it runs in production, a GoPostgresConfiguration, and a pointer to a logrus.Logger
+// and returns a GoPostgres and an error.
+// The connection is retried as configured, and an error wrapping ErrConnectionFailed is returned once the
+// retries are used up. The retries stop when the context is done.
+func Connect(
+	ctx context.Context,
+	isProduction bool,
+	config GoPostgresConfiguration,
+	log *logrus.Logger,
+) (GoPostgres, error) {
+	if log == nil {
+		log = newLogger(isProduction)
 	}
 
 	log.Info("[GoPostgres] Creating database connection...")
@@ -161,34 +189,40 @@ func New(
 			gormConfig,
 		)
 		if err != nil {
-			if retries > 1 {
-				log.Info("[GoPostgres] Retrying connection to database...")
-				time.Sleep(interval)
-			}
 			errConnection = err
 			try++
+			if try < retries {
+				log.Info("[GoPostgres] Retrying connection to database...")
+				if !sleep(ctx, interval) {
+					break
+				}
+			}
 			continue
 		}
 
 		sqlDb, err := db.DB()
 		if err != nil {
-			if retries > 1 {
-				log.Info("[GoPostgres] Retrying get underlying *sql.DB...")
-				time.Sleep(interval)
-			}
 			errConnection = err
 			try++
+			if try < retries {
+				log.Info("[GoPostgres] Retrying get underlying *sql.DB...")
+				if !sleep(ctx, interval) {
+					break
+				}
+			}
 			continue
 		}
 
-		err = sqlDb.Ping()
+		err = sqlDb.PingContext(ctx)
 		if err != nil {
-			if retries > 1 {
-				log.Info("[GoPostgres] Retrying ping database...")
-				time.Sleep(interval)
-			}
 			errConnection = err
 			try++
+			if try < retries {
+				log.Info("[GoPostgres] Retrying ping database...")
+				if !sleep(ctx, interval) {
+					break
+				}
+			}
 			continue
 		}
 
@@ -230,12 +264,15 @@ func New(
 		)
 	}
 
-	if !success && errConnection != nil {
+	if !success {
 		log.Errorf("[GoPostgres] (Attempts: %d/%d) Failed to connect to database", try, retries)
-		log.Fatalf("[GoPostgres] Error: %v", errConnection)
+		if errConnection == nil {
+			errConnection = ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", ErrConnectionFailed, errConnection)
 	}
 
-	return pg
+	return pg, nil
 }
 
 func (p *postgre) Database() *gorm.DB {
@@ -246,21 +283,51 @@ func (p *postgre) SQL() *sql.DB {
 	return p.sql
 }
 
+// Close is a function that closes the database connection.
+// The error is logged instead of exiting the process, see the Close function for the error-returning version.
 func (p *postgre) Close() {
-	if sql := p.sql; sql != nil {
-		if err := sql.Close(); err != nil {
-			p.log.Errorf("[GoPostgres] Error closing database connection: %v", err)
-			os.Exit(1)
-		} else {
-			p.log.Info("[GoPostgres] Database connection closed successfully")
-		}
+	if err := Close(p); err != nil {
+		p.log.Errorf("[GoPostgres] Error closing database connection: %v", err)
+		return
 	}
+	p.log.Info("[GoPostgres] Database connection closed successfully")
+}
+
+// Close is a function that closes the database connection of a GoPostgres.
+// It takes a GoPostgres and returns an error.
+// This is used when the caller needs the error, as the Close method of GoPostgres does not return it.
+func Close(pg GoPostgres) error {
+	if sql := pg.SQL(); sql != nil {
+		return sql.Close()
+	}
+	return nil
 }
 
 func trimString(s string) string {
 	return strings.TrimSpace(s)
 }
 
+func newLogger(isProduction bool) *logrus.Logger {
+	gologger.New(
+		gologger.SetIsProduction(isProduction),
+		gologger.SetServiceName("GoPostgres Database"),
+		gologger.SetPrettyPrint(true),
+	)
+	return gologger.Logger
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
 var GoPostgresConnection map[string]*gorm.DB = make(map[string]*gorm.DB)
 
 func SetupPostgreConnection(connectionName string, db *gorm.DB) {
@@ -271,6 +338,6 @@ func GetPostgreConnection(connectionName string) (*gorm.DB, error) {
 	if conn, ok := GoPostgresConnection[connectionName]; ok {
 		return conn, nil
 	} else {
-		return nil, errors.New("connection not found")
+		return nil, ErrConnectionNotFound
 	}
 }
@@ -0,0 +1,66 @@
+package gopostgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func TestConnectFailed(t *testing.T) {
+	// Nothing listens on port 1, so the connection is refused right away.
+	_, err := Connect(context.Background(), false, GoPostgresConfiguration{
+		Host:          "127.0.0.1",
+		Port:          "1",
+		Retries:       2,
+		RetryInterval: 10 * time.Millisecond,
+	}, testLogger())
+	if !errors.Is(err, ErrConnectionFailed) {
+		t.Fatalf("Connect error = %v, want ErrConnectionFailed", err)
+	}
+}
+
+func TestConnectCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err := Connect(ctx, false, GoPostgresConfiguration{
+		Host:          "127.0.0.1",
+		Port:          "1",
+		Retries:       5,
+		RetryInterval: time.Minute,
+	}, testLogger())
+	if !errors.Is(err, ErrConnectionFailed) {
+		t.Fatalf("Connect error = %v, want ErrConnectionFailed", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("Connect took %v, the retries did not stop with the context", d)
+	}
+}
+
+func TestClose(t *testing.T) {
+	pg := &postgre{log: testLogger()}
+
+	if err := Close(pg); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+
+	// The method only logs, so it can be called on a connection that was never opened.
+	pg.Close()
+}
+
+func TestGetPostgreConnection(t *testing.T) {
+	if _, err := GetPostgreConnection("missing"); !errors.Is(err, ErrConnectionNotFound) {
+		t.Fatalf("GetPostgreConnection error = %v, want ErrConnectionNotFound", err)
+	}
+}
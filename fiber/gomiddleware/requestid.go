@@ -1,16 +1,20 @@
 package gomiddleware
 
 import (
+	"github.com/the-lanky/go-utils/gocontext"
+
 	"github.com/gofiber/fiber/v3"
 	"github.com/google/uuid"
 )
 
 // XRequestID is a constant that represents the request id header.
 // It is used to represent the request id header.
-const XRequestID string = "X-Request-ID"
+const XRequestID string = gocontext.HeaderRequestID
 
 // RequestID is a function that returns a fiber.Handler.
 // It is used to return a fiber.Handler.
+// The request id, and the W3C traceparent and tracestate when sent, are also put in the context of the request,
+// so they are propagated by the packages reading them with gocontext, e.g. to the messages published by gorabbit.
 func RequestID() fiber.Handler {
 	fn := func(c fiber.Ctx) error {
 		rqId := c.Get(XRequestID, "")
@@ -19,6 +23,16 @@ func RequestID() fiber.Handler {
 		}
 		c.Locals(XRequestID, rqId)
 		c.Set(XRequestID, rqId)
+
+		ctx := gocontext.WithRequestID(c.Context(), rqId)
+		if tp := c.Get(gocontext.HeaderTraceParent, ""); len(tp) > 0 {
+			ctx = gocontext.WithTraceParent(ctx, tp)
+			if ts := c.Get(gocontext.HeaderTraceState, ""); len(ts) > 0 {
+				ctx = gocontext.WithTraceState(ctx, ts)
+			}
+		}
+		c.SetContext(ctx)
+
 		return c.Next()
 	}
 	return fn
@@ -0,0 +1,47 @@
+package gomiddleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/the-lanky/go-utils/gocontext"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+func TestRequestID(t *testing.T) {
+	const traceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	var requestId, gotTraceParent string
+	app := fiber.New()
+	app.Use(RequestID())
+	app.Get("/", func(c fiber.Ctx) error {
+		requestId = gocontext.RequestID(c.Context())
+		gotTraceParent = gocontext.TraceParent(c.Context())
+		return nil
+	})
+
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	req.Header.Set(XRequestID, "req-1")
+	req.Header.Set(gocontext.HeaderTraceParent, traceParent)
+
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requestId != "req-1" || gotTraceParent != traceParent {
+		t.Errorf("context request id = %q, traceparent = %q", requestId, gotTraceParent)
+	}
+	if v := res.Header.Get(XRequestID); v != "req-1" {
+		t.Errorf("response request id = %q", v)
+	}
+
+	// A request id is generated when the request has none.
+	res, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := res.Header.Get(XRequestID); v == "" || v != requestId {
+		t.Errorf("response request id = %q, context request id = %q", v, requestId)
+	}
+}
@@ -0,0 +1,126 @@
+package gorabbitadmin
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/the-lanky/go-utils/fiber/goresponse"
+	"github.com/the-lanky/go-utils/gorabbit"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// ParamQueue is the name of the path parameter of the queue.
+const ParamQueue = "queue"
+
+// Register is a function that mounts the admin handlers of a GoRabbit on a router.
+// It takes a fiber.Router and a gorabbit.GoRabbit and returns nothing.
+// The router should be protected, e.g. by an authentication middleware, as it can stop the consumers.
+//
+//	GET  /               the stats of the queues
+//	GET  /:queue         the stats of a queue
+//	POST /:queue/pause   pauses the consumers of a queue
+//	POST /:queue/resume  resumes the consumers of a queue
+//	POST /:queue/cancel  cancels the consumers of a queue
+//
+//	gorabbitadmin.Register(app.Group("/admin/rabbit", auth), r)
+func Register(router fiber.Router, r gorabbit.GoRabbit) {
+	router.Get("/", Stats(r))
+	router.Get("/:"+ParamQueue, Queue(r))
+	router.Post("/:"+ParamQueue+"/pause", Pause(r))
+	router.Post("/:"+ParamQueue+"/resume", Resume(r))
+	router.Post("/:"+ParamQueue+"/cancel", Cancel(r))
+}
+
+// Stats is a function that returns a fiber.Handler.
+// It takes a gorabbit.GoRabbit and returns a fiber.Handler.
+// The handler responds with the stats of the queues as JSON.
+func Stats(r gorabbit.GoRabbit) fiber.Handler {
+	res := goresponse.NewGoResponseClient()
+	return func(c fiber.Ctx) error {
+		return res.Jsonify(c, fiber.StatusOK, "OK", r.Stats(), nil)
+	}
+}
+
+// Queue is a function that returns a fiber.Handler.
+// It takes a gorabbit.GoRabbit and returns a fiber.Handler.
+// The handler responds with the stats of the queue of the path as JSON, and with 404 when it is not consumed.
+func Queue(r gorabbit.GoRabbit) fiber.Handler {
+	res := goresponse.NewGoResponseClient()
+	return func(c fiber.Ctx) error {
+		queue := queueParam(c)
+		for _, s := range r.Stats() {
+			if s.Queue == queue {
+				return res.Jsonify(c, fiber.StatusOK, "OK", s, nil)
+			}
+		}
+		return respondError(c, res, gorabbit.ErrQueueNotFound)
+	}
+}
+
+// Pause is a function that returns a fiber.Handler.
+// It takes a gorabbit.GoRabbit and returns a fiber.Handler.
+// The handler pauses the consumers of the queue of the path.
+func Pause(r gorabbit.GoRabbit) fiber.Handler {
+	return control(r, r.Pause)
+}
+
+// Resume is a function that returns a fiber.Handler.
+// It takes a gorabbit.GoRabbit and returns a fiber.Handler.
+// The handler resumes the consumers of the queue of the path.
+func Resume(r gorabbit.GoRabbit) fiber.Handler {
+	return control(r, r.Resume)
+}
+
+// Cancel is a function that returns a fiber.Handler.
+// It takes a gorabbit.GoRabbit and returns a fiber.Handler.
+// The handler cancels the consumers of the queue of the path.
+func Cancel(r gorabbit.GoRabbit) fiber.Handler {
+	return control(r, r.Cancel)
+}
+
+// control is a function that returns the fiber.Handler of a queue control.
+// It takes a gorabbit.GoRabbit and the control and returns a fiber.Handler.
+// The handler responds with the stats of the queue once the control is applied.
+func control(r gorabbit.GoRabbit, fn func(queue string) error) fiber.Handler {
+	res := goresponse.NewGoResponseClient()
+	return func(c fiber.Ctx) error {
+		queue := queueParam(c)
+		if err := fn(queue); err != nil {
+			return respondError(c, res, err)
+		}
+
+		for _, s := range r.Stats() {
+			if s.Queue == queue {
+				return res.Jsonify(c, fiber.StatusOK, "OK", s, nil)
+			}
+		}
+		return res.Jsonify(c, fiber.StatusOK, "OK", nil, nil)
+	}
+}
+
+// queueParam is a function that returns the queue of the path.
+// It takes a fiber.Ctx and returns a string.
+// The queue is unescaped, so a queue name with reserved characters can be sent escaped.
+func queueParam(c fiber.Ctx) string {
+	queue := c.Params(ParamQueue)
+	if unescaped, err := url.PathUnescape(queue); err == nil {
+		return unescaped
+	}
+	return queue
+}
+
+// respondError is a function that responds with the status of a gorabbit error.
+// It takes a fiber.Ctx, a goresponse.GoResponseClient, and an error and returns an error.
+func respondError(c fiber.Ctx, res goresponse.GoResponseClient, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, gorabbit.ErrQueueNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, gorabbit.ErrQueueCancelled):
+		status = fiber.StatusConflict
+	case errors.Is(err, gorabbit.ErrNotConnected):
+		status = fiber.StatusServiceUnavailable
+	}
+	return res.Jsonify(c, status, err.Error(), nil, nil)
+}
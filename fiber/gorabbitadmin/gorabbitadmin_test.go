@@ -0,0 +1,76 @@
+package gorabbitadmin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/the-lanky/go-utils/gorabbit"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestRegister(t *testing.T) {
+	m := gorabbit.NewMemory(gorabbit.GoRabbitConfiguration{}, nil)
+	if err := m.Listen(context.Background(), gorabbit.GoRabbitConsumerMessages{
+		"orders.eu/1": {
+			"order.created": {Consume: func(context.Context, amqp091.Delivery) error { return nil }},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown(context.Background())
+
+	app := fiber.New()
+	Register(app.Group("/admin/rabbit"), m)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantState  string
+	}{
+		{name: "stats", method: fiber.MethodGet, path: "/admin/rabbit/", wantStatus: fiber.StatusOK},
+		{name: "queue", method: fiber.MethodGet, path: "/admin/rabbit/orders.eu%2F1", wantStatus: fiber.StatusOK, wantState: "running"},
+		{name: "unknown queue", method: fiber.MethodGet, path: "/admin/rabbit/users", wantStatus: fiber.StatusNotFound},
+		{name: "pause", method: fiber.MethodPost, path: "/admin/rabbit/orders.eu%2F1/pause", wantStatus: fiber.StatusOK, wantState: "paused"},
+		{name: "resume", method: fiber.MethodPost, path: "/admin/rabbit/orders.eu%2F1/resume", wantStatus: fiber.StatusOK, wantState: "running"},
+		{name: "cancel", method: fiber.MethodPost, path: "/admin/rabbit/orders.eu%2F1/cancel", wantStatus: fiber.StatusOK, wantState: "cancelled"},
+		{name: "resume cancelled", method: fiber.MethodPost, path: "/admin/rabbit/orders.eu%2F1/resume", wantStatus: fiber.StatusConflict},
+		{name: "pause unknown queue", method: fiber.MethodPost, path: "/admin/rabbit/users/pause", wantStatus: fiber.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := app.Test(httptest.NewRequest(tt.method, tt.path, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantState == "" {
+				return
+			}
+
+			// The state is encoded by name.
+			var body struct {
+				Data struct {
+					Queue string `json:"queue"`
+					State string `json:"state"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Data.Queue != "orders.eu/1" || body.Data.State != tt.wantState {
+				t.Fatalf("stats = %+v, want the state %s", body.Data, tt.wantState)
+			}
+		})
+	}
+}
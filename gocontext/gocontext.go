@@ -0,0 +1,169 @@
+package gocontext
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"maps"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// HeaderRequestID is the HTTP header that carries the request id.
+	HeaderRequestID = "X-Request-ID"
+	// HeaderTraceParent is the W3C trace context header that carries the trace and parent span ids.
+	HeaderTraceParent = "traceparent"
+	// HeaderTraceState is the W3C trace context header that carries the vendor specific trace data.
+	HeaderTraceState = "tracestate"
+)
+
+// key is the type of the context keys of the package.
+type key int
+
+const (
+	requestIDKey key = iota
+	traceParentKey
+	traceStateKey
+	userIDKey
+	appIDKey
+	headersKey
+)
+
+// WithRequestID is a function that returns a copy of the context with the request id.
+// It takes a context and a string and returns a context.
+// This is used by the request id middleware and by the consumers.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID is a function that returns the request id of the context.
+// It takes a context and returns a string.
+// It returns an empty string when the context has no request id.
+func RequestID(ctx context.Context) string {
+	return value(ctx, requestIDKey)
+}
+
+// WithTraceParent is a function that returns a copy of the context with the W3C traceparent.
+// It takes a context and a string and returns a context.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey, traceParent)
+}
+
+// TraceParent is a function that returns the W3C traceparent of the context.
+// It takes a context and returns a string.
+// It returns an empty string when the context has no traceparent.
+func TraceParent(ctx context.Context) string {
+	return value(ctx, traceParentKey)
+}
+
+// WithTraceState is a function that returns a copy of the context with the W3C tracestate.
+// It takes a context and a string and returns a context.
+func WithTraceState(ctx context.Context, traceState string) context.Context {
+	return context.WithValue(ctx, traceStateKey, traceState)
+}
+
+// TraceState is a function that returns the W3C tracestate of the context.
+// It takes a context and returns a string.
+// It returns an empty string when the context has no tracestate.
+func TraceState(ctx context.Context) string {
+	return value(ctx, traceStateKey)
+}
+
+// TraceID is a function that returns the trace id of the traceparent of the context.
+// It takes a context and returns a string.
+// It returns an empty string when the context has no valid traceparent.
+func TraceID(ctx context.Context) string {
+	parts := strings.Split(TraceParent(ctx), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	return parts[1]
+}
+
+// NewTraceParent is a function that creates a new W3C traceparent.
+// It takes nothing and returns a string.
+// This is used to start a trace when the request does not carry one.
+func NewTraceParent() string {
+	traceId := make([]byte, 16)
+	spanId := make([]byte, 8)
+	_, _ = rand.Read(traceId)
+	_, _ = rand.Read(spanId)
+	return "00-" + hex.EncodeToString(traceId) + "-" + hex.EncodeToString(spanId) + "-01"
+}
+
+// WithUserID is a function that returns a copy of the context with the user id.
+// It takes a context and a string and returns a context.
+func WithUserID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, userIDKey, id)
+}
+
+// UserID is a function that returns the user id of the context.
+// It takes a context and returns a string.
+// It returns an empty string when the context has no user id.
+func UserID(ctx context.Context) string {
+	return value(ctx, userIDKey)
+}
+
+// WithAppID is a function that returns a copy of the context with the app id.
+// It takes a context and a string and returns a context.
+func WithAppID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, appIDKey, id)
+}
+
+// AppID is a function that returns the app id of the context.
+// It takes a context and returns a string.
+// It returns an empty string when the context has no app id.
+func AppID(ctx context.Context) string {
+	return value(ctx, appIDKey)
+}
+
+// WithHeader is a function that returns a copy of the context with a custom header.
+// It takes a context, a key, and a value and returns a context.
+// The custom headers are propagated as is, e.g. as message headers by gorabbit.
+func WithHeader(ctx context.Context, key string, val string) context.Context {
+	headers := Headers(ctx)
+	if headers == nil {
+		headers = make(map[string]string, 1)
+	}
+	headers[key] = val
+	return context.WithValue(ctx, headersKey, headers)
+}
+
+// Headers is a function that returns the custom headers of the context.
+// It takes a context and returns a map of string to string.
+// The returned map is a copy and can be modified.
+func Headers(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(headersKey).(map[string]string)
+	return maps.Clone(headers)
+}
+
+// Fields is a function that returns the values of the context as log fields.
+// It takes a context and returns a logrus.Fields.
+// This is used to trace a request across the HTTP and queue hops in the logs.
+//
+//	log.WithFields(gocontext.Fields(ctx)).Info("order created")
+func Fields(ctx context.Context) logrus.Fields {
+	fields := logrus.Fields{}
+	if v := RequestID(ctx); v != "" {
+		fields["requestId"] = v
+	}
+	if v := TraceID(ctx); v != "" {
+		fields["traceId"] = v
+	}
+	if v := UserID(ctx); v != "" {
+		fields["userId"] = v
+	}
+	if v := AppID(ctx); v != "" {
+		fields["appId"] = v
+	}
+	return fields
+}
+
+// value is a function that returns a string value of the context.
+// It takes a context and a key and returns a string.
+func value(ctx context.Context, k key) string {
+	v, _ := ctx.Value(k).(string)
+	return v
+}
@@ -0,0 +1,85 @@
+package gocontext
+
+import (
+	"context"
+	"regexp"
+	"testing"
+)
+
+func TestValues(t *testing.T) {
+	ctx := context.Background()
+	ctx = WithRequestID(ctx, "req-1")
+	ctx = WithTraceParent(ctx, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx = WithTraceState(ctx, "vendor=1")
+	ctx = WithUserID(ctx, "user-1")
+	ctx = WithAppID(ctx, "shop")
+
+	if v := RequestID(ctx); v != "req-1" {
+		t.Errorf("RequestID = %q", v)
+	}
+	if v := TraceState(ctx); v != "vendor=1" {
+		t.Errorf("TraceState = %q", v)
+	}
+	if v := TraceID(ctx); v != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("TraceID = %q", v)
+	}
+
+	fields := Fields(ctx)
+	if fields["requestId"] != "req-1" || fields["traceId"] != "0af7651916cd43dd8448eb211c80319c" ||
+		fields["userId"] != "user-1" || fields["appId"] != "shop" {
+		t.Errorf("Fields = %v", fields)
+	}
+
+	if fields := Fields(context.Background()); len(fields) != 0 {
+		t.Errorf("Fields of an empty context = %v", fields)
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	tests := []struct {
+		traceParent string
+		want        string
+	}{
+		{traceParent: "", want: ""},
+		{traceParent: "invalid", want: ""},
+		{traceParent: "00-short-b7ad6b7169203331-01", want: ""},
+		{traceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", want: "0af7651916cd43dd8448eb211c80319c"},
+	}
+
+	for _, tt := range tests {
+		if got := TraceID(WithTraceParent(context.Background(), tt.traceParent)); got != tt.want {
+			t.Errorf("TraceID(%q) = %q, want %q", tt.traceParent, got, tt.want)
+		}
+	}
+}
+
+func TestNewTraceParent(t *testing.T) {
+	re := regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`)
+
+	a, b := NewTraceParent(), NewTraceParent()
+	if !re.MatchString(a) {
+		t.Fatalf("NewTraceParent = %q", a)
+	}
+	if a == b {
+		t.Fatal("NewTraceParent returned the same trace twice")
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	parent := WithHeader(context.Background(), "x-tenant", "acme")
+	child := WithHeader(parent, "x-region", "eu")
+
+	if h := Headers(child); len(h) != 2 || h["x-tenant"] != "acme" || h["x-region"] != "eu" {
+		t.Errorf("Headers = %v", h)
+	}
+	// The headers of the parent context are not changed.
+	if h := Headers(parent); len(h) != 1 {
+		t.Errorf("Headers of the parent = %v", h)
+	}
+
+	// The returned map is a copy.
+	Headers(parent)["x-tenant"] = "other"
+	if h := Headers(parent); h["x-tenant"] != "acme" {
+		t.Errorf("Headers = %v", h)
+	}
+}
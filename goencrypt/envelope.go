@@ -0,0 +1,153 @@
+package goencrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// The envelope is laid out as:
+//
+//	magic (2) | version (1) | key id length (1) | key id | nonce (12) | ciphertext and tag
+//
+// The header up to the nonce is authenticated as additional data.
+const (
+	envelopeVersion = 1
+	keyInfo         = "goencrypt aes-256-gcm v1"
+)
+
+var envelopeMagic = []byte{'G', 'E'}
+
+var (
+	// ErrInvalidEnvelope is returned when the data is not a valid envelope.
+	ErrInvalidEnvelope = errors.New("goencrypt: invalid envelope")
+	// ErrUnknownKey is returned when the envelope was sealed with a key that is not configured.
+	ErrUnknownKey = errors.New("goencrypt: unknown key id")
+	// ErrSecretTooShort is returned when the secret is shorter than 24 characters.
+	ErrSecretTooShort = errors.New("goencrypt: secret must be at least 24 characters long")
+	// ErrInvalidKey is returned when a key has no secret or an id longer than 255 bytes.
+	ErrInvalidKey = errors.New("goencrypt: invalid key")
+	// ErrInvalidLegacyIV is returned when the legacy IV is not 16 bytes long.
+	ErrInvalidLegacyIV = errors.New("goencrypt: legacy iv must be 16 bytes long")
+)
+
+// sealer is a struct that seals and opens envelopes with a single key.
+type sealer struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// newSealer is a function that creates a new sealer.
+// It takes a Key and returns a pointer to a sealer and an error.
+// The AES-256 key is derived from the secret with HKDF-SHA256.
+func newSealer(k Key) (*sealer, error) {
+	if trimSpace(k.Secret) == "" {
+		return nil, ErrInvalidKey
+	}
+
+	id := k.ID
+	if id == "" {
+		id = KeyID(k.Secret)
+	}
+	if len(id) > 255 {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := hkdf.Key(sha256.New, []byte(k.Secret), nil, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(blk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealer{id: id, aead: aead}, nil
+}
+
+// KeyID is a function that returns the default key id of a secret.
+// It takes a string and returns a string.
+// The id is a short hash of the secret, so every process with the same secret agrees on it.
+func KeyID(secret string) string {
+	sum := sha256.Sum256([]byte("goencrypt key id:" + secret))
+	return hex.EncodeToString(sum[:4])
+}
+
+// header is a function that builds the header of an envelope.
+// It takes nothing and returns a byte slice.
+func (s *sealer) header() []byte {
+	h := make([]byte, 0, len(envelopeMagic)+2+len(s.id))
+	h = append(h, envelopeMagic...)
+	h = append(h, envelopeVersion, byte(len(s.id)))
+	return append(h, s.id...)
+}
+
+// seal is a function that seals the data with a random nonce.
+// It takes a byte slice and returns the envelope and an error.
+func (s *sealer) seal(plain []byte) ([]byte, error) {
+	h := s.header()
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	env := make([]byte, 0, len(h)+len(nonce)+len(plain)+s.aead.Overhead())
+	env = append(env, h...)
+	env = append(env, nonce...)
+
+	return s.aead.Seal(env, nonce, plain, h), nil
+}
+
+// open is a function that opens an envelope.
+// It takes a byte slice and returns a byte slice and an error.
+func (s *sealer) open(env []byte) ([]byte, error) {
+	hl := len(envelopeMagic) + 2 + len(s.id)
+	ns := s.aead.NonceSize()
+	if len(env) < hl+ns+s.aead.Overhead() {
+		return nil, ErrInvalidEnvelope
+	}
+
+	plain, err := s.aead.Open(nil, env[hl:hl+ns], env[hl+ns:], env[:hl])
+	if err != nil {
+		return nil, ErrInvalidEnvelope
+	}
+
+	return plain, nil
+}
+
+// isEnvelope is a function that reports whether the data starts like an envelope.
+// It takes a byte slice and returns a bool.
+// Base64 text never starts like an envelope, so this tells binary envelopes apart from encoded data.
+func isEnvelope(data []byte) bool {
+	return len(data) > len(envelopeMagic)+1 &&
+		bytes.HasPrefix(data, envelopeMagic) &&
+		data[len(envelopeMagic)] == envelopeVersion
+}
+
+// envelopeKeyID is a function that returns the key id of an envelope.
+// It takes a byte slice and returns a string and an error.
+func envelopeKeyID(env []byte) (string, error) {
+	if !isEnvelope(env) {
+		return "", ErrInvalidEnvelope
+	}
+
+	n := int(env[len(envelopeMagic)+1])
+	start := len(envelopeMagic) + 2
+	if len(env) < start+n {
+		return "", ErrInvalidEnvelope
+	}
+
+	return string(env[start : start+n]), nil
+}
@@ -3,7 +3,6 @@ package goencrypt
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"strings"
@@ -11,10 +10,73 @@ import (
 
 // GoEncrypt is an interface that defines the methods for encrypting and decrypting data.
 // It is used to encrypt and decrypt data in a secure way.
+// Encrypt and Seal produce a versioned envelope (AES-GCM with a random nonce per message and a key id),
+// so any process holding the key can decrypt it. Decrypt, DecryptFromBytes, and Open also accept the
+// legacy CFB format when its IV is set, see SetLegacyIV and DisableLegacyDecryption.
 type GoEncrypt interface {
 	Encrypt(data any) (encrypted string, err error)
 	Decrypt(data string) (result []byte, err error)
 	DecryptFromBytes(data []byte) (result []byte, err error)
+	Seal(plain []byte) (envelope []byte, err error)
+	Open(data []byte) (result []byte, err error)
+}
+
+// Key is a struct that represents an encryption key.
+// It is used to keep decrypting envelopes sealed with a previous key after a key rotation.
+// ID defaults to an id derived from the secret.
+type Key struct {
+	ID     string `mapstructure:"id"`
+	Secret string `mapstructure:"secret"`
+}
+
+// config represents the configuration for the GoEncrypt.
+type config struct {
+	keyId          string
+	previousKeys   []Key
+	legacyIV       []byte
+	legacyDisabled bool
+}
+
+// Option is a function that configures the GoEncrypt.
+// It takes a pointer to a config and returns nothing.
+// This is used to chain the options together.
+type Option func(*config)
+
+// SetKeyID sets the id of the key used to seal new envelopes.
+// It takes a string and returns an Option.
+// This is used to name the key instead of deriving the id from the secret.
+func SetKeyID(id string) Option {
+	return func(c *config) {
+		c.keyId = id
+	}
+}
+
+// SetPreviousKeys sets the keys that are still accepted for decryption.
+// It takes a list of Key and returns an Option.
+// This is used to rotate the secret without losing the messages in flight.
+func SetPreviousKeys(keys ...Key) Option {
+	return func(c *config) {
+		c.previousKeys = append(c.previousKeys, keys...)
+	}
+}
+
+// SetLegacyIV sets the IV used to decrypt the legacy CFB format.
+// It takes a byte slice of 16 bytes and returns an Option.
+// The legacy format never carried its IV, so the legacy data can only be decrypted with the IV it was
+// encrypted with. Without this option the legacy format is not decrypted at all.
+func SetLegacyIV(iv []byte) Option {
+	return func(c *config) {
+		c.legacyIV = iv
+	}
+}
+
+// DisableLegacyDecryption disables the decryption of the legacy CFB format.
+// It takes nothing and returns an Option.
+// This is used once the migration window is over.
+func DisableLegacyDecryption() Option {
+	return func(c *config) {
+		c.legacyDisabled = true
+	}
 }
 
 // cryp is a struct that implements the GoEncrypt interface.
@@ -22,22 +84,63 @@ type GoEncrypt interface {
 type cryp struct {
 	secret string
 	size   []byte
+	key    *sealer
+	keys   map[string]*sealer
+	legacy bool
 }
 
 // New is a function that creates a new GoEncrypt instance.
-// It takes a secret string and returns a GoEncrypt instance.
-func New(secret string) GoEncrypt {
-	bb := make([]byte, 16)
-	rand.Read(bb)
+// It takes a secret string and a list of Option and returns a GoEncrypt instance.
+// It panics when the secret or a previous key is invalid, see Init for the error-returning version.
+func New(secret string, opts ...Option) GoEncrypt {
+	c, err := Init(secret, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Init is a function that creates a new GoEncrypt instance.
+// It takes a secret string and a list of Option and returns a GoEncrypt instance and an error.
+// It returns ErrSecretTooShort when the secret is shorter than 24 characters, ErrInvalidLegacyIV when the
+// legacy IV is not 16 bytes long, and an error wrapping ErrInvalidKey when a key is invalid.
+func Init(secret string, opts ...Option) (GoEncrypt, error) {
+	cnf := &config{}
+	for _, opt := range opts {
+		opt(cnf)
+	}
 
 	if len(trimSpace(secret)) < 24 {
-		panic("secret for invt_cryptography must be at least 16 characters long")
+		return nil, ErrSecretTooShort
+	}
+
+	if len(cnf.legacyIV) > 0 && len(cnf.legacyIV) != aes.BlockSize {
+		return nil, ErrInvalidLegacyIV
+	}
+
+	key, err := newSealer(Key{ID: cnf.keyId, Secret: secret})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*sealer{key.id: key}
+	for _, k := range cnf.previousKeys {
+		s, err := newSealer(k)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keys[s.id]; !ok {
+			keys[s.id] = s
+		}
 	}
 
 	return &cryp{
 		secret: secret,
-		size:   bb,
-	}
+		size:   append([]byte(nil), cnf.legacyIV...),
+		key:    key,
+		keys:   keys,
+		legacy: len(cnf.legacyIV) > 0 && !cnf.legacyDisabled,
+	}, nil
 }
 
 // toBytes is a function that converts the data to bytes.
@@ -66,6 +169,7 @@ func (c *cryp) getBlock() (cipher.Block, error) {
 
 // Encrypt is a function that encrypts the data.
 // It takes a data any and returns a string and an error.
+// The data is encoded to JSON and sealed, and the envelope is encoded to base64.
 func (c *cryp) Encrypt(data any) (string, error) {
 	var (
 		enc string
@@ -77,16 +181,12 @@ func (c *cryp) Encrypt(data any) (string, error) {
 		return enc, err
 	}
 
-	blk, err := c.getBlock()
+	env, err := c.Seal(b)
 	if err != nil {
 		return enc, err
 	}
 
-	cfb := cipher.NewCFBEncrypter(blk, c.size)
-	cText := make([]byte, len(b))
-	cfb.XORKeyStream(cText, b)
-
-	enc = c.encode(cText)
+	enc = c.encode(env)
 
 	return enc, nil
 }
@@ -94,32 +194,84 @@ func (c *cryp) Encrypt(data any) (string, error) {
 // Decrypt is a function that decrypts the data.
 // It takes a string and returns a byte slice and an error.
 func (c *cryp) Decrypt(data string) ([]byte, error) {
-	blk, err := c.getBlock()
+	return c.Open([]byte(data))
+}
+
+// DecryptFromBytes is a function that decrypts the data from a byte slice.
+// It takes a byte slice and returns a byte slice and an error.
+func (c *cryp) DecryptFromBytes(data []byte) ([]byte, error) {
+	dec, err := c.Open(data)
 	if err != nil {
 		return nil, err
 	}
 
-	cText, err := c.decode(data)
+	return dec, nil
+}
+
+// Seal is a function that seals the data in an envelope.
+// It takes a byte slice and returns the binary envelope and an error.
+// This is used when the envelope does not need to be text, e.g. as a message body.
+func (c *cryp) Seal(plain []byte) ([]byte, error) {
+	return c.key.seal(plain)
+}
+
+// Open is a function that decrypts an envelope or legacy data.
+// It takes a byte slice and returns a byte slice and an error.
+// The data can be a binary envelope, a base64 envelope, or base64 legacy CFB data.
+// An envelope that fails the authentication or names an unknown key is an error, and is never
+// decrypted as legacy data, so tampered data or data sealed with another secret is not returned.
+func (c *cryp) Open(data []byte) ([]byte, error) {
+	if isEnvelope(data) {
+		return c.openEnvelope(data)
+	}
+
+	raw, err := c.decode(strings.TrimSpace(string(data)))
 	if err != nil {
 		return nil, err
 	}
 
-	cfb := cipher.NewCFBDecrypter(blk, c.size)
-	plain := make([]byte, len(cText))
-	cfb.XORKeyStream(plain, cText)
+	if isEnvelope(raw) {
+		return c.openEnvelope(raw)
+	}
 
-	return plain, nil
+	if !c.legacy {
+		return nil, ErrInvalidEnvelope
+	}
+
+	return c.decryptLegacy(raw)
 }
 
-// DecryptFromBytes is a function that decrypts the data from a byte slice.
+// openEnvelope is a function that opens an envelope with the key it names.
 // It takes a byte slice and returns a byte slice and an error.
-func (c *cryp) DecryptFromBytes(data []byte) ([]byte, error) {
-	dec, err := c.Decrypt(string(data))
+// It returns ErrUnknownKey when the key id is not one of the configured keys.
+func (c *cryp) openEnvelope(env []byte) ([]byte, error) {
+	id, err := envelopeKeyID(env)
 	if err != nil {
 		return nil, err
 	}
 
-	return dec, nil
+	key, ok := c.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key.open(env)
+}
+
+// decryptLegacy is a function that decrypts the legacy CFB format.
+// It takes a byte slice and returns a byte slice and an error.
+// This is used during the migration window only.
+func (c *cryp) decryptLegacy(cText []byte) ([]byte, error) {
+	blk, err := c.getBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	cfb := cipher.NewCFBDecrypter(blk, c.size)
+	plain := make([]byte, len(cText))
+	cfb.XORKeyStream(plain, cText)
+
+	return plain, nil
 }
 
 // trimSpace is a function that trims the space from the data.
@@ -0,0 +1,173 @@
+package goencrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+const (
+	testSecret      = "0123456789abcdef01234567"
+	testOtherSecret = "76543210fedcba9876543210"
+)
+
+var testIV = []byte("fedcba9876543210")
+
+// legacyEncrypt encrypts the data in the legacy CFB format, as the previous versions did.
+func legacyEncrypt(t *testing.T, secret string, iv []byte, plain []byte) string {
+	t.Helper()
+
+	blk, err := aes.NewCipher([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make([]byte, len(plain))
+	cipher.NewCFBEncrypter(blk, iv).XORKeyStream(out, plain)
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+func TestSealOpen(t *testing.T) {
+	plain := []byte(`{"id":1}`)
+
+	a := New(testSecret)
+	b := New(testSecret)
+
+	env, err := a.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := b.Open(env)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("Open = %q, want %q", got, plain)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	enc, err := New(testSecret).Encrypt(map[string]int{"id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := New(testSecret).Decrypt(enc)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(got) != `{"id":1}` {
+		t.Fatalf("Decrypt = %q", got)
+	}
+}
+
+func TestOpenTamperedEnvelope(t *testing.T) {
+	// The legacy decryption is enabled, so a fallback would return garbage instead of an error.
+	c := New(testSecret, SetLegacyIV(testIV))
+
+	env, err := c.Seal([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(env)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "binary", data: tampered},
+		{name: "base64", data: []byte(base64.StdEncoding.EncodeToString(tampered))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Open(tt.data)
+			if !errors.Is(err, ErrInvalidEnvelope) {
+				t.Fatalf("Open = %q, %v, want ErrInvalidEnvelope", got, err)
+			}
+		})
+	}
+}
+
+func TestOpenUnknownKey(t *testing.T) {
+	env, err := New(testOtherSecret).Encrypt("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := New(testSecret, SetLegacyIV(testIV)).Decrypt(env)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Decrypt = %q, %v, want ErrUnknownKey", got, err)
+	}
+}
+
+func TestOpenPreviousKey(t *testing.T) {
+	env, err := New(testOtherSecret).Seal([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := New(testSecret, SetPreviousKeys(Key{Secret: testOtherSecret})).Open(env)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("Open = %q", got)
+	}
+}
+
+func TestOpenLegacy(t *testing.T) {
+	plain := []byte(`"a legacy message longer than a block"`)
+	legacy := legacyEncrypt(t, testSecret, testIV, plain)
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		want    []byte
+		wantErr error
+	}{
+		{name: "with iv", opts: []Option{SetLegacyIV(testIV)}, want: plain},
+		{name: "without iv", wantErr: ErrInvalidEnvelope},
+		{name: "disabled", opts: []Option{SetLegacyIV(testIV), DisableLegacyDecryption()}, wantErr: ErrInvalidEnvelope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(testSecret, tt.opts...).Decrypt(legacy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decrypt error = %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("Decrypt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		opts    []Option
+		wantErr error
+	}{
+		{name: "valid", secret: testSecret},
+		{name: "short secret", secret: "short", wantErr: ErrSecretTooShort},
+		{name: "invalid legacy iv", secret: testSecret, opts: []Option{SetLegacyIV([]byte("short"))}, wantErr: ErrInvalidLegacyIV},
+		{name: "invalid previous key", secret: testSecret, opts: []Option{SetPreviousKeys(Key{ID: "old"})}, wantErr: ErrInvalidKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Init(tt.secret, tt.opts...); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Init error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -28,6 +28,30 @@ type GoMinioConfig struct {
 	UseSSL      bool   `mapstructure:"useSSL"`
 }
 
+var (
+	// ErrInvalidConfig is returned by Connect when the Minio client cannot be created from the configuration.
+	ErrInvalidConfig = errors.New("invalid minio configuration")
+	// ErrConnectionFailed is returned by Connect when Minio cannot be reached.
+	ErrConnectionFailed = errors.New("error connecting to minio")
+	// ErrBucketNotFound is returned when the bucket of the project does not exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+)
+
+// GoMinio is an interface that defines the methods for the GoMinio client.
+type GoMinio interface {
+	UploadFile(
+		ctx context.Context,
+		location string,
+		key string,
+		fileBuffer []byte,
+		fileSize int64,
+		contentType string,
+	) (*UploadFileResponse, error)
+	GetFile(ctx context.Context, location string, key string) (*os.File, error)
+	ExtractFileInfo(f *os.File) (*ExtractedFileInfo, error)
+	MExtractFileInfo(f *multipart.FileHeader) (*ExtractedFileInfo, error)
+}
+
 // gminio is a struct that represents the GoMinio client.
 type gminio struct {
 	client *minio.Client
@@ -39,26 +63,67 @@ type gminio struct {
 var GoMinioClient *gminio
 
 // InitGoMinio is a function that initializes the GoMinio client.
+// It exits the process when the client cannot be created, see Connect for the error-returning version.
 func InitGoMinio(config GoMinioConfig, log *logrus.Logger) {
 	if log == nil {
-		gologger.New(
-			gologger.SetServiceName("GoMinio"),
-		)
-		log = gologger.Logger
+		log = newLogger()
 	}
-	c, er := minio.New(config.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
-		Secure: config.UseSSL,
-	})
+	g, er := newClient(config, log)
 	if er != nil {
 		log.Fatalf("[GoMinio] Error initializing Minio client: %+v", er)
 	}
 	log.Infof("[GoMinio] Minio client initialized successfully...")
-	GoMinioClient = &gminio{
+	GoMinioClient = g
+}
+
+// Connect is a function that creates a new GoMinio client.
+// It takes a context, a GoMinioConfig, and a pointer to a logrus.Logger and returns a GoMinio and an error.
+// When a project name is configured, its bucket is checked with the context, so an unreachable Minio returns
+// an error wrapping ErrConnectionFailed and a missing bucket returns ErrBucketNotFound. Unlike InitGoMinio,
+// it does not set GoMinioClient.
+func Connect(ctx context.Context, config GoMinioConfig, log *logrus.Logger) (GoMinio, error) {
+	if log == nil {
+		log = newLogger()
+	}
+	g, err := newClient(config, log)
+	if err != nil {
+		return nil, err
+	}
+	if config.ProjectName != "" {
+		exists, err := g.client.BucketExists(ctx, config.ProjectName)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+		}
+		if !exists {
+			return nil, ErrBucketNotFound
+		}
+	}
+	log.Infof("[GoMinio] Minio client connected successfully...")
+	return g, nil
+}
+
+// newClient is a function that creates the Minio client.
+func newClient(config GoMinioConfig, log *logrus.Logger) (*gminio, error) {
+	c, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	return &gminio{
 		client: c,
 		log:    log,
 		conf:   config,
-	}
+	}, nil
+}
+
+// newLogger is a function that creates the default logger of the GoMinio client.
+func newLogger() *logrus.Logger {
+	gologger.New(
+		gologger.SetServiceName("GoMinio"),
+	)
+	return gologger.Logger
 }
 
 // UploadFileResponse is a struct that represents the response from the UploadFile function.
@@ -94,7 +159,7 @@ func (g *gminio) UploadFile(
 	}
 	if !exists {
 		g.log.Errorf("[GoMinio] Bucket not found: %+v", bucket)
-		return nil, errors.New("bucket not found")
+		return nil, ErrBucketNotFound
 	}
 	dest := fmt.Sprintf("%s/%s", location, key)
 	info, err := g.client.PutObject(
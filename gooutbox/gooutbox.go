@@ -0,0 +1,607 @@
+package gooutbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/the-lanky/go-utils/gocontext"
+	"github.com/the-lanky/go-utils/gologger"
+	"github.com/the-lanky/go-utils/gorabbit"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// GoOutboxStatusPending is the status of a message waiting to be published.
+	GoOutboxStatusPending = "pending"
+	// GoOutboxStatusProcessing is the status of a message claimed by a relay until its lease expires.
+	GoOutboxStatusProcessing = "processing"
+	// GoOutboxStatusSent is the status of a message confirmed by the broker.
+	GoOutboxStatusSent = "sent"
+	// GoOutboxStatusFailed is the status of a message that used up its attempts.
+	GoOutboxStatusFailed = "failed"
+
+	defaultTable           = "outbox_messages"
+	defaultBatchSize       = 100
+	defaultPollInterval    = 1 * time.Second
+	defaultLeaseTimeout    = 5 * time.Minute
+	defaultMaxAttempts     = 10
+	defaultRetryDelay      = 1 * time.Second
+	defaultMaxRetryDelay   = 5 * time.Minute
+	defaultRetention       = 7 * 24 * time.Hour
+	defaultCleanupInterval = 1 * time.Hour
+)
+
+var (
+	// ErrUnsupportedOption is returned by Enqueue when the publisher option sets a field the outbox cannot keep.
+	ErrUnsupportedOption = errors.New("publisher option not supported by the outbox")
+)
+
+// GoOutboxMessage is a struct that represents a row of the outbox table.
+// It is used to keep an outgoing message in the same transaction as the data it belongs to.
+// MessageId is the message id of the published message, so the consumers can deduplicate it. It is the
+// message id of the publisher option, or ID when the option has none.
+// ClaimId is the claim of the relay publishing the message, and AvailableAt is the end of its lease then.
+// Attempts counts the claims of the message, so a message whose relay keeps stopping is failed as well.
+type GoOutboxMessage struct {
+	ID            string            `gorm:"primaryKey;type:uuid"`
+	MessageId     string            `gorm:"not null;index"`
+	Exchange      string            `gorm:"not null;default:''"`
+	Topic         string            `gorm:"not null"`
+	Payload       []byte            `gorm:"type:jsonb;not null"`
+	RequestId     string            `gorm:"not null;default:''"`
+	TraceParent   string            `gorm:"not null;default:''"`
+	TraceState    string            `gorm:"not null;default:''"`
+	UserId        string            `gorm:"not null;default:''"`
+	AppId         string            `gorm:"not null;default:''"`
+	Headers       map[string]string `gorm:"type:jsonb;serializer:json"`
+	Transient     bool              `gorm:"not null;default:false"`
+	Priority      uint8             `gorm:"not null;default:0"`
+	Expiration    time.Duration     `gorm:"not null;default:0"`
+	Timestamp     *time.Time
+	Type          string    `gorm:"not null;default:''"`
+	ReplyTo       string    `gorm:"not null;default:''"`
+	CorrelationId string    `gorm:"not null;default:''"`
+	Status        string    `gorm:"not null;default:'pending';index:idx_outbox_status_available,priority:1"`
+	ClaimId       string    `gorm:"not null;default:''"`
+	Attempts      int       `gorm:"not null;default:0"`
+	LastError     string    `gorm:"not null;default:''"`
+	AvailableAt   time.Time `gorm:"not null;index:idx_outbox_status_available,priority:2"`
+	CreatedAt     time.Time `gorm:"not null"`
+	SentAt        *time.Time
+}
+
+// GoOutboxConfiguration is a struct that represents the configuration for the GoOutbox.
+// It is used to configure the outbox table and the relay.
+// LeaseTimeout is how long a relay keeps the messages it claimed, 5 minutes by default. The messages of a relay
+// that stopped before marking them are claimed again once the lease expires.
+type GoOutboxConfiguration struct {
+	Table           string        `mapstructure:"table"`
+	BatchSize       int           `mapstructure:"batchSize"`
+	PollInterval    time.Duration `mapstructure:"pollInterval"`
+	LeaseTimeout    time.Duration `mapstructure:"leaseTimeout"`
+	MaxAttempts     int           `mapstructure:"maxAttempts"`
+	RetryDelay      time.Duration `mapstructure:"retryDelay"`
+	MaxRetryDelay   time.Duration `mapstructure:"maxRetryDelay"`
+	Retention       time.Duration `mapstructure:"retention"`
+	CleanupInterval time.Duration `mapstructure:"cleanupInterval"`
+}
+
+// GoOutbox is an interface that defines the methods for the outbox.
+// It is used to enqueue messages inside a transaction and to relay them to the broker.
+type GoOutbox interface {
+	Migrate(ctx context.Context) error
+	Enqueue(ctx context.Context, tx *gorm.DB, opt gorabbit.GoRabbitPublisherOption) (string, error)
+	Run(ctx context.Context) error
+}
+
+// outbox is a struct that implements the GoOutbox interface.
+type outbox struct {
+	db        *gorm.DB
+	publisher gorabbit.Publisher
+	conf      GoOutboxConfiguration
+	log       *logrus.Logger
+}
+
+// New is a function that creates a new GoOutbox.
+// It takes a pointer to a gorm.DB, a gorabbit.Publisher, a GoOutboxConfiguration, and a pointer to a logrus.Logger
+// and returns a GoOutbox.
+func New(
+	db *gorm.DB,
+	publisher gorabbit.Publisher,
+	conf GoOutboxConfiguration,
+	log *logrus.Logger,
+) GoOutbox {
+	if log == nil {
+		gologger.New(
+			gologger.SetServiceName("GoOutbox"),
+		)
+		log = gologger.Logger
+	}
+
+	if strings.TrimSpace(conf.Table) == "" {
+		conf.Table = defaultTable
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultBatchSize
+	}
+	if conf.PollInterval <= 0 {
+		conf.PollInterval = defaultPollInterval
+	}
+	if conf.LeaseTimeout <= 0 {
+		conf.LeaseTimeout = defaultLeaseTimeout
+	}
+	if conf.MaxAttempts <= 0 {
+		conf.MaxAttempts = defaultMaxAttempts
+	}
+	if conf.RetryDelay <= 0 {
+		conf.RetryDelay = defaultRetryDelay
+	}
+	if conf.MaxRetryDelay <= 0 {
+		conf.MaxRetryDelay = defaultMaxRetryDelay
+	}
+	if conf.Retention <= 0 {
+		conf.Retention = defaultRetention
+	}
+	if conf.CleanupInterval <= 0 {
+		conf.CleanupInterval = defaultCleanupInterval
+	}
+
+	return &outbox{
+		db:        db,
+		publisher: publisher,
+		conf:      conf,
+		log:       log,
+	}
+}
+
+// Migrate is a function that creates or updates the outbox table.
+// It takes a context and returns an error.
+func (o *outbox) Migrate(ctx context.Context) error {
+	if err := o.db.WithContext(ctx).Table(o.conf.Table).AutoMigrate(&GoOutboxMessage{}); err != nil {
+		return fmt.Errorf("error migrating outbox table: '%s': %w", o.conf.Table, err)
+	}
+	return nil
+}
+
+// Enqueue is a function that writes an outgoing message to the outbox table.
+// It takes a context, the transaction, and a gorabbit.GoRabbitPublisherOption and returns the message id and an error.
+// The message is only published by the relay once the transaction is committed. The request id, the trace context,
+// the user id, the app id, and the custom headers of the context are kept with the message, as are the AMQP
+// properties of the option. The message is stored and published as JSON, so an error wrapping
+// ErrUnsupportedOption is returned when the option sets another content type or RawHeaders. A delayed message is
+// kept in the outbox until due, instead of in a delay queue of the broker, and the relay retries the failed
+// messages itself, so Retries and RetryDelay are ignored. When the publisher is a gorabbit.SchemaValidator, the
+// message is validated with the schema of its topic as the relay will publish it, and an error wrapping
+// gorabbit.ErrSchemaValidation is returned, failing the transaction, when it does not validate.
+//
+//	err := gopostgres.GoTransaction("").WithTransaction(ctx, func(tx *gorm.DB) error {
+//		if err := tx.Create(&order).Error; err != nil {
+//			return err
+//		}
+//		_, err := outbox.Enqueue(ctx, tx, gorabbit.GoRabbitPublisherOption{Topic: "order.created", Message: order})
+//		return err
+//	})
+func (o *outbox) Enqueue(ctx context.Context, tx *gorm.DB, opt gorabbit.GoRabbitPublisherOption) (string, error) {
+	msg, err := record(ctx, opt, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	if v, ok := o.publisher.(gorabbit.SchemaValidator); ok {
+		if err := v.ValidateSchema(msg.option()); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.WithContext(ctx).Table(o.conf.Table).Create(&msg).Error; err != nil {
+		return "", fmt.Errorf("error writing outbox message: %w", err)
+	}
+
+	return msg.MessageId, nil
+}
+
+// record is a function that builds the outbox row of a message.
+// It takes a context, a gorabbit.GoRabbitPublisherOption, and the enqueue time and returns a GoOutboxMessage
+// and an error.
+func record(ctx context.Context, opt gorabbit.GoRabbitPublisherOption, now time.Time) (GoOutboxMessage, error) {
+	if strings.TrimSpace(opt.Topic) == "" {
+		return GoOutboxMessage{}, gorabbit.ErrTopicRequired
+	}
+
+	if opt.Message == nil {
+		return GoOutboxMessage{}, gorabbit.ErrMessageRequired
+	}
+
+	if ct, _, _ := strings.Cut(opt.ContentType, ";"); ct != "" &&
+		!strings.EqualFold(strings.TrimSpace(ct), gorabbit.ContentTypeJSON) {
+		return GoOutboxMessage{}, fmt.Errorf("%w: content type '%s'", ErrUnsupportedOption, opt.ContentType)
+	}
+
+	if len(opt.RawHeaders) > 0 {
+		return GoOutboxMessage{}, fmt.Errorf("%w: raw headers", ErrUnsupportedOption)
+	}
+
+	payload, err := json.Marshal(opt.Message)
+	if err != nil {
+		return GoOutboxMessage{}, fmt.Errorf("error encoding outbox message: %w", err)
+	}
+
+	id := uuid.New().String()
+
+	messageId := opt.MessageId
+	if messageId == "" {
+		messageId = id
+	}
+
+	headers := gocontext.Headers(ctx)
+	for k, v := range opt.Headers {
+		if headers == nil {
+			headers = make(map[string]string, len(opt.Headers))
+		}
+		headers[k] = v
+	}
+
+	userId := opt.UserId
+	if userId == "" {
+		userId = gocontext.UserID(ctx)
+	}
+
+	appId := opt.AppId
+	if appId == "" {
+		appId = gocontext.AppID(ctx)
+	}
+
+	ts := opt.Timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+
+	availableAt := now.Add(opt.Delay)
+	if !opt.DelayUntil.IsZero() {
+		availableAt = opt.DelayUntil
+	}
+	if availableAt.Before(now) {
+		availableAt = now
+	}
+
+	return GoOutboxMessage{
+		ID:            id,
+		MessageId:     messageId,
+		Exchange:      opt.Exchange,
+		Topic:         opt.Topic,
+		Payload:       payload,
+		RequestId:     gocontext.RequestID(ctx),
+		TraceParent:   gocontext.TraceParent(ctx),
+		TraceState:    gocontext.TraceState(ctx),
+		UserId:        userId,
+		AppId:         appId,
+		Headers:       headers,
+		Transient:     opt.Transient,
+		Priority:      opt.Priority,
+		Expiration:    opt.Expiration,
+		Timestamp:     &ts,
+		Type:          opt.Type,
+		ReplyTo:       opt.ReplyTo,
+		CorrelationId: opt.CorrelationId,
+		Status:        GoOutboxStatusPending,
+		AvailableAt:   availableAt,
+		CreatedAt:     now,
+	}, nil
+}
+
+// option is a function that builds the publisher option of an outbox message.
+// It takes nothing and returns a gorabbit.GoRabbitPublisherOption.
+// The request id and the trace context of the message are sent in its headers, as the messages of a batch
+// share the context of the relay.
+func (m GoOutboxMessage) option() gorabbit.GoRabbitPublisherOption {
+	headers := make(map[string]string, len(m.Headers)+3)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	if m.RequestId != "" {
+		headers[gorabbit.HeaderRequestID] = m.RequestId
+	}
+	if m.TraceParent != "" {
+		headers[gorabbit.HeaderTraceParent] = m.TraceParent
+	}
+	if m.TraceState != "" {
+		headers[gorabbit.HeaderTraceState] = m.TraceState
+	}
+
+	var ts time.Time
+	if m.Timestamp != nil {
+		ts = *m.Timestamp
+	}
+
+	return gorabbit.GoRabbitPublisherOption{
+		MessageId:     m.MessageId,
+		Exchange:      m.Exchange,
+		Topic:         m.Topic,
+		Message:       json.RawMessage(m.Payload),
+		ContentType:   gorabbit.ContentTypeJSON,
+		UserId:        m.UserId,
+		AppId:         m.AppId,
+		Headers:       headers,
+		Transient:     m.Transient,
+		Priority:      m.Priority,
+		Expiration:    m.Expiration,
+		Timestamp:     ts,
+		Type:          m.Type,
+		ReplyTo:       m.ReplyTo,
+		CorrelationId: m.CorrelationId,
+	}
+}
+
+// Run is a function that relays the outbox messages to the broker until the context is done.
+// It takes a context and returns nil once the context is done.
+// A batch of pending messages is claimed in a short transaction with SELECT ... FOR UPDATE SKIP LOCKED, so
+// several relays can run at once, and is published as a batch outside of the transaction. The messages are
+// marked as sent once the broker confirmed them. A failed message is retried with an exponential backoff and
+// marked as failed once its attempts are used up. The sent messages are deleted after the retention.
+func (o *outbox) Run(ctx context.Context) error {
+	o.log.Info("[GoOutbox] Relay started")
+
+	poll := time.NewTicker(o.conf.PollInterval)
+	defer poll.Stop()
+
+	cleanup := time.NewTicker(o.conf.CleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		for {
+			n, err := o.relay(ctx)
+			if err != nil && ctx.Err() == nil {
+				o.log.Errorf("[GoOutbox] Error relaying messages: %s", err.Error())
+			}
+			// A full batch means more messages are probably waiting.
+			if err != nil || n < o.conf.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			o.log.Info("[GoOutbox] Relay stopped")
+			return nil
+		case <-cleanup.C:
+			if err := o.cleanup(ctx); err != nil && ctx.Err() == nil {
+				o.log.Errorf("[GoOutbox] Error cleaning up messages: %s", err.Error())
+			}
+		case <-poll.C:
+		}
+	}
+}
+
+// relay is a function that publishes a batch of pending messages.
+// It takes a context and returns the number of messages of the batch and an error.
+// No row is locked while the messages are published, as the batch is claimed beforehand.
+func (o *outbox) relay(ctx context.Context) (int, error) {
+	claim := uuid.New().String()
+
+	msgs, err := o.claim(ctx, claim)
+	if err != nil || len(msgs) == 0 {
+		return 0, err
+	}
+
+	opts := make([]gorabbit.GoRabbitPublisherOption, len(msgs))
+	for i, msg := range msgs {
+		opts[i] = msg.option()
+	}
+
+	// The failed messages are retried from their results, so the batch error is not needed.
+	results, _ := o.publisher.PublishBatch(ctx, opts)
+
+	var (
+		sent []string
+		errs []error
+	)
+	for i, msg := range msgs {
+		var perr error
+		if i < len(results) {
+			perr = results[i].Err
+		} else {
+			perr = gorabbit.ErrPublishBatchFailed
+		}
+
+		if perr == nil {
+			sent = append(sent, msg.ID)
+			continue
+		}
+		if err := o.fail(ctx, claim, msg, perr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := o.sent(ctx, claim, sent); err != nil {
+		errs = append(errs, err)
+	}
+
+	return len(msgs), errors.Join(errs...)
+}
+
+// claim is a function that claims a batch of messages for a relay.
+// It takes a context and the claim id and returns the claimed messages and an error.
+// The pending messages that are due and the messages whose lease expired are claimed, and are leased to the
+// relay for the lease timeout. Every claim counts as an attempt, so a message whose lease expired after its
+// last attempt is marked as failed instead.
+func (o *outbox) claim(ctx context.Context, claim string) ([]GoOutboxMessage, error) {
+	var msgs []GoOutboxMessage
+
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var rows []GoOutboxMessage
+		if err := tx.Table(o.conf.Table).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where(
+				"status IN ? AND available_at <= ?",
+				[]string{GoOutboxStatusPending, GoOutboxStatusProcessing},
+				now,
+			).
+			Order("created_at").
+			Limit(o.conf.BatchSize).
+			Find(&rows).Error; err != nil {
+			return err
+		}
+
+		claimed, exhausted := o.split(rows)
+
+		if len(exhausted) > 0 {
+			ids := make([]string, len(exhausted))
+			for i, msg := range exhausted {
+				ids[i] = msg.ID
+				o.log.Errorf(
+					"[GoOutbox] [%s] Message failed after %d attempt(s): lease expired",
+					msg.MessageId,
+					msg.Attempts,
+				)
+			}
+
+			if err := tx.Table(o.conf.Table).Where("id IN ?", ids).Updates(map[string]any{
+				"status":     GoOutboxStatusFailed,
+				"last_error": "lease expired",
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(claimed) == 0 {
+			return nil
+		}
+
+		ids := make([]string, len(claimed))
+		for i := range claimed {
+			ids[i] = claimed[i].ID
+			claimed[i].Attempts++
+		}
+
+		if err := tx.Table(o.conf.Table).Where("id IN ?", ids).Updates(map[string]any{
+			"status":       GoOutboxStatusProcessing,
+			"claim_id":     claim,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"available_at": now.Add(o.conf.LeaseTimeout),
+		}).Error; err != nil {
+			return err
+		}
+
+		msgs = claimed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// split is a function that splits the rows selected by a claim.
+// It takes the rows and returns the rows to claim and the rows that used up their attempts.
+// A row still processing was claimed by a relay that stopped before marking it, so that claim was an attempt.
+func (o *outbox) split(rows []GoOutboxMessage) ([]GoOutboxMessage, []GoOutboxMessage) {
+	var claimed, exhausted []GoOutboxMessage
+	for _, msg := range rows {
+		if msg.Status == GoOutboxStatusProcessing && msg.Attempts >= o.conf.MaxAttempts {
+			exhausted = append(exhausted, msg)
+			continue
+		}
+		claimed = append(claimed, msg)
+	}
+	return claimed, exhausted
+}
+
+// sent is a function that marks the messages of a claim as sent.
+// It takes a context, the claim id, and the message ids and returns an error.
+// A message claimed again by another relay once the lease expired is left to that relay.
+func (o *outbox) sent(ctx context.Context, claim string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := o.db.WithContext(ctx).
+		Table(o.conf.Table).
+		Where("id IN ? AND claim_id = ? AND status = ?", ids, claim, GoOutboxStatusProcessing).
+		Updates(map[string]any{
+			"status":     GoOutboxStatusSent,
+			"sent_at":    time.Now(),
+			"last_error": "",
+		}).Error; err != nil {
+		return fmt.Errorf("error updating outbox messages: %w", err)
+	}
+
+	return nil
+}
+
+// fail is a function that records a failed attempt of a message of a claim.
+// It takes a context, the claim id, the GoOutboxMessage, and the publish error and returns an error.
+// The message is pending again after its backoff, or failed once its attempts are used up. The attempt was
+// counted when the message was claimed.
+func (o *outbox) fail(ctx context.Context, claim string, msg GoOutboxMessage, err error) error {
+	updates := map[string]any{
+		"last_error": err.Error(),
+	}
+
+	if msg.Attempts >= o.conf.MaxAttempts {
+		updates["status"] = GoOutboxStatusFailed
+		o.log.Errorf(
+			"[GoOutbox] [%s] Message failed after %d attempt(s): %s",
+			msg.MessageId,
+			msg.Attempts,
+			err.Error(),
+		)
+	} else {
+		updates["status"] = GoOutboxStatusPending
+		updates["available_at"] = time.Now().Add(o.backoff(msg.Attempts))
+		o.log.Errorf(
+			"[GoOutbox] [%d] [%s] Error publishing message: %s",
+			msg.Attempts,
+			msg.MessageId,
+			err.Error(),
+		)
+	}
+
+	if uerr := o.db.WithContext(ctx).
+		Table(o.conf.Table).
+		Where("id = ? AND claim_id = ? AND status = ?", msg.ID, claim, GoOutboxStatusProcessing).
+		Updates(updates).Error; uerr != nil {
+		return fmt.Errorf("error updating outbox message: '%s': %w", msg.MessageId, uerr)
+	}
+
+	return nil
+}
+
+// backoff is a function that returns the delay before the next attempt of a message.
+// It takes the number of attempts and returns a time.Duration.
+func (o *outbox) backoff(attempts int) time.Duration {
+	d := o.conf.RetryDelay
+	for i := 1; i < attempts && d < o.conf.MaxRetryDelay; i++ {
+		d *= 2
+	}
+	return min(d, o.conf.MaxRetryDelay)
+}
+
+// cleanup is a function that deletes the sent messages older than the retention.
+// It takes a context and returns an error.
+// The failed messages are kept, so they can be inspected and requeued by hand.
+func (o *outbox) cleanup(ctx context.Context) error {
+	res := o.db.WithContext(ctx).
+		Table(o.conf.Table).
+		Where("status = ? AND sent_at < ?", GoOutboxStatusSent, time.Now().Add(-o.conf.Retention)).
+		Delete(&GoOutboxMessage{})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected > 0 {
+		o.log.Infof("[GoOutbox] %d sent message(s) cleaned up", res.RowsAffected)
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package gooutbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/the-lanky/go-utils/gocontext"
+	"github.com/the-lanky/go-utils/gorabbit"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestRecordOption(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	ts := now.Add(-time.Minute)
+
+	ctx := gocontext.WithRequestID(context.Background(), "req-1")
+	ctx = gocontext.WithTraceParent(ctx, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	opt := gorabbit.GoRabbitPublisherOption{
+		MessageId:     "5f0c6b8e-4b7a-4d43-9b1e-7d8f0d6f2a11",
+		Exchange:      "orders",
+		Topic:         "order.created",
+		Message:       map[string]int{"id": 1},
+		ContentType:   "application/json; charset=utf-8",
+		UserId:        "user-1",
+		AppId:         "shop",
+		Headers:       map[string]string{"x-tenant": "acme"},
+		Transient:     true,
+		Priority:      5,
+		Expiration:    30 * time.Second,
+		Timestamp:     ts,
+		Type:          "OrderCreated",
+		ReplyTo:       "replies",
+		CorrelationId: "corr-1",
+		Delay:         time.Minute,
+	}
+
+	msg, err := record(ctx, opt, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Status != GoOutboxStatusPending {
+		t.Errorf("Status = %q, want %q", msg.Status, GoOutboxStatusPending)
+	}
+	if !msg.AvailableAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("AvailableAt = %v, want %v", msg.AvailableAt, now.Add(time.Minute))
+	}
+
+	got := msg.option()
+	want := gorabbit.GoRabbitPublisherOption{
+		MessageId:   opt.MessageId,
+		Exchange:    opt.Exchange,
+		Topic:       opt.Topic,
+		Message:     json.RawMessage(`{"id":1}`),
+		ContentType: gorabbit.ContentTypeJSON,
+		UserId:      opt.UserId,
+		AppId:       opt.AppId,
+		Headers: map[string]string{
+			"x-tenant":                 "acme",
+			gorabbit.HeaderRequestID:   "req-1",
+			gorabbit.HeaderTraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		},
+		Transient:     opt.Transient,
+		Priority:      opt.Priority,
+		Expiration:    opt.Expiration,
+		Timestamp:     ts,
+		Type:          opt.Type,
+		ReplyTo:       opt.ReplyTo,
+		CorrelationId: opt.CorrelationId,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("option =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestRecordDelayUntil(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	msg, err := record(context.Background(), gorabbit.GoRabbitPublisherOption{
+		Topic:      "order.created",
+		Message:    "hello",
+		DelayUntil: now.Add(-time.Hour),
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A message due in the past is available right away.
+	if !msg.AvailableAt.Equal(now) {
+		t.Errorf("AvailableAt = %v, want %v", msg.AvailableAt, now)
+	}
+	if msg.Timestamp == nil || !msg.Timestamp.Equal(now) {
+		t.Errorf("Timestamp = %v, want %v", msg.Timestamp, now)
+	}
+}
+
+func TestRecordMessageId(t *testing.T) {
+	tests := []struct {
+		name      string
+		messageId string
+	}{
+		{name: "uuid", messageId: "5f0c6b8e-4b7a-4d43-9b1e-7d8f0d6f2a11"},
+		{name: "not a uuid", messageId: "order-123"},
+		{name: "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := record(context.Background(), gorabbit.GoRabbitPublisherOption{
+				MessageId: tt.messageId,
+				Topic:     "order.created",
+				Message:   "hello",
+			}, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The primary key is always generated, so any message id fits the uuid column.
+			if _, err := uuid.Parse(msg.ID); err != nil {
+				t.Fatalf("ID = %q is not a uuid: %v", msg.ID, err)
+			}
+
+			want := tt.messageId
+			if want == "" {
+				want = msg.ID
+			}
+			if msg.MessageId != want {
+				t.Errorf("MessageId = %q, want %q", msg.MessageId, want)
+			}
+			if got := msg.option().MessageId; got != want {
+				t.Errorf("option MessageId = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRecordErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     gorabbit.GoRabbitPublisherOption
+		wantErr error
+	}{
+		{
+			name:    "no topic",
+			opt:     gorabbit.GoRabbitPublisherOption{Message: "hello"},
+			wantErr: gorabbit.ErrTopicRequired,
+		},
+		{
+			name:    "no message",
+			opt:     gorabbit.GoRabbitPublisherOption{Topic: "order.created"},
+			wantErr: gorabbit.ErrMessageRequired,
+		},
+		{
+			name: "content type",
+			opt: gorabbit.GoRabbitPublisherOption{
+				Topic:       "order.created",
+				Message:     "hello",
+				ContentType: gorabbit.ContentTypeMsgPack,
+			},
+			wantErr: ErrUnsupportedOption,
+		},
+		{
+			name: "raw headers",
+			opt: gorabbit.GoRabbitPublisherOption{
+				Topic:      "order.created",
+				Message:    "hello",
+				RawHeaders: amqp091.Table{"x-count": int32(1)},
+			},
+			wantErr: ErrUnsupportedOption,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := record(context.Background(), tt.opt, time.Now()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("record error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	o := New(nil, nil, GoOutboxConfiguration{MaxAttempts: 3}, nil).(*outbox)
+
+	rows := []GoOutboxMessage{
+		{ID: "pending", Status: GoOutboxStatusPending, Attempts: 2},
+		{ID: "lease expired", Status: GoOutboxStatusProcessing, Attempts: 2},
+		{ID: "exhausted", Status: GoOutboxStatusProcessing, Attempts: 3},
+	}
+
+	claimed, exhausted := o.split(rows)
+
+	ids := func(msgs []GoOutboxMessage) []string {
+		out := make([]string, len(msgs))
+		for i, msg := range msgs {
+			out[i] = msg.ID
+		}
+		return out
+	}
+	if got := ids(claimed); !reflect.DeepEqual(got, []string{"pending", "lease expired"}) {
+		t.Errorf("claimed = %v", got)
+	}
+	if got := ids(exhausted); !reflect.DeepEqual(got, []string{"exhausted"}) {
+		t.Errorf("exhausted = %v", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	o := New(nil, nil, GoOutboxConfiguration{
+		RetryDelay:    time.Second,
+		MaxRetryDelay: 10 * time.Second,
+	}, nil).(*outbox)
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 4, want: 8 * time.Second},
+		{attempts: 5, want: 10 * time.Second},
+		{attempts: 50, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := o.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestEnqueueSchemaValidation(t *testing.T) {
+	type order struct {
+		Id    string `json:"id"`
+		Total int    `json:"total"`
+	}
+
+	r := gorabbit.NewMemory(gorabbit.GoRabbitConfiguration{}, nil)
+	if err := r.RegisterSchema("order.created", gorabbit.GoRabbitSchema{
+		Version:    1,
+		JSONSchema: []byte(`{"type": "object", "required": ["id", "total"]}`),
+		Type:       order{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	o := New(nil, r.Publisher(), GoOutboxConfiguration{}, nil)
+
+	// The message is rejected before the transaction is used.
+	_, err := o.Enqueue(context.Background(), nil, gorabbit.GoRabbitPublisherOption{
+		Topic:   "order.created",
+		Message: map[string]any{"id": "a"},
+	})
+	if !errors.Is(err, gorabbit.ErrSchemaValidation) {
+		t.Fatalf("Enqueue error = %v, want gorabbit.ErrSchemaValidation", err)
+	}
+
+	// The relay publishes the stored payload, which must pass the Type check of the schema.
+	msg, err := record(context.Background(), gorabbit.GoRabbitPublisherOption{
+		Topic:   "order.created",
+		Message: order{Id: "a", Total: 1},
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ValidateSchema(msg.option()); err != nil {
+		t.Fatalf("ValidateSchema = %v", err)
+	}
+}
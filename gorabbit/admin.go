@@ -0,0 +1,388 @@
+package gorabbit
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// latencySamples is the number of the latest handler latencies the percentiles are computed from.
+const latencySamples = 1024
+
+// GoRabbitQueueState is a type that represents the consumer state of a queue.
+// It is used to pause, resume, and cancel the consumers of a queue at runtime.
+type GoRabbitQueueState int
+
+const (
+	// GoRabbitQueueRunning is the state of a queue that is consumed.
+	GoRabbitQueueRunning GoRabbitQueueState = iota
+	// GoRabbitQueuePaused is the state of a queue whose consumers are cancelled until it is resumed.
+	GoRabbitQueuePaused
+	// GoRabbitQueueCancelled is the state of a queue whose consumers are cancelled for good.
+	GoRabbitQueueCancelled
+)
+
+// String is a function that returns the name of the queue state.
+// It takes nothing and returns a string.
+// This is used to print the queue state.
+func (s GoRabbitQueueState) String() string {
+	switch s {
+	case GoRabbitQueueRunning:
+		return "running"
+	case GoRabbitQueuePaused:
+		return "paused"
+	case GoRabbitQueueCancelled:
+		return "cancelled"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText is a function that returns the name of the queue state.
+// It takes nothing and returns a []byte and an error.
+// This is used to encode the queue state by name in JSON.
+func (s GoRabbitQueueState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// GoRabbitQueueStats is a struct that represents the counters of a queue since it was first consumed.
+// Delivered counts the messages handed to the handlers, Succeeded the acked ones, and Failed the retried
+// and dead-lettered ones. Redelivered counts the messages the broker delivered again, e.g. after a
+// consumer was lost, and InFlight the messages being handled.
+type GoRabbitQueueStats struct {
+	Queue       string             `json:"queue"`
+	State       GoRabbitQueueState `json:"state"`
+	Delivered   uint64             `json:"delivered"`
+	Succeeded   uint64             `json:"succeeded"`
+	Failed      uint64             `json:"failed"`
+	Redelivered uint64             `json:"redelivered"`
+	InFlight    int64              `json:"inFlight"`
+	Latency     GoRabbitLatency    `json:"latency"`
+}
+
+// GoRabbitLatency is a struct that represents the handler latency percentiles of a queue.
+// They are computed from the latest 1024 messages, and are encoded in milliseconds in JSON.
+type GoRabbitLatency struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// MarshalJSON is a function that encodes the latency percentiles in milliseconds.
+// It takes nothing and returns a []byte and an error.
+// This is used to implement the json.Marshaler interface.
+func (l GoRabbitLatency) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		P50 float64 `json:"p50Ms"`
+		P90 float64 `json:"p90Ms"`
+		P99 float64 `json:"p99Ms"`
+		Max float64 `json:"maxMs"`
+	}{
+		P50: milliseconds(l.P50),
+		P90: milliseconds(l.P90),
+		P99: milliseconds(l.P99),
+		Max: milliseconds(l.Max),
+	})
+}
+
+// queueRegistry is a struct that represents the consumed queues.
+// It is used to keep the state and the counters of the queues across the listeners and the reconnections.
+type queueRegistry struct {
+	mu     sync.Mutex
+	queues map[string]*queueControl
+}
+
+// queueControl is a struct that represents the state and the counters of a queue.
+type queueControl struct {
+	state GoRabbitQueueState
+
+	delivered   atomic.Uint64
+	succeeded   atomic.Uint64
+	failed      atomic.Uint64
+	redelivered atomic.Uint64
+	inflight    atomic.Int64
+
+	latMu sync.Mutex
+	lat   []time.Duration
+	next  int
+}
+
+// register is a function that registers a consumed queue.
+// It takes a queue name and returns nothing.
+// A cancelled queue is running again once consumed by a new Listen call, while a paused one stays paused.
+func (q *queueRegistry) register(queue string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c := q.control(queue)
+	if c.state == GoRabbitQueueCancelled {
+		c.state = GoRabbitQueueRunning
+	}
+}
+
+// control is a function that returns the control of a queue, creating it when needed.
+// It takes a queue name and returns a pointer to a queueControl.
+// The lock must be held.
+func (q *queueRegistry) control(queue string) *queueControl {
+	if q.queues == nil {
+		q.queues = make(map[string]*queueControl)
+	}
+
+	c, ok := q.queues[queue]
+	if !ok {
+		c = &queueControl{}
+		q.queues[queue] = c
+	}
+	return c
+}
+
+// stats is a function that returns the counters of a queue.
+// It takes a queue name and returns a pointer to a queueControl.
+// This is used to count the deliveries of the queue.
+func (q *queueRegistry) stats(queue string) *queueControl {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.control(queue)
+}
+
+// state is a function that returns the state of a queue.
+// It takes a queue name and returns a GoRabbitQueueState.
+// A queue that was never registered is running.
+func (q *queueRegistry) state(queue string) GoRabbitQueueState {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if c, ok := q.queues[queue]; ok {
+		return c.state
+	}
+	return GoRabbitQueueRunning
+}
+
+// running is a function that reports whether a queue is to be consumed.
+// It takes a queue name and returns a bool.
+func (q *queueRegistry) running(queue string) bool {
+	return q.state(queue) == GoRabbitQueueRunning
+}
+
+// transition is a function that changes the state of a queue.
+// It takes a queue name and a GoRabbitQueueState and returns a bool and an error.
+// It returns false when the queue is already in the state, ErrQueueNotFound when the queue is not consumed,
+// and ErrQueueCancelled when a cancelled queue is paused or resumed.
+func (q *queueRegistry) transition(queue string, state GoRabbitQueueState) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, ok := q.queues[queue]
+	if !ok {
+		return false, ErrQueueNotFound
+	}
+
+	switch {
+	case c.state == state:
+		return false, nil
+	case c.state == GoRabbitQueueCancelled:
+		return false, ErrQueueCancelled
+	}
+
+	c.state = state
+	return true, nil
+}
+
+// snapshot is a function that returns the counters of the queues.
+// It takes nothing and returns a list of GoRabbitQueueStats sorted by queue name.
+func (q *queueRegistry) snapshot() []GoRabbitQueueStats {
+	q.mu.Lock()
+	out := make([]GoRabbitQueueStats, 0, len(q.queues))
+	controls := make(map[string]*queueControl, len(q.queues))
+	for name, c := range q.queues {
+		out = append(out, GoRabbitQueueStats{Queue: name, State: c.state})
+		controls[name] = c
+	}
+	q.mu.Unlock()
+
+	for i := range out {
+		c := controls[out[i].Queue]
+		out[i].Delivered = c.delivered.Load()
+		out[i].Succeeded = c.succeeded.Load()
+		out[i].Failed = c.failed.Load()
+		out[i].Redelivered = c.redelivered.Load()
+		out[i].InFlight = c.inflight.Load()
+		out[i].Latency = c.latency()
+	}
+
+	slices.SortFunc(out, func(a, b GoRabbitQueueStats) int {
+		return strings.Compare(a.Queue, b.Queue)
+	})
+
+	return out
+}
+
+// begin is a function that counts a message handed to a handler.
+// It takes an amqp091.Delivery and returns a function counting the outcome of the message.
+// The returned function takes whether the message was acked, and records the handler latency.
+func (c *queueControl) begin(msg amqp091.Delivery) func(succeeded bool) {
+	start := time.Now()
+
+	c.delivered.Add(1)
+	c.inflight.Add(1)
+	if msg.Redelivered {
+		c.redelivered.Add(1)
+	}
+
+	return func(succeeded bool) {
+		c.inflight.Add(-1)
+		if succeeded {
+			c.succeeded.Add(1)
+		} else {
+			c.failed.Add(1)
+		}
+		c.observe(time.Since(start))
+	}
+}
+
+// observe is a function that records a handler latency.
+// It takes a time.Duration and returns nothing.
+// The latest latencySamples latencies are kept.
+func (c *queueControl) observe(d time.Duration) {
+	c.latMu.Lock()
+	defer c.latMu.Unlock()
+
+	if len(c.lat) < latencySamples {
+		c.lat = append(c.lat, d)
+		return
+	}
+	c.lat[c.next] = d
+	c.next = (c.next + 1) % latencySamples
+}
+
+// latency is a function that computes the handler latency percentiles.
+// It takes nothing and returns a GoRabbitLatency.
+func (c *queueControl) latency() GoRabbitLatency {
+	c.latMu.Lock()
+	samples := slices.Clone(c.lat)
+	c.latMu.Unlock()
+
+	if len(samples) == 0 {
+		return GoRabbitLatency{}
+	}
+	slices.Sort(samples)
+
+	percentile := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(samples)))) - 1
+		return samples[max(i, 0)]
+	}
+
+	return GoRabbitLatency{
+		P50: percentile(0.50),
+		P90: percentile(0.90),
+		P99: percentile(0.99),
+		Max: samples[len(samples)-1],
+	}
+}
+
+// milliseconds is a function that converts a time.Duration to milliseconds.
+// It takes a time.Duration and returns a float64.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Pause is a function that pauses the consumers of a queue.
+// It takes a queue name and returns an error.
+// The consumers are cancelled, so the broker keeps the new messages in the queue, and the messages being
+// handled are finished. The queue stays paused across reconnections until Resume is called.
+// It returns ErrQueueNotFound when the queue is not consumed, and ErrQueueCancelled when it is cancelled.
+func (r *rbt) Pause(queue string) error {
+	changed, err := r.queues.transition(queue, GoRabbitQueuePaused)
+	if err != nil || !changed {
+		return err
+	}
+
+	r.cancelConsumers(queue)
+	r.log.Infof("[GoRabbit] Queue '%s' paused", queue)
+
+	return nil
+}
+
+// Resume is a function that resumes the consumers of a paused queue.
+// It takes a queue name and returns an error.
+// It returns ErrQueueNotFound when the queue is not consumed, and ErrQueueCancelled when it is cancelled.
+func (r *rbt) Resume(queue string) error {
+	changed, err := r.queues.transition(queue, GoRabbitQueueRunning)
+	if err != nil || !changed {
+		return err
+	}
+
+	r.mu.RLock()
+	closing := r.closing
+	conn := r.acn
+	listeners := append([]*listener(nil), r.listeners...)
+	r.mu.RUnlock()
+
+	if closing {
+		return ErrNotConnected
+	}
+
+	for _, l := range listeners {
+		topics, ok := l.consumers[queue]
+		// A consumer still draining is started again by itself once done.
+		if !ok || l.isStopped() || l.consuming(queue) {
+			continue
+		}
+		if err := r.consume(conn, l, queue, topics); err != nil {
+			r.log.Errorf("[GoRabbit] Error resuming queue '%s': %s", queue, err.Error())
+			return err
+		}
+	}
+
+	r.log.Infof("[GoRabbit] Queue '%s' resumed", queue)
+
+	return nil
+}
+
+// Cancel is a function that cancels the consumers of a queue for good.
+// It takes a queue name and returns an error.
+// The consumers are cancelled as by Pause, but the queue cannot be resumed, and is only consumed again by a
+// new Listen call. It returns ErrQueueNotFound when the queue is not consumed.
+func (r *rbt) Cancel(queue string) error {
+	changed, err := r.queues.transition(queue, GoRabbitQueueCancelled)
+	if err != nil || !changed {
+		return err
+	}
+
+	r.cancelConsumers(queue)
+	r.log.Infof("[GoRabbit] Queue '%s' cancelled", queue)
+
+	return nil
+}
+
+// Stats is a function that returns the counters of the consumed queues.
+// It takes nothing and returns a list of GoRabbitQueueStats sorted by queue name.
+// This is used to see what each queue is doing, e.g. in an ops dashboard.
+func (r *rbt) Stats() []GoRabbitQueueStats {
+	return r.queues.snapshot()
+}
+
+// cancelConsumers is a function that cancels the consumers of a queue.
+// It takes a queue name and returns nothing.
+// The consumers are not started again, as the queue is not running.
+func (r *rbt) cancelConsumers(queue string) {
+	r.mu.RLock()
+	listeners := append([]*listener(nil), r.listeners...)
+	r.mu.RUnlock()
+
+	for _, l := range listeners {
+		for _, s := range l.subscriptions(queue) {
+			if err := s.ch.Cancel(s.tag, false); err != nil {
+				r.log.Errorf("[GoRabbit] Error cancelling consumer of queue '%s': %s", queue, err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package gorabbit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestQueueStateJSON(t *testing.T) {
+	b, err := json.Marshal(GoRabbitQueueStats{Queue: "orders", State: GoRabbitQueuePaused, Latency: GoRabbitLatency{P50: 1500 * time.Microsecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		State   string             `json:"state"`
+		Latency map[string]float64 `json:"latency"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.State != "paused" || got.Latency["p50Ms"] != 1.5 {
+		t.Fatalf("stats = %s", b)
+	}
+
+	if s := GoRabbitQueueState(9).String(); s != "unknown" {
+		t.Errorf("String of an unknown state = %q", s)
+	}
+}
+
+func TestQueueTransition(t *testing.T) {
+	r := &rbt{log: testLogger()}
+	r.queues.register("orders")
+
+	tests := []struct {
+		name    string
+		control func(string) error
+		queue   string
+		want    GoRabbitQueueState
+		wantErr error
+	}{
+		{name: "unknown queue", control: r.Pause, queue: "users", want: GoRabbitQueueRunning, wantErr: ErrQueueNotFound},
+		{name: "pause", control: r.Pause, queue: "orders", want: GoRabbitQueuePaused},
+		{name: "pause again", control: r.Pause, queue: "orders", want: GoRabbitQueuePaused},
+		{name: "resume", control: r.Resume, queue: "orders", want: GoRabbitQueueRunning},
+		{name: "cancel", control: r.Cancel, queue: "orders", want: GoRabbitQueueCancelled},
+		{name: "resume cancelled", control: r.Resume, queue: "orders", want: GoRabbitQueueCancelled, wantErr: ErrQueueCancelled},
+		{name: "pause cancelled", control: r.Pause, queue: "orders", want: GoRabbitQueueCancelled, wantErr: ErrQueueCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.control(tt.queue); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got := r.queues.state(tt.queue); got != tt.want {
+				t.Fatalf("state = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A cancelled queue runs again once consumed by a new Listen call.
+	r.queues.register("orders")
+	if got := r.queues.state("orders"); got != GoRabbitQueueRunning {
+		t.Fatalf("state after register = %v, want running", got)
+	}
+}
+
+func TestQueueLatency(t *testing.T) {
+	c := &queueControl{}
+	if got := c.latency(); got != (GoRabbitLatency{}) {
+		t.Fatalf("latency without samples = %+v", got)
+	}
+
+	for i := 1; i <= 100; i++ {
+		c.observe(time.Duration(i) * time.Millisecond)
+	}
+	want := GoRabbitLatency{P50: 50 * time.Millisecond, P90: 90 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if got := c.latency(); got != want {
+		t.Fatalf("latency = %+v, want %+v", got, want)
+	}
+
+	// Only the latest samples are kept.
+	for range latencySamples {
+		c.observe(time.Millisecond)
+	}
+	if got := c.latency(); got.Max != time.Millisecond {
+		t.Fatalf("max latency = %v, want the old samples dropped", got.Max)
+	}
+}
+
+func TestMemoryPause(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(_ context.Context, msg amqp091.Delivery) error {
+				if string(msg.Body) == `"fail"` {
+					return errors.New("boom")
+				}
+				return nil
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Pause("orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The messages of a paused queue are kept until it is resumed.
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+	if n := len(m.Queued("orders")); n != 1 {
+		t.Fatalf("queued %d messages while paused, want 1", n)
+	}
+
+	if err := m.Resume("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "fail"}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	stats := m.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	// The failed message is delivered once and retried twice before it is dead-lettered.
+	s := stats[0]
+	if s.Queue != "orders" || s.State != GoRabbitQueueRunning || s.Delivered != 4 || s.Succeeded != 1 || s.Failed != 3 || s.InFlight != 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+
+	if err := m.Cancel("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Resume("orders"); !errors.Is(err, ErrQueueCancelled) {
+		t.Fatalf("Resume of a cancelled queue = %v, want ErrQueueCancelled", err)
+	}
+	if err := m.Pause("users"); !errors.Is(err, ErrQueueNotFound) {
+		t.Fatalf("Pause of an unknown queue = %v, want ErrQueueNotFound", err)
+	}
+}
@@ -0,0 +1,203 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultBatchWindow is the number of messages of a batch waiting for their confirmation at once.
+const defaultBatchWindow = 1000
+
+// GoRabbitPublishResult is a struct that represents the result of a message of a batch.
+// Err is nil when the broker confirmed the message, and a *GoRabbitPublishError otherwise.
+type GoRabbitPublishResult struct {
+	MessageId string
+	Topic     string
+	Err       error
+}
+
+// batchMessage is a struct that represents a message of a batch waiting for its confirmation.
+type batchMessage struct {
+	seq      uint64
+	w        *pendingConfirm
+	exchange string
+	delay    time.Duration
+}
+
+// PublishBatch is a function that publishes a batch of messages.
+// It takes a context and a list of GoRabbitPublisherOption and returns a list of GoRabbitPublishResult and an error.
+// The messages are published one after the other on a dedicated confirm-mode channel of the publisher
+// connection without waiting for each confirmation, and the confirmations are waited for together, so a
+// large batch takes a fraction of the time of as many Publish calls. The results are in the order of the options, and an error wrapping
+// ErrPublishBatchFailed is returned when some of the messages were not published.
+// The messages are not retried, so the failed ones can be published again from their results.
+//
+//	results, err := r.Publisher().PublishBatch(ctx, opts)
+//	if errors.Is(err, gorabbit.ErrPublishBatchFailed) {
+//		for _, res := range results {
+//			if res.Err != nil {
+//				// ...
+//			}
+//		}
+//	}
+func (r *rbt) PublishBatch(
+	ctx context.Context,
+	opts []GoRabbitPublisherOption,
+) ([]GoRabbitPublishResult, error) {
+	results := make([]GoRabbitPublishResult, len(opts))
+	if len(opts) == 0 {
+		return results, nil
+	}
+
+	r.log.Infof("[GoRabbit] Publishing batch of %d messages...", len(opts))
+
+	conn := r.pub.connection()
+
+	var (
+		cc  *confirmChannel
+		err error
+	)
+	if conn == nil || conn.IsClosed() {
+		err = ErrNotConnected
+	} else {
+		cc, err = openConfirmChannel(conn)
+	}
+	if err != nil {
+		r.log.Errorf("[GoRabbit] Error publishing batch: %s", err.Error())
+		for i, opt := range opts {
+			results[i] = GoRabbitPublishResult{
+				MessageId: opt.MessageId,
+				Topic:     opt.Topic,
+				Err:       &GoRabbitPublishError{MessageId: opt.MessageId, Topic: opt.Topic, Err: err},
+			}
+		}
+		return results, fmt.Errorf("%w: %d/%d: %w", ErrPublishBatchFailed, len(opts), len(opts), err)
+	}
+	defer cc.ch.Close()
+
+	pending := make([]*batchMessage, len(opts))
+
+	for i, opt := range opts {
+		// The oldest message is waited for first, so no more than the window is in flight.
+		if i >= defaultBatchWindow {
+			r.confirmBatch(ctx, cc, &results[i-defaultBatchWindow], pending[i-defaultBatchWindow])
+		}
+
+		results[i], pending[i] = r.publishBatch(ctx, cc, opt)
+	}
+
+	for i := max(len(opts)-defaultBatchWindow, 0); i < len(opts); i++ {
+		r.confirmBatch(ctx, cc, &results[i], pending[i])
+	}
+
+	failed := 0
+	for _, res := range results {
+		if res.Err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		r.log.Errorf("[GoRabbit] Error publishing batch. Failed: %d/%d", failed, len(opts))
+		return results, fmt.Errorf("%w: %d/%d", ErrPublishBatchFailed, failed, len(opts))
+	}
+
+	r.log.Infof("[GoRabbit] Batch of %d messages published successfully", len(opts))
+	return results, nil
+}
+
+// publishBatch is a function that publishes a message of a batch without waiting for its confirmation.
+// It takes a context, a pointer to the confirmChannel, and a GoRabbitPublisherOption and returns a
+// GoRabbitPublishResult and a pointer to a batchMessage.
+// The batchMessage is nil when the message was not published, and the result carries the error then.
+func (r *rbt) publishBatch(
+	ctx context.Context,
+	cc *confirmChannel,
+	opt GoRabbitPublisherOption,
+) (GoRabbitPublishResult, *batchMessage) {
+	uid := opt.MessageId
+	if uid == "" {
+		uid = uuid.New().String()
+	}
+
+	res := GoRabbitPublishResult{MessageId: uid, Topic: opt.Topic}
+	fail := func(attempts int, err error) (GoRabbitPublishResult, *batchMessage) {
+		r.log.Errorf("[GoRabbit] [%s] Error publishing message: %s", uid, err.Error())
+		res.Err = &GoRabbitPublishError{MessageId: uid, Topic: opt.Topic, Attempts: attempts, Err: err}
+		return res, nil
+	}
+
+	if r.trimSpace(opt.Topic) == "" {
+		return fail(0, ErrTopicRequired)
+	}
+
+	if opt.Message == nil {
+		return fail(0, ErrMessageRequired)
+	}
+
+	exchange := opt.Exchange
+	if exchange == "" {
+		exchange = r.exchangeName()
+	}
+
+	pub, err := r.publishing(ctx, opt, uid)
+	if err != nil {
+		return fail(0, err)
+	}
+
+	target, delay := r.delayed(opt, exchange, &pub)
+	if delay > 0 {
+		if err := r.declareDelay(exchange, delay); err != nil {
+			return fail(1, err)
+		}
+	}
+
+	w := newPendingConfirm(&pub, true)
+
+	seq := cc.ch.GetNextPublishSeqNo()
+	if !cc.add(seq, w) {
+		return fail(1, ErrNotConnected)
+	}
+
+	if err := cc.ch.PublishWithContext(ctx, target, opt.Topic, true, false, pub); err != nil {
+		cc.remove(seq)
+		return fail(1, err)
+	}
+
+	return res, &batchMessage{seq: seq, w: w, exchange: exchange, delay: delay}
+}
+
+// confirmBatch is a function that waits for the confirmation of a message of a batch.
+// It takes a context, a pointer to the confirmChannel, a pointer to the GoRabbitPublishResult, and a pointer to
+// the batchMessage and returns nothing.
+// Every message is waited for at most the publish timeout, counted from the time its turn comes.
+func (r *rbt) confirmBatch(
+	ctx context.Context,
+	cc *confirmChannel,
+	res *GoRabbitPublishResult,
+	m *batchMessage,
+) {
+	if m == nil {
+		return
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, defaultPublishTimeout)
+	defer cancel()
+
+	err := cc.wait(wctx, m.seq, m.w)
+	if err == nil {
+		return
+	}
+
+	// The delay queue is gone, so it is declared again on the next publish.
+	if errors.Is(err, ErrPublishUnroutable) && m.delay > 0 {
+		r.forgetDelay(m.exchange, m.delay)
+	}
+
+	r.log.Errorf("[GoRabbit] [%s] Error publishing message: %s", res.MessageId, err.Error())
+	res.Err = &GoRabbitPublishError{MessageId: res.MessageId, Topic: res.Topic, Attempts: 1, Err: err}
+}
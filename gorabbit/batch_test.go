@@ -0,0 +1,135 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPublishBatchNotConnected(t *testing.T) {
+	r := &rbt{log: testLogger()}
+
+	results, err := r.PublishBatch(context.Background(), nil)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("PublishBatch of no messages = %v, %v", results, err)
+	}
+
+	results, err = r.PublishBatch(context.Background(), []GoRabbitPublisherOption{
+		{MessageId: "order-1", Topic: "order.created", Message: "hello"},
+		{MessageId: "order-2", Topic: "order.created", Message: "hello"},
+	})
+	if !errors.Is(err, ErrPublishBatchFailed) || !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("PublishBatch error = %v, want ErrPublishBatchFailed and ErrNotConnected", err)
+	}
+	for i, res := range results {
+		var perr *GoRabbitPublishError
+		if res.MessageId != fmt.Sprintf("order-%d", i+1) || !errors.As(res.Err, &perr) {
+			t.Errorf("result %d = %+v", i, res)
+		}
+	}
+}
+
+func TestPublishBatchInvalidMessage(t *testing.T) {
+	r := &rbt{log: testLogger()}
+
+	tests := []struct {
+		name    string
+		opt     GoRabbitPublisherOption
+		wantErr error
+	}{
+		{name: "no topic", opt: GoRabbitPublisherOption{Message: "hello"}, wantErr: ErrTopicRequired},
+		{name: "no message", opt: GoRabbitPublisherOption{Topic: "order.created"}, wantErr: ErrMessageRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The message is rejected before the channel is used.
+			res, m := r.publishBatch(context.Background(), nil, tt.opt)
+			if m != nil || !errors.Is(res.Err, tt.wantErr) || res.MessageId == "" {
+				t.Fatalf("publishBatch = %+v, %v, want %v", res, m, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfirmBatch(t *testing.T) {
+	r := &rbt{log: testLogger(), delays: map[string]time.Time{}}
+	cc := &confirmChannel{
+		pending: make(map[uint64]*pendingConfirm),
+		byId:    make(map[string]*pendingConfirm),
+	}
+
+	// A message that was not published is skipped.
+	r.confirmBatch(context.Background(), cc, &GoRabbitPublishResult{}, nil)
+
+	tests := []struct {
+		name    string
+		err     error
+		delay   time.Duration
+		wantErr error
+	}{
+		{name: "confirmed"},
+		{name: "nacked", err: ErrPublishNacked, wantErr: ErrPublishNacked},
+		{name: "unroutable delayed", err: ErrPublishUnroutable, delay: 2 * time.Second, wantErr: ErrPublishUnroutable},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &pendingConfirm{done: make(chan error, 1)}
+			w.done <- tt.err
+
+			if tt.delay > 0 {
+				r.delays[r.delayQueueName("orders", tt.delay)] = time.Now()
+			}
+
+			res := GoRabbitPublishResult{MessageId: "order-1", Topic: "order.created"}
+			r.confirmBatch(context.Background(), cc, &res, &batchMessage{
+				seq:      uint64(i + 1),
+				w:        w,
+				exchange: "orders",
+				delay:    tt.delay,
+			})
+
+			var perr *GoRabbitPublishError
+			if tt.wantErr == nil && res.Err != nil {
+				t.Fatalf("result error = %v", res.Err)
+			}
+			if tt.wantErr != nil && (!errors.As(res.Err, &perr) || !errors.Is(res.Err, tt.wantErr)) {
+				t.Fatalf("result error = %v, want a *GoRabbitPublishError wrapping %v", res.Err, tt.wantErr)
+			}
+
+			// The delay queue of an unroutable delayed message is declared again on the next publish.
+			if tt.delay > 0 && len(r.delays) != 0 {
+				t.Errorf("delays = %v, want the delay queue forgotten", r.delays)
+			}
+		})
+	}
+}
+
+func TestMemoryPublishBatch(t *testing.T) {
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+	m.Bind("orders", "order.*")
+
+	results, err := m.PublishBatch(context.Background(), []GoRabbitPublisherOption{
+		{Topic: "order.created", Message: "a"},
+		{Topic: "user.created", Message: "b"},
+		{Topic: "order.updated"},
+		{Topic: "order.updated", Message: "c"},
+	})
+	if !errors.Is(err, ErrPublishBatchFailed) {
+		t.Fatalf("PublishBatch error = %v, want ErrPublishBatchFailed", err)
+	}
+
+	wantErrs := []error{nil, ErrPublishUnroutable, ErrMessageRequired, nil}
+	for i, res := range results {
+		if res.MessageId == "" || !errors.Is(res.Err, wantErrs[i]) {
+			t.Errorf("result %d = %+v, want %v", i, res, wantErrs[i])
+		}
+	}
+
+	if n := len(m.Queued("orders")); n != 2 {
+		t.Fatalf("queued %d messages, want 2", n)
+	}
+}
@@ -0,0 +1,248 @@
+package gorabbit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ContentTypeJSON is the content type of the messages encoded with JSONCodec.
+	ContentTypeJSON = "application/json"
+	// ContentTypeMsgPack is the content type of the messages encoded with MsgPackCodec.
+	ContentTypeMsgPack = "application/msgpack"
+	// ContentTypeCBOR is the content type of the messages encoded with CBORCodec.
+	ContentTypeCBOR = "application/cbor"
+	// ContentTypeProtobuf is the content type of the messages encoded with ProtobufCodec.
+	ContentTypeProtobuf = "application/protobuf"
+	// ContentTypeRaw is the content type of the messages encoded with RawCodec.
+	ContentTypeRaw = "application/octet-stream"
+
+	// ContentEncodingGoEncrypt is the content encoding of the messages sealed with the configured secret.
+	ContentEncodingGoEncrypt = "goencrypt"
+)
+
+// Codec is an interface that defines the methods for encoding and decoding the message bodies.
+// It is used to publish and consume the messages in a content type other than JSON.
+// ContentType is the content type set on the published messages, and the one the consumers pick the codec by.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSONCodec is the Codec of the JSON messages. It is the default one.
+	JSONCodec Codec = jsonCodec{}
+	// MsgPackCodec is the Codec of the MessagePack messages.
+	MsgPackCodec Codec = msgpackCodec{}
+	// CBORCodec is the Codec of the CBOR messages.
+	CBORCodec Codec = cborCodec{}
+	// ProtobufCodec is the Codec of the protobuf messages. The messages must implement proto.Message.
+	ProtobufCodec Codec = protobufCodec{}
+	// RawCodec is the Codec of the messages that are sent as is. The messages must be a []byte, a string,
+	// or a json.RawMessage.
+	RawCodec Codec = rawCodec{}
+)
+
+// codecs is the registry of the codecs by content type.
+var codecs = struct {
+	mu sync.RWMutex
+	m  map[string]Codec
+}{
+	m: map[string]Codec{
+		ContentTypeJSON:          JSONCodec,
+		"text/plain":             JSONCodec,
+		"":                       JSONCodec,
+		ContentTypeMsgPack:       MsgPackCodec,
+		"application/x-msgpack":  MsgPackCodec,
+		ContentTypeCBOR:          CBORCodec,
+		ContentTypeProtobuf:      ProtobufCodec,
+		"application/x-protobuf": ProtobufCodec,
+		ContentTypeRaw:           RawCodec,
+	},
+}
+
+// RegisterCodec is a function that registers a codec.
+// It takes a Codec and a list of content type aliases and returns nothing.
+// The codec is registered for its content type and the aliases, replacing the codec registered before.
+// This is used to plug in a content type gorabbit does not know.
+func RegisterCodec(codec Codec, aliases ...string) {
+	codecs.mu.Lock()
+	defer codecs.mu.Unlock()
+
+	codecs.m[normalizeContentType(codec.ContentType())] = codec
+	for _, alias := range aliases {
+		codecs.m[normalizeContentType(alias)] = codec
+	}
+}
+
+// CodecFor is a function that returns the codec of a content type.
+// It takes a content type and returns a Codec and an error.
+// The parameters of the content type are ignored. The messages without a content type, or with the
+// "text/plain" one of the previous versions, are JSON. An error wrapping ErrUnsupportedContentType is
+// returned when no codec is registered for the content type.
+func CodecFor(contentType string) (Codec, error) {
+	codecs.mu.RLock()
+	defer codecs.mu.RUnlock()
+
+	codec, ok := codecs.m[normalizeContentType(contentType)]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedContentType, contentType)
+	}
+	return codec, nil
+}
+
+// Decode is a function that decodes the body of a consumed message.
+// It takes an amqp091.Delivery and a pointer to the destination and returns an error.
+// The codec is picked by the content type of the message. This is used by Handle and Reply and can be
+// used by raw ConsumerFunc handlers as well. The returned error wraps ErrDecode.
+func Decode(msg amqp091.Delivery, dest any) error {
+	codec, err := CodecFor(msg.ContentType)
+	if err != nil {
+		return fmt.Errorf("%w into %T: %w", ErrDecode, dest, err)
+	}
+
+	if err := codec.Unmarshal(msg.Body, dest); err != nil {
+		return fmt.Errorf("%w into %T: %v", ErrDecode, dest, err)
+	}
+
+	return nil
+}
+
+// normalizeContentType is a function that normalizes a content type.
+// It takes a content type and returns a string.
+// The parameters are dropped and the media type is lowercased.
+func normalizeContentType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// codec is a function that returns the codec of a published message.
+// It takes the content type of the publisher option and a topic and returns a Codec and an error.
+// The content type of the option is used first, then the one configured for the topic, then the
+// configured default, and JSON otherwise.
+func (r *rbt) codec(contentType string, topic string) (Codec, error) {
+	if contentType == "" {
+		contentType = r.conf.ContentTypes[topic]
+	}
+	if contentType == "" {
+		contentType = r.conf.ContentType
+	}
+	if contentType == "" {
+		return JSONCodec, nil
+	}
+	return CodecFor(contentType)
+}
+
+// replyCodec is a function that returns the codec of the reply of a request.
+// It takes the request and returns a Codec.
+// The reply is encoded like the request, and in JSON when the request content type is unknown.
+func replyCodec(msg amqp091.Delivery) Codec {
+	codec, err := CodecFor(msg.ContentType)
+	if err != nil {
+		return JSONCodec
+	}
+	return codec
+}
+
+// jsonCodec is a struct that implements the Codec interface with encoding/json.
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return ContentTypeJSON }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// msgpackCodec is a struct that implements the Codec interface with MessagePack.
+// The json tags of the structs are used, so the same types can be sent in both formats.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return ContentTypeMsgPack }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// cborCodec is a struct that implements the Codec interface with CBOR.
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string { return ContentTypeCBOR }
+
+func (cborCodec) Marshal(v any) ([]byte, error) { return cbor.Marshal(v) }
+
+func (cborCodec) Unmarshal(data []byte, v any) error { return cbor.Unmarshal(data, v) }
+
+// protobufCodec is a struct that implements the Codec interface with protobuf.
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec cannot encode %T, it is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec cannot decode into %T, it is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// rawCodec is a struct that implements the Codec interface without encoding.
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string { return ContentTypeRaw }
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case json.RawMessage:
+		return b, nil
+	case string:
+		return []byte(b), nil
+	}
+	return nil, fmt.Errorf("raw codec cannot encode %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch b := v.(type) {
+	case *[]byte:
+		*b = append((*b)[:0], data...)
+	case *json.RawMessage:
+		*b = append((*b)[:0], data...)
+	case *string:
+		*b = string(data)
+	default:
+		return fmt.Errorf("raw codec cannot decode into %T", v)
+	}
+	return nil
+}
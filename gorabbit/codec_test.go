@@ -0,0 +1,177 @@
+package gorabbit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, MsgPackCodec, CBORCodec} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			body, err := codec.Marshal(testOrder{Id: "a", Total: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got testOrder
+			if err := Decode(amqp091.Delivery{ContentType: codec.ContentType(), Body: body}, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != (testOrder{Id: "a", Total: 1}) {
+				t.Fatalf("decoded %+v", got)
+			}
+		})
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	body, err := ProtobufCodec.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &wrapperspb.StringValue{}
+	if err := ProtobufCodec.Unmarshal(body, got); err != nil || !proto.Equal(got, wrapperspb.String("hello")) {
+		t.Fatalf("Unmarshal = %v, %v", got, err)
+	}
+
+	if _, err := ProtobufCodec.Marshal(testOrder{}); err == nil {
+		t.Fatal("Marshal accepted a value that is not a proto.Message")
+	}
+}
+
+func TestRawCodec(t *testing.T) {
+	for _, v := range []any{[]byte("hello"), "hello", json.RawMessage("hello")} {
+		body, err := RawCodec.Marshal(v)
+		if err != nil || string(body) != "hello" {
+			t.Fatalf("Marshal(%T) = %q, %v", v, body, err)
+		}
+	}
+	if _, err := RawCodec.Marshal(1); err == nil {
+		t.Fatal("Marshal accepted an int")
+	}
+
+	var s string
+	if err := RawCodec.Unmarshal([]byte("hello"), &s); err != nil || s != "hello" {
+		t.Fatalf("Unmarshal = %q, %v", s, err)
+	}
+	var o testOrder
+	if err := RawCodec.Unmarshal([]byte("hello"), &o); err == nil {
+		t.Fatal("Unmarshal accepted a struct")
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        Codec
+		wantErr     error
+	}{
+		{contentType: "", want: JSONCodec},
+		{contentType: "text/plain", want: JSONCodec},
+		{contentType: "Application/JSON; charset=utf-8", want: JSONCodec},
+		{contentType: "application/x-msgpack", want: MsgPackCodec},
+		{contentType: ContentTypeCBOR, want: CBORCodec},
+		{contentType: "application/x-protobuf", want: ProtobufCodec},
+		{contentType: ContentTypeRaw, want: RawCodec},
+		{contentType: "application/xml", wantErr: ErrUnsupportedContentType},
+	}
+
+	for _, tt := range tests {
+		got, err := CodecFor(tt.contentType)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("CodecFor(%q) = %v, %v, want %v, %v", tt.contentType, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// upperCodec is a Codec sending the strings in upper case.
+type upperCodec struct{}
+
+func (upperCodec) ContentType() string { return "text/x-upper" }
+
+func (upperCodec) Marshal(v any) ([]byte, error) { return []byte(strings.ToUpper(v.(string))), nil }
+
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	*v.(*string) = strings.ToLower(string(data))
+	return nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	RegisterCodec(upperCodec{}, "text/x-shout")
+
+	for _, contentType := range []string{"text/x-upper", "TEXT/X-SHOUT"} {
+		if codec, err := CodecFor(contentType); err != nil || codec != (upperCodec{}) {
+			t.Fatalf("CodecFor(%q) = %v, %v", contentType, codec, err)
+		}
+	}
+
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{ContentTypes: map[string]string{"greeting": "text/x-upper"}}, nil)
+
+	got := make(chan string, 1)
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"greetings": {
+			"greeting": Handle(func(_ context.Context, s string, _ GoRabbitMeta) error {
+				got <- s
+				return nil
+			}),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The codec is picked by the content type configured for the topic.
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "greeting", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	published := m.PublishedTo("greeting")
+	if len(published) != 1 || published[0].ContentType != "text/x-upper" || string(published[0].Body) != "HELLO" {
+		t.Fatalf("published = %+v", published)
+	}
+	if s := <-got; s != "hello" {
+		t.Fatalf("handled %q", s)
+	}
+}
+
+func TestPublishCodec(t *testing.T) {
+	r := &rbt{conf: GoRabbitConfiguration{
+		ContentType:  ContentTypeMsgPack,
+		ContentTypes: map[string]string{"order.created": ContentTypeCBOR},
+	}}
+
+	tests := []struct {
+		name        string
+		contentType string
+		topic       string
+		want        Codec
+		wantErr     error
+	}{
+		{name: "option", contentType: ContentTypeJSON, topic: "order.created", want: JSONCodec},
+		{name: "topic", topic: "order.created", want: CBORCodec},
+		{name: "default", topic: "user.created", want: MsgPackCodec},
+		{name: "unknown", contentType: "application/xml", topic: "order.created", wantErr: ErrUnsupportedContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.codec(tt.contentType, tt.topic)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Fatalf("codec = %v, %v, want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	if got := replyCodec(amqp091.Delivery{ContentType: "application/xml"}); got != JSONCodec {
+		t.Errorf("replyCodec of an unknown content type = %v, want JSONCodec", got)
+	}
+}
@@ -0,0 +1,160 @@
+package gorabbit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// ContentEncodingGzip is the content encoding of the messages compressed with gzip.
+	ContentEncodingGzip = "gzip"
+	// ContentEncodingZstd is the content encoding of the messages compressed with zstd.
+	ContentEncodingZstd = "zstd"
+	// ContentEncodingIdentity is the compression that disables the compression, e.g. of a topic.
+	ContentEncodingIdentity = "identity"
+
+	defaultCompressionThreshold = 1024
+	maxDecompressedSize         = 128 << 20
+)
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+)
+
+// compression is a function that returns the compression of the messages of a topic.
+// It takes a topic and returns a string.
+// The compression configured for the topic is used first, then the configured default. It returns an
+// empty string when the messages are not compressed.
+func (r *rbt) compression(topic string) string {
+	c, ok := r.conf.Compressions[topic]
+	if !ok {
+		c = r.conf.Compression
+	}
+
+	c = strings.ToLower(strings.TrimSpace(c))
+	if c == ContentEncodingIdentity {
+		return ""
+	}
+	return c
+}
+
+// compressionThreshold is a function that returns the size from which the messages are compressed.
+// It takes nothing and returns an int.
+func (r *rbt) compressionThreshold() int {
+	if r.conf.CompressionThreshold > 0 {
+		return r.conf.CompressionThreshold
+	}
+	return defaultCompressionThreshold
+}
+
+// validateCompression is a function that validates the compressions of the configuration.
+// It takes a GoRabbitConfiguration and returns an error.
+// It returns an error wrapping ErrInvalidConfig when a compression is not supported.
+func validateCompression(opt GoRabbitConfiguration) error {
+	check := func(c string) error {
+		switch strings.ToLower(strings.TrimSpace(c)) {
+		case "", ContentEncodingIdentity, ContentEncodingGzip, ContentEncodingZstd:
+			return nil
+		}
+		return fmt.Errorf("%w: compression: '%s'", ErrInvalidConfig, c)
+	}
+
+	if err := check(opt.Compression); err != nil {
+		return err
+	}
+	for _, c := range opt.Compressions {
+		if err := check(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compress is a function that compresses a message body.
+// It takes a compression and a []byte and returns a []byte and an error.
+func compress(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case ContentEncodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case ContentEncodingZstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(body, nil), nil
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedContentEncoding, encoding)
+}
+
+// decompress is a function that decompresses a message body.
+// It takes a content encoding and a []byte and returns a []byte and an error.
+// A body decompressing to more than 128 MiB is rejected.
+func decompress(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case ContentEncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		b, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxDecompressedSize {
+			return nil, fmt.Errorf("gzip body is larger than %d bytes", maxDecompressedSize)
+		}
+		return b, nil
+	case ContentEncodingZstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(body, nil)
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedContentEncoding, encoding)
+}
+
+// isCompression is a function that reports whether a content coding is a compression.
+// It takes a content coding and returns a bool.
+// The compressions are the ones of the HTTP content codings registry, so the compressions gorabbit does not
+// support are told apart from the codings that leave the body as is.
+func isCompression(coding string) bool {
+	switch coding {
+	case ContentEncodingGzip, ContentEncodingZstd, "x-gzip", "deflate", "compress", "x-compress", "br", "dcb", "dcz":
+		return true
+	}
+	return false
+}
+
+// contentEncodings is a function that splits a content encoding into its codings.
+// It takes a content encoding and returns a list of codings in the order they were applied.
+func contentEncodings(encoding string) []string {
+	var out []string
+	for _, c := range strings.Split(encoding, ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" && c != ContentEncodingIdentity {
+			out = append(out, c)
+		}
+	}
+	return out
+}
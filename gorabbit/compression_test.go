@@ -0,0 +1,206 @@
+package gorabbit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	body := []byte(strings.Repeat("order.created ", 200))
+
+	for _, encoding := range []string{ContentEncodingGzip, ContentEncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			z, err := compress(encoding, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(z) >= len(body) {
+				t.Fatalf("compressed %d bytes to %d", len(body), len(z))
+			}
+
+			got, err := decompress(encoding, z)
+			if err != nil || !bytes.Equal(got, body) {
+				t.Fatalf("decompress = %q, %v", got, err)
+			}
+
+			if _, err := decompress(encoding, body); err == nil {
+				t.Fatal("decompress accepted a body that is not compressed")
+			}
+		})
+	}
+
+	if _, err := compress("br", body); !errors.Is(err, ErrUnsupportedContentEncoding) {
+		t.Fatalf("compress error = %v, want ErrUnsupportedContentEncoding", err)
+	}
+	if _, err := decompress("br", body); !errors.Is(err, ErrUnsupportedContentEncoding) {
+		t.Fatalf("decompress error = %v, want ErrUnsupportedContentEncoding", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	r := &rbt{conf: GoRabbitConfiguration{
+		Compression:  " GZIP ",
+		Compressions: map[string]string{"order.created": ContentEncodingZstd, "user.created": "identity"},
+	}}
+
+	tests := []struct {
+		topic string
+		want  string
+	}{
+		{topic: "order.created", want: ContentEncodingZstd},
+		{topic: "user.created", want: ""},
+		{topic: "order.updated", want: ContentEncodingGzip},
+	}
+
+	for _, tt := range tests {
+		if got := r.compression(tt.topic); got != tt.want {
+			t.Errorf("compression(%q) = %q, want %q", tt.topic, got, tt.want)
+		}
+	}
+
+	if got := (&rbt{}).compression("order.created"); got != "" {
+		t.Errorf("compression without a configuration = %q", got)
+	}
+}
+
+func TestEncodeCompression(t *testing.T) {
+	large := strings.Repeat("a", 2048)
+
+	tests := []struct {
+		name         string
+		conf         GoRabbitConfiguration
+		message      string
+		wantEncoding string
+	}{
+		{name: "large", conf: GoRabbitConfiguration{Compression: ContentEncodingGzip}, message: large, wantEncoding: "gzip"},
+		{name: "below threshold", conf: GoRabbitConfiguration{Compression: ContentEncodingGzip}, message: "small", wantEncoding: ""},
+		{name: "threshold", conf: GoRabbitConfiguration{Compression: ContentEncodingZstd, CompressionThreshold: 10}, message: strings.Repeat("a", 64), wantEncoding: "zstd"},
+		// A body that is not smaller once compressed is sent as is.
+		{name: "not smaller", conf: GoRabbitConfiguration{Compression: ContentEncodingGzip, CompressionThreshold: 1}, message: "ab", wantEncoding: ""},
+		{name: "identity", conf: GoRabbitConfiguration{Compression: ContentEncodingGzip, Compressions: map[string]string{"order.created": "identity"}}, message: large, wantEncoding: ""},
+		{name: "encrypted", conf: GoRabbitConfiguration{Compression: ContentEncodingGzip, Secret: testSecret}, message: large, wantEncoding: "gzip, goencrypt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestCore(t, tt.conf)
+
+			body, encoding, err := r.encode(JSONCodec, "order.created", tt.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoding != tt.wantEncoding {
+				t.Fatalf("content encoding = %q, want %q", encoding, tt.wantEncoding)
+			}
+
+			msg, err := r.open(amqp091.Delivery{ContentEncoding: encoding, Body: body})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			if err := Decode(msg, &got); err != nil || got != tt.message {
+				t.Fatalf("decoded %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    GoRabbitConfiguration
+		wantErr error
+	}{
+		{name: "none", conf: GoRabbitConfiguration{}},
+		{name: "supported", conf: GoRabbitConfiguration{Compression: "Zstd", Compressions: map[string]string{"order.created": "gzip", "user.created": "identity"}}},
+		{name: "unsupported", conf: GoRabbitConfiguration{Compression: "br"}, wantErr: ErrInvalidConfig},
+		{name: "unsupported by topic", conf: GoRabbitConfiguration{Compressions: map[string]string{"order.created": "lz4"}}, wantErr: ErrInvalidConfig},
+	}
+
+	for _, tt := range tests {
+		if err := validateCompression(tt.conf); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// The compression is validated before the broker is dialed.
+	if _, err := Connect(context.Background(), GoRabbitConfiguration{Compression: "br"}, testLogger()); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Connect error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestContentEncodings(t *testing.T) {
+	tests := []struct {
+		encoding string
+		want     []string
+	}{
+		{encoding: "", want: nil},
+		{encoding: "identity", want: nil},
+		{encoding: "GZIP, goencrypt", want: []string{"gzip", "goencrypt"}},
+		{encoding: " zstd ,, identity", want: []string{"zstd"}},
+	}
+
+	for _, tt := range tests {
+		if got := contentEncodings(tt.encoding); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("contentEncodings(%q) = %q, want %q", tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestOpenContentEncoding(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	gz, err := compress(ContentEncodingGzip, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		encoding     string
+		body         []byte
+		wantEncoding string
+		wantErr      error
+	}{
+		{name: "identity", encoding: "identity", body: body},
+		{name: "identity and gzip", encoding: "identity, gzip", body: gz},
+		{name: "charset", encoding: "utf-8", body: body, wantEncoding: "utf-8"},
+		{name: "gzip and charset", encoding: "gzip, UTF-8", body: gz, wantEncoding: "utf-8"},
+		{name: "unsupported compression", encoding: "br", body: body, wantErr: ErrUnsupportedContentEncoding},
+		{name: "unsupported compression and charset", encoding: "deflate, utf-8", body: body, wantErr: ErrUnsupportedContentEncoding},
+	}
+
+	r := &rbt{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := r.open(amqp091.Delivery{ContentEncoding: tt.encoding, Body: tt.body})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("open error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(msg.Body, body) || msg.ContentEncoding != tt.wantEncoding {
+				t.Fatalf("open = %q, %q, want %q, %q", msg.Body, msg.ContentEncoding, body, tt.wantEncoding)
+			}
+		})
+	}
+}
+
+func TestIsCompression(t *testing.T) {
+	for _, c := range []string{ContentEncodingGzip, ContentEncodingZstd, "br", "deflate", "compress"} {
+		if !isCompression(c) {
+			t.Errorf("isCompression(%q) = false", c)
+		}
+	}
+	for _, c := range []string{ContentEncodingGoEncrypt, "utf-8", "base64"} {
+		if isCompression(c) {
+			t.Errorf("isCompression(%q) = true", c)
+		}
+	}
+}
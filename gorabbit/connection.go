@@ -0,0 +1,439 @@
+package gorabbit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// GoRabbitConnectionState is a type that represents the connection state.
+// It is used to report the state of the connection to the broker.
+type GoRabbitConnectionState int
+
+const (
+	// GoRabbitStateConnecting is the state while the first connection is being established.
+	GoRabbitStateConnecting GoRabbitConnectionState = iota
+	// GoRabbitStateConnected is the state while the connection and the channel are open.
+	GoRabbitStateConnected
+	// GoRabbitStateDisconnected is the state right after the connection or the channel was lost.
+	GoRabbitStateDisconnected
+	// GoRabbitStateReconnecting is the state while a reconnection attempt is running.
+	GoRabbitStateReconnecting
+	// GoRabbitStateClosed is the state after Close has been called.
+	GoRabbitStateClosed
+)
+
+// String is a function that returns the name of the connection state.
+// It takes nothing and returns a string.
+// This is used to print the connection state.
+func (s GoRabbitConnectionState) String() string {
+	switch s {
+	case GoRabbitStateConnecting:
+		return "connecting"
+	case GoRabbitStateConnected:
+		return "connected"
+	case GoRabbitStateDisconnected:
+		return "disconnected"
+	case GoRabbitStateReconnecting:
+		return "reconnecting"
+	case GoRabbitStateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// GoRabbitConnectionEvent is a struct that represents a connection state change.
+// It is used to notify the subscribers of NotifyState.
+type GoRabbitConnectionEvent struct {
+	State   GoRabbitConnectionState
+	Attempt int
+	Err     error
+	Time    time.Time
+}
+
+const (
+	defaultHeartbeat         = 10 * time.Second
+	defaultHost              = "localhost"
+	defaultUser              = "guest"
+	defaultLocale            = "en_US"
+	publisherConnectionName  = "publisher"
+	defaultDialTimeout       = 30 * time.Second
+	defaultReconnectDelay    = 1 * time.Second
+	defaultMaxReconnectDelay = 30 * time.Second
+	notifierBufferSize       = 16
+)
+
+// dial is a function that opens a new connection and a new channel.
+// It takes a context and returns a pointer to an amqp091.Connection, a pointer to an amqp091.Channel, and an error.
+// This is used to connect and reconnect to the broker.
+func (r *rbt) dial(ctx context.Context) (*amqp091.Connection, *amqp091.Channel, error) {
+	conn, err := amqp091.DialConfig(r.dsn, r.amqpConfig(ctx, r.conf.ConnectionName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("error opening channel: %w", err)
+	}
+
+	return conn, ch, nil
+}
+
+// dialPublisher is a function that opens the publisher connection.
+// It takes a context and returns a pointer to an amqp091.Connection and an error.
+// The connection is named after the main one with a "publisher" suffix.
+func (r *rbt) dialPublisher(ctx context.Context) (*amqp091.Connection, error) {
+	name := ""
+	if r.conf.ConnectionName != "" {
+		name = r.conf.ConnectionName + " (" + publisherConnectionName + ")"
+	}
+
+	conn, err := amqp091.DialConfig(r.dsn, r.amqpConfig(ctx, name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrConnectionFailed, publisherConnectionName, err)
+	}
+
+	return conn, nil
+}
+
+// amqpConfig is a function that returns the configuration of a new connection.
+// It takes a context and the connection name and returns an amqp091.Config.
+// The context bounds the dial and the handshake.
+func (r *rbt) amqpConfig(ctx context.Context, name string) amqp091.Config {
+	heartbeat := r.conf.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeat
+	}
+
+	props := amqp091.NewConnectionProperties()
+	if name != "" {
+		props.SetClientConnectionName(name)
+	}
+
+	conf := amqp091.Config{
+		Vhost:      r.conf.VHost,
+		ChannelMax: r.conf.ChannelMax,
+		FrameSize:  r.conf.FrameSize,
+		Heartbeat:  heartbeat,
+		Properties: props,
+		Locale:     defaultLocale,
+		Dial:       dialContext(ctx),
+	}
+
+	// The TLS configuration is cloned as amqp091 sets its server name.
+	if r.tls != nil {
+		conf.TLSClientConfig = r.tls.Clone()
+	}
+
+	return conf
+}
+
+// uri is a function that returns the URI of the broker.
+// It takes nothing and returns an amqp091.URI and an error.
+// The URL is used when set, and the URI is built from the host, the port, and the credentials otherwise,
+// so they do not need to be escaped. The scheme is amqps when TLS is set.
+func (c GoRabbitConfiguration) uri() (amqp091.URI, error) {
+	if strings.TrimSpace(c.URL) != "" {
+		uri, err := amqp091.ParseURI(strings.TrimSpace(c.URL))
+		if err != nil {
+			return amqp091.URI{}, fmt.Errorf("%w: url: %w", ErrInvalidConfig, err)
+		}
+		if c.TLS.enabled() && uri.Scheme == "amqp" {
+			uri.Scheme = "amqps"
+			if uri.Port == 5672 {
+				uri.Port = 5671
+			}
+		}
+		return uri, nil
+	}
+
+	uri := amqp091.URI{
+		Scheme:   "amqp",
+		Host:     defaultHost,
+		Port:     5672,
+		Username: defaultUser,
+		Password: defaultUser,
+		Vhost:    "/",
+	}
+
+	if c.TLS.enabled() {
+		uri.Scheme = "amqps"
+		uri.Port = 5671
+	}
+
+	if strings.TrimSpace(c.Host) != "" {
+		uri.Host = strings.TrimSpace(c.Host)
+	}
+
+	if strings.TrimSpace(c.Port) != "" {
+		port, err := strconv.Atoi(strings.TrimSpace(c.Port))
+		if err != nil || port <= 0 || port > 65535 {
+			return amqp091.URI{}, fmt.Errorf("%w: port: '%s'", ErrInvalidConfig, c.Port)
+		}
+		uri.Port = port
+	}
+
+	if c.User != "" {
+		uri.Username = c.User
+		uri.Password = c.Password
+	}
+
+	return uri, nil
+}
+
+// dialContext is a function that creates the dial function of a connection.
+// It takes a context and returns a function dialing a network address.
+// The TCP connection is opened with the context, and the AMQP handshake must complete before the context
+// deadline, or the default dial timeout when it has none.
+func dialContext(ctx context.Context) func(network string, addr string) (net.Conn, error) {
+	return func(network string, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(defaultDialTimeout)
+		}
+
+		// The deadline is cleared by amqp091 once the handshake is complete.
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+// channel is a function that returns the current channel.
+// It takes nothing and returns a pointer to an amqp091.Channel.
+// This is used to always use the channel of the latest connection.
+func (r *rbt) channel() *amqp091.Channel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ach
+}
+
+// watch is a function that watches the connections and the channel.
+// It takes a pointer to the amqp091.Connection, a pointer to the publisher amqp091.Connection, and a pointer to
+// an amqp091.Channel and returns nothing.
+// This is used to reconnect when the broker closes a connection or the channel. Both connections are
+// opened again when either of them is lost.
+func (r *rbt) watch(conn *amqp091.Connection, pconn *amqp091.Connection, ch *amqp091.Channel) {
+	for {
+		connClosed := conn.NotifyClose(make(chan *amqp091.Error, 1))
+		pconnClosed := pconn.NotifyClose(make(chan *amqp091.Error, 1))
+		chClosed := ch.NotifyClose(make(chan *amqp091.Error, 1))
+
+		var reason *amqp091.Error
+		select {
+		case <-r.done:
+			return
+		case reason = <-connClosed:
+		case reason = <-pconnClosed:
+		case reason = <-chClosed:
+		}
+
+		if r.isClosing() {
+			return
+		}
+
+		var err error
+		if reason != nil {
+			err = reason
+			r.log.Errorf("[GoRabbit] Connection lost: %s", reason.Error())
+		} else {
+			r.log.Error("[GoRabbit] Connection lost")
+		}
+		r.setState(GoRabbitStateDisconnected, 0, err)
+
+		_ = conn.Close()
+		_ = pconn.Close()
+
+		conn, pconn, ch = r.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect is a function that reconnects to the broker with an exponential backoff.
+// It takes nothing and returns a pointer to the amqp091.Connection, a pointer to the publisher amqp091.Connection,
+// and a pointer to an amqp091.Channel.
+// This is used to restore the connections, the topology, and the consumers.
+// It returns nil values when the GoRabbit is closed while reconnecting.
+func (r *rbt) reconnect() (*amqp091.Connection, *amqp091.Connection, *amqp091.Channel) {
+	var (
+		delay    = defaultReconnectDelay
+		maxDelay = defaultMaxReconnectDelay
+	)
+
+	if r.conf.ReconnectDelay > 0 {
+		delay = r.conf.ReconnectDelay
+	}
+
+	if r.conf.MaxReconnectDelay > 0 {
+		maxDelay = r.conf.MaxReconnectDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		r.setState(GoRabbitStateReconnecting, attempt, nil)
+		r.log.Infof("[GoRabbit] [%d] Reconnecting in %s...", attempt, delay)
+
+		select {
+		case <-r.done:
+			return nil, nil, nil
+		case <-time.After(delay):
+		}
+
+		var pconn *amqp091.Connection
+		conn, ch, err := r.dial(context.Background())
+		if err == nil {
+			pconn, err = r.dialPublisher(context.Background())
+		}
+		if err == nil {
+			err = r.restore(conn, pconn, ch)
+		}
+		if err == nil {
+			r.log.Infof("[GoRabbit] [%d] Reconnected successfully", attempt)
+			r.setState(GoRabbitStateConnected, attempt, nil)
+			return conn, pconn, ch
+		}
+
+		if conn != nil {
+			_ = conn.Close()
+		}
+		if pconn != nil {
+			_ = pconn.Close()
+		}
+
+		if r.isClosing() {
+			return nil, nil, nil
+		}
+
+		r.log.Errorf("[GoRabbit] [%d] Error reconnecting: %s", attempt, err.Error())
+		r.setState(GoRabbitStateDisconnected, attempt, err)
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// restore is a function that swaps in the new connections and declares the remembered consumers again.
+// It takes a pointer to the amqp091.Connection, a pointer to the publisher amqp091.Connection, and a pointer to
+// an amqp091.Channel and returns an error.
+// This is used to resume the publishers and the consumers after a reconnection.
+func (r *rbt) restore(conn *amqp091.Connection, pconn *amqp091.Connection, ch *amqp091.Channel) error {
+	r.mu.Lock()
+	if r.closing {
+		r.mu.Unlock()
+		return fmt.Errorf("connection closed")
+	}
+	r.acn = conn
+	r.ach = ch
+	listeners := append([]*listener(nil), r.listeners...)
+	r.mu.Unlock()
+
+	if err := r.pub.open(pconn, r.conf.PublisherChannels); err != nil {
+		return err
+	}
+
+	if err := r.declareExchanges(ch); err != nil {
+		return err
+	}
+
+	for _, l := range listeners {
+		if err := r.listen(conn, ch, l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isClosing is a function that reports whether Close has been called.
+// It takes nothing and returns a bool.
+// This is used to stop reconnecting after Close.
+func (r *rbt) isClosing() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.closing
+}
+
+// State is a function that returns the current connection state.
+// It takes nothing and returns a GoRabbitConnectionState.
+// This is used to report the connection state, e.g. in health endpoints.
+func (r *rbt) State() GoRabbitConnectionState {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	return r.state
+}
+
+// NotifyState is a function that subscribes to the connection state changes.
+// It takes nothing and returns a receive-only channel of GoRabbitConnectionEvent.
+// This is used to be notified when the connection is lost and restored.
+// Events are dropped when the subscriber does not keep up, and the channel is closed on Close.
+func (r *rbt) NotifyState() <-chan GoRabbitConnectionEvent {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	ch := make(chan GoRabbitConnectionEvent, notifierBufferSize)
+	if r.state == GoRabbitStateClosed {
+		close(ch)
+		return ch
+	}
+	r.notifiers = append(r.notifiers, ch)
+	return ch
+}
+
+// setState is a function that sets the connection state and notifies the subscribers.
+// It takes a GoRabbitConnectionState, an int, and an error and returns nothing.
+// This is used to publish the connection state changes.
+func (r *rbt) setState(state GoRabbitConnectionState, attempt int, err error) {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	if r.state == GoRabbitStateClosed {
+		return
+	}
+	r.state = state
+
+	evt := GoRabbitConnectionEvent{
+		State:   state,
+		Attempt: attempt,
+		Err:     err,
+		Time:    time.Now(),
+	}
+	for _, n := range r.notifiers {
+		select {
+		case n <- evt:
+		default:
+		}
+	}
+}
+
+// closeNotifiers is a function that closes the channels of the subscribers.
+// It takes nothing and returns nothing.
+// This is used to release the subscribers on Close.
+func (r *rbt) closeNotifiers() {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	for _, n := range r.notifiers {
+		close(n)
+	}
+	r.notifiers = nil
+}
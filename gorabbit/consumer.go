@@ -0,0 +1,463 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/the-lanky/go-utils/gocontext"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultConsumerRestartDelay = 5000 * time.Millisecond
+	defaultShutdownTimeout      = 30 * time.Second
+)
+
+// subscription is a struct that represents a queue consumed on its own channel.
+// It is used to cancel the consumer on shutdown.
+type subscription struct {
+	queue string
+	tag   string
+	ch    *amqp091.Channel
+}
+
+// listener is a struct that represents the consumers started by a Listen call.
+// It is used to stop them and to wait for their running handlers.
+type listener struct {
+	consumers GoRabbitConsumerMessages
+	hctx      context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu      sync.Mutex
+	stopped bool
+	subs    map[*subscription]bool
+	wg      sync.WaitGroup
+}
+
+// newListener is a function that creates a new listener.
+// It takes a context and a GoRabbitConsumerMessages and returns a pointer to a listener.
+// The handlers get a context that keeps the values of the Listen context but is only cancelled
+// when the shutdown deadline is reached, so they can finish while the consumers drain.
+func newListener(ctx context.Context, consumers GoRabbitConsumerMessages) *listener {
+	hctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	return &listener{
+		consumers: consumers,
+		hctx:      hctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		subs:      make(map[*subscription]bool),
+	}
+}
+
+// add is a function that registers a subscription of the listener.
+// It takes a pointer to a subscription and returns a bool.
+// It returns false when the listener is already stopped.
+func (l *listener) add(s *subscription) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopped {
+		return false
+	}
+	l.subs[s] = true
+	l.wg.Add(1)
+	return true
+}
+
+// remove is a function that forgets a subscription of the listener once its workers are done.
+// It takes a pointer to a subscription and returns nothing.
+// This is used when the channel of the subscription is closed.
+func (l *listener) remove(s *subscription) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.subs, s)
+	l.wg.Done()
+}
+
+// isStopped is a function that reports whether the listener is stopped.
+// It takes nothing and returns a bool.
+// This is used to requeue the prefetched messages instead of handling them.
+func (l *listener) isStopped() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopped
+}
+
+// stop is a function that cancels the consumers of the listener.
+// It takes a pointer to a rbt and returns nothing.
+// The broker stops delivering, and the deliveries are drained by the workers.
+func (l *listener) stop(r *rbt) {
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return
+	}
+	l.stopped = true
+	close(l.done)
+	subs := make([]*subscription, 0, len(l.subs))
+	for s := range l.subs {
+		subs = append(subs, s)
+	}
+	l.mu.Unlock()
+
+	for _, s := range subs {
+		if err := s.ch.Cancel(s.tag, false); err != nil {
+			r.log.Errorf("[GoRabbit] Error cancelling consumer of queue '%s': %s", s.queue, err.Error())
+		} else {
+			r.log.Infof("[GoRabbit] Consumer of queue '%s' cancelled", s.queue)
+		}
+	}
+}
+
+// subscriptions is a function that returns the subscriptions of a queue.
+// It takes a queue name and returns a list of pointers to subscription.
+// This is used to cancel the consumers of a queue.
+func (l *listener) subscriptions(queue string) []*subscription {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var subs []*subscription
+	for s := range l.subs {
+		if s.queue == queue {
+			subs = append(subs, s)
+		}
+	}
+	return subs
+}
+
+// consuming is a function that reports whether the listener has a consumer of a queue.
+// It takes a queue name and returns a bool.
+// This is used so a queue is never consumed twice by a listener.
+func (l *listener) consuming(queue string) bool {
+	return len(l.subscriptions(queue)) > 0
+}
+
+// wait is a function that waits until the workers of the listener are done.
+// It takes nothing and returns nothing.
+// This is used on shutdown.
+func (l *listener) wait() {
+	l.wg.Wait()
+}
+
+// consume is a function that starts consuming a queue on its own channel.
+// It takes a pointer to an amqp091.Connection, a pointer to a listener, a queue name, and the consumers of its topics and returns an error.
+// The channel gets the prefetch (QoS) of the queue, so a slow queue never holds back the others.
+func (r *rbt) consume(
+	conn *amqp091.Connection,
+	l *listener,
+	queue string,
+	topics map[string]GoRabbitConsumer,
+) error {
+	conf := r.queueConfig(queue)
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("error opening channel for queue: '%s': %w", queue, err)
+	}
+
+	prefetch := conf.PrefetchCount
+	if prefetch <= 0 {
+		prefetch = 2 * concurrency(conf)
+	}
+
+	if err := ch.Qos(prefetch, conf.PrefetchSize, false); err != nil {
+		_ = ch.Close()
+		return fmt.Errorf("error setting prefetch for queue: '%s': %w", queue, err)
+	}
+
+	sub := &subscription{
+		queue: queue,
+		tag:   fmt.Sprintf("gorabbit-%s-%s", queue, uuid.New().String()),
+		ch:    ch,
+	}
+
+	if !l.add(sub) {
+		_ = ch.Close()
+		return nil
+	}
+
+	messages, err := ch.Consume(
+		queue,
+		sub.tag,
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		_ = ch.Close()
+		l.remove(sub)
+		return fmt.Errorf("error consuming queue: '%s': %w", queue, err)
+	}
+
+	go r.consumeMessages(conn, l, sub, topics, messages)
+
+	// The listener may have been stopped while the consumer was starting.
+	if l.isStopped() {
+		_ = ch.Cancel(sub.tag, false)
+	}
+
+	return nil
+}
+
+// concurrency is a function that returns the number of workers of a queue.
+// It takes a GoRabbitQueue and returns an int.
+// It returns 1 when the concurrency is not configured.
+func concurrency(conf GoRabbitQueue) int {
+	if conf.Concurrency > 1 {
+		return conf.Concurrency
+	}
+	return 1
+}
+
+// orderingKey is a function that returns the ordering key of a message.
+// It takes a GoRabbitQueue and an amqp091.Delivery and returns a string.
+// It returns an empty string when the message can be handled in any order.
+func orderingKey(conf GoRabbitQueue, msg amqp091.Delivery) string {
+	if conf.OrderingKey != nil {
+		return conf.OrderingKey(msg)
+	}
+	if conf.OrderingHeader != "" {
+		if v, ok := msg.Headers[conf.OrderingHeader]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// consumeMessages is a function that consumes the messages.
+// It takes a pointer to an amqp091.Connection, a pointer to a listener, a pointer to a subscription, the consumers
+// of its topics, and a channel of amqp091.Delivery and returns nothing.
+// The deliveries are handed to the workers of the queue. A message without an ordering key goes to the first free
+// worker, and a message with an ordering key always goes to the same worker, so those messages keep their order.
+// The queue is consumed again when its channel is closed while the connection is still open.
+func (r *rbt) consumeMessages(
+	conn *amqp091.Connection,
+	l *listener,
+	sub *subscription,
+	topics map[string]GoRabbitConsumer,
+	msgs <-chan amqp091.Delivery,
+) {
+	var (
+		queue   = sub.queue
+		conf    = r.queueConfig(queue)
+		workers = concurrency(conf)
+		fns     = r.handlers(queue, topics)
+		shared  = make(chan amqp091.Delivery)
+		lanes   = make([]chan amqp091.Delivery, workers)
+
+		wg sync.WaitGroup
+	)
+
+	for i := range lanes {
+		lanes[i] = make(chan amqp091.Delivery)
+		wg.Add(1)
+		go func(lane <-chan amqp091.Delivery) {
+			defer wg.Done()
+			r.work(l.hctx, queue, fns, shared, lane)
+		}(lanes[i])
+	}
+
+	for msg := range msgs {
+		if l.isStopped() {
+			if err := msg.Nack(false, true); err != nil {
+				r.log.Errorf("[GoRabbit] [%s] Error requeueing message: %s", msg.MessageId, err.Error())
+			}
+			continue
+		}
+
+		key := orderingKey(conf, msg)
+		if key == "" {
+			shared <- msg
+			continue
+		}
+
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		lanes[h.Sum32()%uint32(workers)] <- msg
+	}
+
+	close(shared)
+	for _, lane := range lanes {
+		close(lane)
+	}
+	wg.Wait()
+
+	if !sub.ch.IsClosed() {
+		_ = sub.ch.Close()
+	}
+	l.remove(sub)
+
+	r.log.Infof("[GoRabbit] Queue '%s' stopped", queue)
+
+	// A paused or cancelled queue is not consumed again, and a resumed one may already be.
+	restart := func() bool {
+		return !r.isClosing() && !l.isStopped() && !conn.IsClosed() &&
+			r.queues.running(queue) && !l.consuming(queue)
+	}
+
+	for restart() {
+		time.Sleep(defaultConsumerRestartDelay)
+		if !restart() {
+			return
+		}
+
+		if err := r.consume(conn, l, queue, topics); err != nil {
+			r.log.Errorf("[GoRabbit] Error restarting queue '%s': %s", queue, err.Error())
+			continue
+		}
+
+		r.log.Infof("[GoRabbit] Queue '%s' restarted", queue)
+		return
+	}
+}
+
+// work is a function that runs a worker of a queue.
+// It takes the handler context, a queue name, the handlers of its topics, the shared channel, and the own channel of the worker and returns nothing.
+// It returns once both channels are closed.
+func (r *rbt) work(
+	ctx context.Context,
+	queue string,
+	handlers *dispatcher,
+	shared <-chan amqp091.Delivery,
+	lane <-chan amqp091.Delivery,
+) {
+	for shared != nil || lane != nil {
+		select {
+		case msg, ok := <-shared:
+			if !ok {
+				shared = nil
+				continue
+			}
+			r.handleDelivery(ctx, queue, handlers, msg)
+		case msg, ok := <-lane:
+			if !ok {
+				lane = nil
+				continue
+			}
+			r.handleDelivery(ctx, queue, handlers, msg)
+		}
+	}
+}
+
+// handleDelivery is a function that handles a message.
+// It takes the handler context, a queue name, the handlers of its topics, and an amqp091.Delivery and returns nothing.
+// The message is acked on success, retried on error or panic, and dead-lettered when it cannot be handled at all
+// or the error wraps ErrNonRetryable. The handler gets a context rebuilt from the headers of the message,
+// with the request id and the trace context of the publisher. The handler is the one of the most specific topic
+// matching the routing key, or the fallback of the queue.
+func (r *rbt) handleDelivery(
+	ctx context.Context,
+	queue string,
+	handlers *dispatcher,
+	msg amqp091.Delivery,
+) {
+	done := r.queues.stats(queue).begin(msg)
+	succeeded := false
+	defer func() { done(succeeded) }()
+
+	msg = originalDelivery(msg)
+	body := msg.Body
+
+	ctx = deliveryContext(ctx, msg)
+	log := r.log.WithFields(gocontext.Fields(ctx))
+
+	defer func() {
+		if rc := recover(); rc != nil {
+			log.Errorf(
+				"[GoRabbit] [%s] [%s] Consumer panic: %v",
+				msg.MessageId,
+				msg.RoutingKey,
+				rc,
+			)
+			r.retry(queue, msg, body, fmt.Errorf("consumer panic: %v", rc))
+		}
+	}()
+
+	log.Infof(
+		"[GoRabbit] [%s] [%s] Consuming topic...",
+		msg.MessageId,
+		msg.RoutingKey,
+	)
+
+	handle, ok := handlers.match(msg.RoutingKey)
+	if !ok {
+		log.Errorf(
+			"[GoRabbit] [%s] [%s] Consumer not found",
+			msg.MessageId,
+			msg.RoutingKey,
+		)
+		r.deadLetter(queue, msg, body, errors.New("consumer not found"))
+		return
+	}
+
+	plain, err := r.open(msg)
+	if err != nil {
+		log.Errorf(
+			"[GoRabbit] [%s] [%s] Error opening message: %s",
+			msg.MessageId,
+			msg.RoutingKey,
+			err.Error(),
+		)
+		r.deadLetter(queue, msg, body, err)
+		return
+	}
+
+	plain, err = r.conform(plain)
+	if err != nil {
+		log.Errorf(
+			"[GoRabbit] [%s] [%s] Error checking message schema: %s",
+			msg.MessageId,
+			msg.RoutingKey,
+			err.Error(),
+		)
+		if errors.Is(err, ErrNonRetryable) {
+			r.deadLetter(queue, msg, body, err)
+		} else {
+			r.retry(queue, msg, body, err)
+		}
+		return
+	}
+
+	if r.conf.Debug {
+		log.Info(string(plain.Body))
+	}
+
+	ctx = context.WithValue(ctx, replierKey{}, replier(r))
+	ctx = context.WithValue(ctx, queueKey{}, queue)
+
+	if err := handle(ctx, plain); err != nil {
+		log.Errorf(
+			"[GoRabbit] [%s] [%s] Error consuming message: %s",
+			msg.MessageId,
+			msg.RoutingKey,
+			err.Error(),
+		)
+		if errors.Is(err, ErrNonRetryable) {
+			r.deadLetter(queue, msg, body, err)
+		} else {
+			r.retry(queue, msg, body, err)
+		}
+		return
+	}
+
+	succeeded = true
+
+	if err := msg.Ack(false); err != nil {
+		log.Errorf(
+			"[GoRabbit] [%s] [%s] Error acknowledging message: %s",
+			msg.MessageId,
+			msg.RoutingKey,
+			err.Error(),
+		)
+	}
+}
@@ -0,0 +1,114 @@
+package gorabbit
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestConcurrency(t *testing.T) {
+	tests := []struct {
+		conf GoRabbitQueue
+		want int
+	}{
+		{conf: GoRabbitQueue{}, want: 1},
+		{conf: GoRabbitQueue{Concurrency: -1}, want: 1},
+		{conf: GoRabbitQueue{Concurrency: 8}, want: 8},
+	}
+
+	for _, tt := range tests {
+		if got := concurrency(tt.conf); got != tt.want {
+			t.Errorf("concurrency(%d) = %d, want %d", tt.conf.Concurrency, got, tt.want)
+		}
+	}
+}
+
+func TestOrderingKey(t *testing.T) {
+	msg := amqp091.Delivery{
+		RoutingKey: "order.created",
+		Headers:    amqp091.Table{"x-order-id": int64(42)},
+	}
+
+	tests := []struct {
+		name string
+		conf GoRabbitQueue
+		want string
+	}{
+		{name: "unordered", conf: GoRabbitQueue{}, want: ""},
+		{name: "header", conf: GoRabbitQueue{OrderingHeader: "x-order-id"}, want: "42"},
+		{name: "missing header", conf: GoRabbitQueue{OrderingHeader: "x-user-id"}, want: ""},
+		{
+			name: "function wins over header",
+			conf: GoRabbitQueue{
+				OrderingHeader: "x-order-id",
+				OrderingKey:    func(msg amqp091.Delivery) string { return msg.RoutingKey },
+			},
+			want: "order.created",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderingKey(tt.conf, msg); got != tt.want {
+				t.Fatalf("orderingKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	const workers = 3
+
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{
+		Queues: map[string]GoRabbitQueue{"orders": {Concurrency: workers}},
+	}, nil)
+
+	var (
+		running atomic.Int32
+		peak    atomic.Int32
+		release = make(chan struct{})
+	)
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(context.Context, amqp091.Delivery) error {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				<-release
+				return nil
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 * workers {
+		if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every worker picks a message, and the others wait for a free worker.
+	deadline := time.Now().Add(5 * time.Second)
+	for running.Load() < workers && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	waitIdle(t, m)
+
+	if p := peak.Load(); p != workers {
+		t.Fatalf("%d handlers ran at once, want %d", p, workers)
+	}
+	if n := len(m.Acked()); n != 2*workers {
+		t.Fatalf("acked %d messages, want %d", n, 2*workers)
+	}
+}
@@ -0,0 +1,144 @@
+package gorabbit
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/the-lanky/go-utils/goencrypt"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// crypto is a struct that represents the message encryption.
+// It is used to seal the published messages and to open the consumed ones.
+// The messages are sealed in the goencrypt envelope, which carries its nonce and key id, so any
+// service with the same secret can decrypt them. Legacy messages are only accepted when LegacyIV is set, as
+// the legacy format does not carry its IV, and until DisableLegacyDecryption is set.
+type crypto struct {
+	enc goencrypt.GoEncrypt
+}
+
+// encrypt is a function that encrypts the message.
+// It takes a []byte and returns a []byte and an error.
+// The encoded message is sealed in a binary envelope.
+func (c *crypto) encrypt(message []byte) ([]byte, error) {
+	return c.enc.Seal(message)
+}
+
+// decrypt is a function that decrypts the message.
+// It takes a []byte and returns a []byte and an error.
+// The message can be an envelope or a message in the legacy format.
+func (c *crypto) decrypt(message []byte) ([]byte, error) {
+	return c.enc.Open(message)
+}
+
+// initCrypto is a function that initializes the crypto.
+// It takes a GoRabbitConfiguration and returns a *crypto and an error.
+// It returns nil when no secret is configured, as the messages are not encrypted then.
+func initCrypto(opt GoRabbitConfiguration) (*crypto, error) {
+	if len(opt.Secret) == 0 {
+		return nil, nil
+	}
+
+	if len(opt.Secret) < 24 {
+		return nil, ErrSecretTooShort
+	}
+
+	opts := []goencrypt.Option{
+		goencrypt.SetKeyID(opt.SecretKeyId),
+		goencrypt.SetPreviousKeys(opt.PreviousSecrets...),
+	}
+	if opt.LegacyIV != "" {
+		iv, err := base64.StdEncoding.DecodeString(opt.LegacyIV)
+		if err != nil {
+			return nil, fmt.Errorf("%w: legacy iv: %w", ErrInvalidSecret, err)
+		}
+		opts = append(opts, goencrypt.SetLegacyIV(iv))
+	}
+	if opt.DisableLegacyDecryption {
+		opts = append(opts, goencrypt.DisableLegacyDecryption())
+	}
+
+	enc, err := goencrypt.Init(opt.Secret, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSecret, err)
+	}
+
+	return &crypto{enc: enc}, nil
+}
+
+// encode is a function that encodes a message body.
+// It takes a Codec, a topic, and a any and returns a []byte, the content encoding, and an error.
+// The message is encoded with the codec, compressed when the compression of the topic is set and the body is
+// large enough, and sealed when the message encryption is enabled. The content encoding lists the codings
+// in the order they were applied, e.g. "gzip, goencrypt".
+func (r *rbt) encode(codec Codec, topic string, message any) ([]byte, string, error) {
+	b, err := codec.Marshal(message)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var codings []string
+
+	if c := r.compression(topic); c != "" && len(b) >= r.compressionThreshold() {
+		z, err := compress(c, b)
+		if err != nil {
+			return nil, "", err
+		}
+		// The body is only sent compressed when it is smaller.
+		if len(z) < len(b) {
+			b = z
+			codings = append(codings, c)
+		}
+	}
+
+	if r.withMessageEncryption {
+		b, err = r.cr.encrypt(b)
+		if err != nil {
+			return nil, "", err
+		}
+		codings = append(codings, ContentEncodingGoEncrypt)
+	}
+
+	return b, strings.Join(codings, ", "), nil
+}
+
+// open is a function that decrypts and decompresses a consumed message.
+// It takes an amqp091.Delivery and returns an amqp091.Delivery and an error.
+// The codings of the content encoding are undone from the last to the first, and the returned delivery
+// carries the plain body. When the message encryption is enabled the legacy messages sent without a content
+// encoding are opened too, and an encrypted message fails with ErrEncryptedMessage when it is disabled.
+// A compression gorabbit does not support fails with ErrUnsupportedContentEncoding, while the other codings,
+// e.g. a charset set by another publisher, leave the body as is and are kept in the content encoding.
+func (r *rbt) open(msg amqp091.Delivery) (amqp091.Delivery, error) {
+	codings := contentEncodings(msg.ContentEncoding)
+	if len(codings) == 0 && r.withMessageEncryption {
+		codings = []string{ContentEncodingGoEncrypt}
+	}
+
+	var kept []string
+
+	body := msg.Body
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch c := codings[i]; {
+		case c == ContentEncodingGoEncrypt:
+			if !r.withMessageEncryption {
+				return msg, ErrEncryptedMessage
+			}
+			body, err = r.cr.decrypt(body)
+		case isCompression(c):
+			body, err = decompress(c, body)
+		default:
+			kept = append([]string{c}, kept...)
+		}
+		if err != nil {
+			return msg, err
+		}
+	}
+
+	msg.Body = body
+	msg.ContentEncoding = strings.Join(kept, ", ")
+	return msg, nil
+}
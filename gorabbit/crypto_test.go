@@ -0,0 +1,103 @@
+package gorabbit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/the-lanky/go-utils/goencrypt"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const testSecret = "0123456789abcdef01234567"
+
+// newTestCore returns a core with the message encryption of the configuration.
+func newTestCore(t *testing.T, conf GoRabbitConfiguration) *rbt {
+	t.Helper()
+
+	cr, err := initCrypto(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &rbt{conf: conf, cr: cr, withMessageEncryption: cr != nil}
+}
+
+func TestEncodeOpen(t *testing.T) {
+	a := newTestCore(t, GoRabbitConfiguration{Secret: testSecret})
+	b := newTestCore(t, GoRabbitConfiguration{Secret: testSecret})
+
+	body, encoding, err := a.encode(JSONCodec, "order.created", map[string]int{"id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding != ContentEncodingGoEncrypt {
+		t.Fatalf("encoding = %q, want %q", encoding, ContentEncodingGoEncrypt)
+	}
+
+	got, err := b.open(amqp091.Delivery{ContentEncoding: encoding, Body: body})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if string(got.Body) != `{"id":1}` || got.ContentEncoding != "" {
+		t.Fatalf("open = %q, %q", got.Body, got.ContentEncoding)
+	}
+}
+
+func TestOpenLegacy(t *testing.T) {
+	iv := []byte("fedcba9876543210")
+
+	blk, err := aes.NewCipher([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := make([]byte, len(`{"id":1}`))
+	cipher.NewCFBEncrypter(blk, iv).XORKeyStream(legacy, []byte(`{"id":1}`))
+	body := []byte(base64.StdEncoding.EncodeToString(legacy))
+
+	t.Run("with iv", func(t *testing.T) {
+		r := newTestCore(t, GoRabbitConfiguration{
+			Secret:   testSecret,
+			LegacyIV: base64.StdEncoding.EncodeToString(iv),
+		})
+
+		got, err := r.open(amqp091.Delivery{Body: body})
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		if string(got.Body) != `{"id":1}` {
+			t.Fatalf("open = %q", got.Body)
+		}
+	})
+
+	t.Run("without iv", func(t *testing.T) {
+		r := newTestCore(t, GoRabbitConfiguration{Secret: testSecret})
+
+		if _, err := r.open(amqp091.Delivery{Body: body}); !errors.Is(err, goencrypt.ErrInvalidEnvelope) {
+			t.Fatalf("open error = %v, want goencrypt.ErrInvalidEnvelope", err)
+		}
+	})
+}
+
+func TestInitCrypto(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    GoRabbitConfiguration
+		wantErr error
+	}{
+		{name: "no secret", conf: GoRabbitConfiguration{}},
+		{name: "short secret", conf: GoRabbitConfiguration{Secret: "short"}, wantErr: ErrSecretTooShort},
+		{name: "invalid legacy iv", conf: GoRabbitConfiguration{Secret: testSecret, LegacyIV: "c2hvcnQ="}, wantErr: ErrInvalidSecret},
+		{name: "legacy iv not base64", conf: GoRabbitConfiguration{Secret: testSecret, LegacyIV: "!"}, wantErr: ErrInvalidSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := initCrypto(tt.conf); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("initCrypto error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package gorabbit
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// HeaderDelay is the header that carries the delay of a delayed message in milliseconds.
+	HeaderDelay = "x-gorabbit-delay"
+	// HeaderDelayExchange is the header that carries the exchange a delayed message is published to once due.
+	HeaderDelayExchange = "x-gorabbit-delay-exchange"
+
+	defaultDelayExchange  = "gorabbit.delay"
+	defaultDelayPrecision = 1 * time.Second
+	delayQueueGrace       = 10 * time.Minute
+)
+
+// delayOf is a function that returns the delay of a published message.
+// It takes a GoRabbitPublisherOption and the current time and returns a time.Duration.
+// DelayUntil takes precedence over Delay, and a delay in the past is no delay.
+func delayOf(opt GoRabbitPublisherOption, now time.Time) time.Duration {
+	d := opt.Delay
+	if !opt.DelayUntil.IsZero() {
+		d = opt.DelayUntil.Sub(now)
+	}
+	return max(d, 0)
+}
+
+// delayed is a function that routes a delayed message to its delay queue.
+// It takes a GoRabbitPublisherOption, the exchange, and a pointer to the amqp091.Publishing and returns the
+// exchange to publish to and the rounded delay.
+// The exchange is returned as is when the message is not delayed. The expiration of a delayed message is
+// dropped, as the delay queue would dead-letter the message once expired instead of once due.
+func (r *rbt) delayed(opt GoRabbitPublisherOption, exchange string, pub *amqp091.Publishing) (string, time.Duration) {
+	delay := delayOf(opt, time.Now())
+	if delay <= 0 {
+		return exchange, 0
+	}
+
+	delay = r.delayPrecision(delay)
+	pub.Headers = delayHeaders(pub.Headers, exchange, delay)
+	pub.Expiration = ""
+
+	return r.delayExchange(), delay
+}
+
+// delayExchange is a function that returns the name of the delay exchange.
+// It takes nothing and returns a string.
+// This is used to declare and publish to the delay exchange.
+func (r *rbt) delayExchange() string {
+	if r.conf.DelayExchange != "" {
+		return r.conf.DelayExchange
+	}
+	return defaultDelayExchange
+}
+
+// delayPrecision is a function that rounds up a delay to the configured precision.
+// It takes a time.Duration and returns a time.Duration.
+// The messages with the same rounded delay share a delay queue, so a coarser precision means fewer queues.
+func (r *rbt) delayPrecision(d time.Duration) time.Duration {
+	p := defaultDelayPrecision
+	if r.conf.DelayPrecision > 0 {
+		p = r.conf.DelayPrecision
+	}
+	return (d + p - 1) / p * p
+}
+
+// delayQueueName is a function that returns the name of the delay queue of an exchange and a delay.
+// It takes an exchange and a time.Duration and returns a string.
+func (r *rbt) delayQueueName(exchange string, delay time.Duration) string {
+	return fmt.Sprintf("%s.%s.%d", r.delayExchange(), exchange, delay.Milliseconds())
+}
+
+// delayHeaders is a function that adds the delay headers to the headers of a message.
+// It takes an amqp091.Table, an exchange, and a time.Duration and returns an amqp091.Table.
+// The headers route the message to its delay queue through the delay exchange.
+func delayHeaders(headers amqp091.Table, exchange string, delay time.Duration) amqp091.Table {
+	out := amqp091.Table{}
+	for k, v := range headers {
+		out[k] = v
+	}
+	out[HeaderDelay] = strconv.FormatInt(delay.Milliseconds(), 10)
+	out[HeaderDelayExchange] = exchange
+	return out
+}
+
+// declareDelay is a function that declares the delay queue of an exchange and a delay.
+// It takes an exchange and a time.Duration and returns an error.
+// The delay exchange is a headers exchange bound to one queue per exchange and delay. The queue holds the
+// message for its TTL and then dead-letters it to the exchange with its original routing key. The queue
+// is durable, so the delayed messages survive a restart of the publisher, and is deleted by the broker
+// once unused for longer than the delay. It is declared again from time to time so it never expires
+// while it holds messages.
+func (r *rbt) declareDelay(exchange string, delay time.Duration) error {
+	name := r.delayQueueName(exchange, delay)
+
+	r.delayMu.Lock()
+	defer r.delayMu.Unlock()
+
+	if at, ok := r.delays[name]; ok && time.Since(at) < delayQueueGrace/2 {
+		return nil
+	}
+
+	r.mu.RLock()
+	conn := r.acn
+	r.mu.RUnlock()
+
+	if conn == nil || conn.IsClosed() {
+		return ErrNotConnected
+	}
+
+	// A failed declaration closes the channel, so a channel of its own is used to keep the main one open.
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("error opening delay channel: %w", err)
+	}
+	defer ch.Close()
+
+	dx := r.delayExchange()
+	if err := ch.ExchangeDeclare(
+		dx,
+		amqp091.ExchangeHeaders,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		return fmt.Errorf("error declaring delay exchange: '%s': %w", dx, err)
+	}
+
+	if _, err := ch.QueueDeclare(
+		name,
+		true,
+		false,
+		false,
+		false,
+		amqp091.Table{
+			"x-message-ttl":          delay.Milliseconds(),
+			"x-expires":              (delay + delayQueueGrace).Milliseconds(),
+			"x-dead-letter-exchange": exchange,
+		},
+	); err != nil {
+		return fmt.Errorf("error declaring delay queue: '%s': %w", name, err)
+	}
+
+	if err := ch.QueueBind(name, "", dx, false, amqp091.Table{
+		"x-match":           "all",
+		HeaderDelay:         strconv.FormatInt(delay.Milliseconds(), 10),
+		HeaderDelayExchange: exchange,
+	}); err != nil {
+		return fmt.Errorf("error binding delay queue: '%s': %w", name, err)
+	}
+
+	if r.delays == nil {
+		r.delays = make(map[string]time.Time)
+	}
+	r.delays[name] = time.Now()
+
+	return nil
+}
+
+// forgetDelay is a function that forgets the declaration of a delay queue.
+// It takes an exchange and a time.Duration and returns nothing.
+// This is used when a delayed message was unroutable, as the delay queue was deleted, e.g. by a broker restart.
+func (r *rbt) forgetDelay(exchange string, delay time.Duration) {
+	r.delayMu.Lock()
+	defer r.delayMu.Unlock()
+
+	delete(r.delays, r.delayQueueName(exchange, delay))
+}
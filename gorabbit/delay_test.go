@@ -0,0 +1,129 @@
+package gorabbit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestDelayOf(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		opt  GoRabbitPublisherOption
+		want time.Duration
+	}{
+		{name: "none", opt: GoRabbitPublisherOption{}, want: 0},
+		{name: "delay", opt: GoRabbitPublisherOption{Delay: time.Minute}, want: time.Minute},
+		{name: "until wins", opt: GoRabbitPublisherOption{Delay: time.Minute, DelayUntil: now.Add(time.Hour)}, want: time.Hour},
+		{name: "past", opt: GoRabbitPublisherOption{DelayUntil: now.Add(-time.Hour)}, want: 0},
+		{name: "negative", opt: GoRabbitPublisherOption{Delay: -time.Minute}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := delayOf(tt.opt, now); got != tt.want {
+				t.Fatalf("delayOf = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelayPrecision(t *testing.T) {
+	tests := []struct {
+		precision time.Duration
+		delay     time.Duration
+		want      time.Duration
+	}{
+		{delay: 1500 * time.Millisecond, want: 2 * time.Second},
+		{delay: 2 * time.Second, want: 2 * time.Second},
+		{precision: time.Minute, delay: 61 * time.Second, want: 2 * time.Minute},
+		{precision: time.Millisecond, delay: 1500 * time.Millisecond, want: 1500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		r := &rbt{conf: GoRabbitConfiguration{DelayPrecision: tt.precision}}
+		if got := r.delayPrecision(tt.delay); got != tt.want {
+			t.Errorf("delayPrecision(%v) with precision %v = %v, want %v", tt.delay, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestDelayed(t *testing.T) {
+	r := &rbt{}
+	headers := amqp091.Table{"x-tenant": "acme"}
+	pub := amqp091.Publishing{Headers: headers, Expiration: "1000"}
+
+	target, delay := r.delayed(GoRabbitPublisherOption{Delay: 1500 * time.Millisecond}, "orders", &pub)
+	if target != defaultDelayExchange || delay != 2*time.Second {
+		t.Fatalf("delayed = %q, %v", target, delay)
+	}
+	if pub.Headers[HeaderDelay] != "2000" || pub.Headers[HeaderDelayExchange] != "orders" || pub.Headers["x-tenant"] != "acme" {
+		t.Errorf("headers = %v", pub.Headers)
+	}
+	if pub.Expiration != "" {
+		t.Errorf("expiration = %q, want it dropped", pub.Expiration)
+	}
+	if _, ok := headers[HeaderDelay]; ok {
+		t.Error("the original headers were changed")
+	}
+	if name := r.delayQueueName("orders", delay); name != "gorabbit.delay.orders.2000" {
+		t.Errorf("delayQueueName = %q", name)
+	}
+
+	pub = amqp091.Publishing{Expiration: "1000"}
+	if target, delay := r.delayed(GoRabbitPublisherOption{}, "orders", &pub); target != "orders" || delay != 0 || pub.Expiration != "1000" {
+		t.Errorf("delayed of a message that is not delayed = %q, %v, %+v", target, delay, pub)
+	}
+}
+
+func TestMemoryDelayed(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	handled := make(chan time.Time, 2)
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(context.Context, amqp091.Delivery) error {
+				handled <- time.Now()
+				return nil
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A delayed message is held until it is due.
+	start := time.Now()
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "soon", Delay: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case at := <-handled:
+		if at.Sub(start) < 20*time.Millisecond {
+			t.Fatalf("handled after %v, before the delay", at.Sub(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the delayed message was not handled")
+	}
+
+	// DeliverDelayed routes the delayed messages right away.
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "later", Delay: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if d := m.Delayed(); len(d) != 1 || string(d[0].Body) != `"later"` {
+		t.Fatalf("delayed = %+v", d)
+	}
+	m.DeliverDelayed()
+	waitIdle(t, m)
+
+	if n := len(m.Acked()); n != 2 {
+		t.Fatalf("acked %d messages, want 2", n)
+	}
+	if n := len(m.Delayed()); n != 0 {
+		t.Fatalf("%d messages still delayed", n)
+	}
+}
@@ -0,0 +1,125 @@
+package gorabbit
+
+import (
+	"slices"
+	"strings"
+)
+
+// FallbackTopic is the topic of the fallback consumer of a queue.
+// The fallback consumer is not bound, and handles the messages of the queue no other topic matches,
+// e.g. the ones routed by a binding declared outside of gorabbit. Without it those messages are dead-lettered.
+//
+//	consumers := gorabbit.GoRabbitConsumerMessages{
+//		"orders": {
+//			"order.*":              gorabbit.Handle(onOrder),
+//			"order.created":        gorabbit.Handle(onOrderCreated),
+//			gorabbit.FallbackTopic: gorabbit.GoRabbitConsumer{Consume: onUnknown},
+//		},
+//	}
+const FallbackTopic = ""
+
+// dispatcher is a struct that represents the handlers of a queue.
+// It is used to find the handler of a routing key with the AMQP topic semantics.
+type dispatcher struct {
+	exact    map[string]ConsumerFunc
+	patterns []dispatchPattern
+	fallback ConsumerFunc
+}
+
+// dispatchPattern is a struct that represents a wildcard topic of a queue.
+type dispatchPattern struct {
+	pattern string
+	words   []string
+	handle  ConsumerFunc
+}
+
+// newDispatcher is a function that creates a new dispatcher.
+// It takes a map of topic to ConsumerFunc and returns a pointer to a dispatcher.
+// The wildcard topics are sorted from the most specific to the least specific one.
+func newDispatcher(handlers map[string]ConsumerFunc) *dispatcher {
+	d := &dispatcher{exact: make(map[string]ConsumerFunc)}
+
+	for topic, fn := range handlers {
+		switch {
+		case topic == FallbackTopic:
+			d.fallback = fn
+		case isPattern(topic):
+			d.patterns = append(d.patterns, dispatchPattern{
+				pattern: topic,
+				words:   strings.Split(topic, "."),
+				handle:  fn,
+			})
+		default:
+			d.exact[topic] = fn
+		}
+	}
+
+	slices.SortFunc(d.patterns, func(a, b dispatchPattern) int {
+		return comparePatterns(a.words, b.words)
+	})
+
+	return d
+}
+
+// match is a function that returns the handler of a routing key.
+// It takes a routing key and returns a ConsumerFunc and a bool.
+// A topic without wildcards wins over the wildcard ones, the most specific wildcard topic wins over the
+// others, and the fallback handles the routing keys no topic matches.
+func (d *dispatcher) match(key string) (ConsumerFunc, bool) {
+	if fn, ok := d.exact[key]; ok {
+		return fn, true
+	}
+
+	words := strings.Split(key, ".")
+	for _, p := range d.patterns {
+		if matchWords(p.words, words) {
+			return p.handle, true
+		}
+	}
+
+	if d.fallback != nil {
+		return d.fallback, true
+	}
+	return nil, false
+}
+
+// isPattern is a function that reports whether a topic has wildcards.
+// It takes a topic and returns a bool.
+func isPattern(topic string) bool {
+	for _, w := range strings.Split(topic, ".") {
+		if w == "*" || w == "#" {
+			return true
+		}
+	}
+	return false
+}
+
+// comparePatterns is a function that orders two wildcard topics by specificity.
+// It takes the words of both topics and returns a negative number when the first one is more specific.
+// The words are compared from left to right, a literal word being more specific than "*", and "*" more
+// specific than "#". A longer topic is more specific than its prefix, and the topics are ordered by name otherwise.
+func comparePatterns(a []string, b []string) int {
+	for i := range min(len(a), len(b)) {
+		if c := wordRank(a[i]) - wordRank(b[i]); c != 0 {
+			return c
+		}
+	}
+
+	if c := len(b) - len(a); c != 0 {
+		return c
+	}
+
+	return strings.Compare(strings.Join(a, "."), strings.Join(b, "."))
+}
+
+// wordRank is a function that returns the rank of a word of a topic.
+// It takes a word and returns an int, lower being more specific.
+func wordRank(w string) int {
+	switch w {
+	case "#":
+		return 2
+	case "*":
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,148 @@
+package gorabbit
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{pattern: "order.created", key: "order.created", want: true},
+		{pattern: "order.created", key: "order.updated", want: false},
+		{pattern: "order.*", key: "order.created", want: true},
+		{pattern: "order.*", key: "order", want: false},
+		{pattern: "order.*", key: "order.created.eu", want: false},
+		{pattern: "*.created", key: "order.created", want: true},
+		{pattern: "order.#", key: "order", want: true},
+		{pattern: "order.#", key: "order.created.eu", want: true},
+		{pattern: "#.eu", key: "eu", want: true},
+		{pattern: "#.eu", key: "order.created.eu", want: true},
+		{pattern: "order.#.eu", key: "order.eu", want: true},
+		{pattern: "order.#.eu", key: "order.created.us", want: false},
+		{pattern: "#", key: "order.created", want: true},
+		{pattern: "#", key: "", want: true},
+		{pattern: "*", key: "order.created", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := topicMatch(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("topicMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestComparePatterns(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want int
+	}{
+		{name: "literal beats star", a: "order.created.*", b: "order.*.eu", want: -1},
+		{name: "star beats hash", a: "order.*", b: "order.#", want: -1},
+		{name: "leftmost word decides", a: "order.#", b: "*.created", want: -1},
+		{name: "longer beats prefix", a: "order.*.eu", b: "order.*", want: -1},
+		{name: "tie ordered by name", a: "order.*", b: "user.*", want: -1},
+		{name: "same pattern", a: "order.*", b: "order.*", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := strings.Split(tt.a, "."), strings.Split(tt.b, ".")
+			if got := sign(comparePatterns(a, b)); got != tt.want {
+				t.Errorf("comparePatterns(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+			if got := sign(comparePatterns(b, a)); got != -tt.want {
+				t.Errorf("comparePatterns(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatcherMatch(t *testing.T) {
+	handler := func(topic string) ConsumerFunc {
+		return func(ctx context.Context, _ amqp091.Delivery) error {
+			*ctx.Value(topicKey{}).(*string) = topic
+			return nil
+		}
+	}
+
+	topics := []string{"order.created", "order.*", "order.#", "#", "*.created.eu", "#.eu"}
+	handlers := make(map[string]ConsumerFunc, len(topics))
+	for _, topic := range topics {
+		handlers[topic] = handler(topic)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "order.created", want: "order.created"},
+		{key: "order.updated", want: "order.*"},
+		{key: "order", want: "order.#"},
+		{key: "order.created.eu", want: "order.#"},
+		{key: "user.created.eu", want: "*.created.eu"},
+		{key: "eu", want: "#.eu"},
+		{key: "user", want: "#"},
+	}
+
+	d := newDispatcher(handlers)
+	for _, tt := range tests {
+		fn, ok := d.match(tt.key)
+		if !ok {
+			t.Errorf("match(%q) found no handler", tt.key)
+			continue
+		}
+
+		var got string
+		_ = fn(context.WithValue(context.Background(), topicKey{}, &got), amqp091.Delivery{})
+		if got != tt.want {
+			t.Errorf("match(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDispatcherFallback(t *testing.T) {
+	var fallback bool
+	d := newDispatcher(map[string]ConsumerFunc{
+		"order.*": func(context.Context, amqp091.Delivery) error { return nil },
+	})
+	if _, ok := d.match("user.created"); ok {
+		t.Fatal("match found a handler without a matching topic or a fallback")
+	}
+
+	d = newDispatcher(map[string]ConsumerFunc{
+		FallbackTopic: func(context.Context, amqp091.Delivery) error {
+			fallback = true
+			return nil
+		},
+	})
+	fn, ok := d.match("user.created")
+	if !ok {
+		t.Fatal("match did not return the fallback")
+	}
+	_ = fn(context.Background(), amqp091.Delivery{})
+	if !fallback {
+		t.Fatal("match returned another handler than the fallback")
+	}
+}
+
+type topicKey struct{}
+
+// sign is a function that returns the sign of a comparison.
+func sign(c int) int {
+	switch {
+	case c < 0:
+		return -1
+	case c > 0:
+		return 1
+	}
+	return 0
+}
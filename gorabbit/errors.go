@@ -0,0 +1,114 @@
+package gorabbit
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTopicRequired is returned when a message is published without a topic.
+	ErrTopicRequired = errors.New("topic is required")
+	// ErrMessageRequired is returned when a nil message is published.
+	ErrMessageRequired = errors.New("message is required")
+	// ErrInvalidConfig is returned by Connect when the URL, the TLS settings, or a compression cannot be used.
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrSecretTooShort is returned by Connect when the secret is shorter than 24 characters.
+	ErrSecretTooShort = errors.New("secret must be at least 24 characters long")
+	// ErrInvalidSecret is returned by Connect when the secret or a previous secret cannot be used.
+	ErrInvalidSecret = errors.New("invalid secret")
+	// ErrConnectionFailed is returned by Connect when the broker cannot be reached.
+	ErrConnectionFailed = errors.New("error connecting to RabbitMQ")
+	// ErrTopologyFailed is returned by Connect when the exchanges cannot be declared.
+	ErrTopologyFailed = errors.New("error declaring topology")
+	// ErrNotConnected is returned when there is no open channel to publish on, e.g. while reconnecting.
+	ErrNotConnected = errors.New("gorabbit is not connected")
+	// ErrPublishNacked is returned when the broker negatively acknowledges a message.
+	ErrPublishNacked = errors.New("message nacked by the broker")
+	// ErrPublishUnroutable is returned when the broker returns a mandatory message that no queue is bound for.
+	ErrPublishUnroutable = errors.New("message is unroutable")
+	// ErrPublishNotConfirmed is returned when the channel is closed before the broker confirmed the message.
+	ErrPublishNotConfirmed = errors.New("message not confirmed before the channel was closed")
+	// ErrPublishBatchFailed is returned by PublishBatch when some of the messages were not published.
+	ErrPublishBatchFailed = errors.New("some messages of the batch were not published")
+	// ErrDecode is returned by the typed handlers when the body cannot be decoded.
+	ErrDecode = errors.New("error decoding message")
+	// ErrUnsupportedContentType is returned when no codec is registered for the content type of a message.
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	// ErrUnsupportedContentEncoding is returned when a consumed message is compressed with a compression gorabbit does not support.
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+	// ErrEncryptedMessage is returned when a consumed message is encrypted but no secret is configured.
+	ErrEncryptedMessage = errors.New("message is encrypted but no secret is configured")
+	// ErrNonRetryable marks a handler error that sends the message to the dead-letter exchange without retrying it.
+	ErrNonRetryable = errors.New("non-retryable error")
+	// ErrConsumerPanic is returned by the Recovery middleware when the handler panicked.
+	ErrConsumerPanic = errors.New("consumer panic")
+	// ErrDuplicateInProgress is returned by a GoRabbitDedupStore when the message is being handled by another consumer.
+	ErrDuplicateInProgress = errors.New("message is already being handled")
+	// ErrQueueNotFound is returned by the queue controls when the queue is not consumed.
+	ErrQueueNotFound = errors.New("queue is not consumed")
+	// ErrQueueCancelled is returned by the queue controls when the consumers of the queue are cancelled.
+	ErrQueueCancelled = errors.New("queue consumers are cancelled")
+	// ErrInvalidSchema is returned by RegisterSchema when the schema cannot be used.
+	ErrInvalidSchema = errors.New("invalid schema")
+	// ErrSchemaValidation is returned when a message does not validate with the schema of its topic.
+	ErrSchemaValidation = errors.New("message does not match the schema")
+	// ErrSchemaVersion is returned when a consumed message cannot be upcast to the current schema version.
+	ErrSchemaVersion = errors.New("unsupported schema version")
+	// ErrReplyToRequired is returned by the reply handlers when the request has no reply-to address.
+	ErrReplyToRequired = errors.New("request has no reply-to address")
+	// ErrReplyLost is returned by Call when the reply channel is closed before the reply was received.
+	ErrReplyLost = errors.New("reply channel closed before the reply was received")
+)
+
+// NonRetryable is a function that marks a handler error as non-retryable.
+// It takes an error and returns an error.
+// The message is dead-lettered right away instead of going through the retry queues.
+func NonRetryable(err error) error {
+	if err == nil || errors.Is(err, ErrNonRetryable) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrNonRetryable, err)
+}
+
+// GoRabbitPublishError is a struct that represents a failed publish.
+// It is returned by Publish once the retries are used up, and wraps the error of the last attempt.
+type GoRabbitPublishError struct {
+	MessageId string
+	Topic     string
+	Attempts  int
+	Err       error
+}
+
+// Error is a function that returns the error message.
+// It takes nothing and returns a string.
+// This is used to implement the error interface.
+func (e *GoRabbitPublishError) Error() string {
+	return fmt.Sprintf(
+		"gorabbit: publishing message '%s' to topic '%s' failed after %d attempt(s): %v",
+		e.MessageId,
+		e.Topic,
+		e.Attempts,
+		e.Err,
+	)
+}
+
+// Unwrap is a function that returns the wrapped error.
+// It takes nothing and returns an error.
+// This is used to match the error with errors.Is and errors.As.
+func (e *GoRabbitPublishError) Unwrap() error {
+	return e.Err
+}
+
+// GoRabbitRemoteError is a struct that represents an error returned by the handler of a call.
+// It is returned by Call when the server replied with an error instead of a response.
+type GoRabbitRemoteError struct {
+	Topic   string
+	Message string
+}
+
+// Error is a function that returns the error message.
+// It takes nothing and returns a string.
+// This is used to implement the error interface.
+func (e *GoRabbitRemoteError) Error() string {
+	return fmt.Sprintf("gorabbit: call to topic '%s' failed: %s", e.Topic, e.Message)
+}
@@ -0,0 +1,252 @@
+package godedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/the-lanky/go-utils/gorabbit"
+	"github.com/the-lanky/go-utils/goredis"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	statusProcessing = "processing"
+	statusDone       = "done"
+
+	defaultPrefix        = "gorabbit:dedup:"
+	defaultTable         = "gorabbit_dedup"
+	defaultTTL           = 24 * time.Hour
+	defaultProcessingTTL = 5 * time.Minute
+)
+
+var (
+	// ErrUnsupportedRedis is returned by NewRedisStore when the Redis client cannot claim and release keys.
+	ErrUnsupportedRedis = errors.New("redis client does not support SaveIfNotExists and DeleteIfEquals")
+)
+
+// GoDedupConfiguration is a struct that represents the configuration for the dedup stores.
+// TTL is how long a handled message is remembered and should cover the redelivery window, and ProcessingTTL
+// is how long a claim is kept when the consumer dies before releasing it.
+type GoDedupConfiguration struct {
+	Prefix        string        `mapstructure:"prefix"`
+	Table         string        `mapstructure:"table"`
+	TTL           time.Duration `mapstructure:"ttl"`
+	ProcessingTTL time.Duration `mapstructure:"processingTtl"`
+}
+
+// withDefaults is a function that fills the zero values with the defaults.
+// It takes nothing and returns a GoDedupConfiguration.
+func (c GoDedupConfiguration) withDefaults() GoDedupConfiguration {
+	if strings.TrimSpace(c.Prefix) == "" {
+		c.Prefix = defaultPrefix
+	}
+	if strings.TrimSpace(c.Table) == "" {
+		c.Table = defaultTable
+	}
+	if c.TTL <= 0 {
+		c.TTL = defaultTTL
+	}
+	if c.ProcessingTTL <= 0 {
+		c.ProcessingTTL = defaultProcessingTTL
+	}
+	return c
+}
+
+// redisClient is an interface that defines the methods of the Redis client used by the Redis store.
+// The client returned by goredis.New implements it, though goredis.GoRedis does not declare all of its methods.
+type redisClient interface {
+	Save(ctx context.Context, key string, value any, ttl time.Duration) error
+	SaveIfNotExists(ctx context.Context, key string, value any, ttl time.Duration) (bool, error)
+	Get(ctx context.Context, key string, dest any) error
+	DeleteIfEquals(ctx context.Context, key string, value any) (bool, error)
+}
+
+// redisStore is a struct that implements the gorabbit.GoRabbitDedupStore interface with goredis.
+type redisStore struct {
+	rds  redisClient
+	conf GoDedupConfiguration
+}
+
+// NewRedisStore is a function that creates a new dedup store backed by goredis.
+// It takes a goredis.GoRedis and a GoDedupConfiguration and returns a gorabbit.GoRabbitDedupStore and an error.
+// A message is claimed with SETNX and the token of the claim, which is replaced by a done marker once it is
+// handled. An error wrapping ErrUnsupportedRedis is returned when the client does not implement SaveIfNotExists
+// and DeleteIfEquals, as the client of goredis.New does.
+//
+//	store, err := godedup.NewRedisStore(rds, godedup.GoDedupConfiguration{})
+//	if err != nil {
+//		return err
+//	}
+//	r.Use(gorabbit.Recovery(log), gorabbit.Dedup(store))
+func NewRedisStore(rds goredis.GoRedis, conf GoDedupConfiguration) (gorabbit.GoRabbitDedupStore, error) {
+	client, ok := rds.(redisClient)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedRedis, rds)
+	}
+
+	return &redisStore{
+		rds:  client,
+		conf: conf.withDefaults(),
+	}, nil
+}
+
+// Begin is a function that claims a key.
+// It takes a context and a key and returns the token of the claim, a bool, and an error.
+// The key holds the token while it is claimed, and the done marker once it is handled.
+func (s *redisStore) Begin(ctx context.Context, key string) (string, bool, error) {
+	k := s.conf.Prefix + key
+	token := uuid.New().String()
+
+	ok, err := s.rds.SaveIfNotExists(ctx, k, token, s.conf.ProcessingTTL)
+	if err != nil {
+		return "", false, err
+	}
+	if ok {
+		return token, true, nil
+	}
+
+	var status string
+	if err := s.rds.Get(ctx, k, &status); err != nil {
+		// The claim expired in between, so the redelivery will claim it.
+		if errors.Is(err, redis.Nil) {
+			return "", false, gorabbit.ErrDuplicateInProgress
+		}
+		return "", false, err
+	}
+
+	if status == statusDone {
+		return "", false, nil
+	}
+	return "", false, gorabbit.ErrDuplicateInProgress
+}
+
+// Commit is a function that marks a key as handled.
+// It takes a context and a key and returns an error.
+func (s *redisStore) Commit(ctx context.Context, key string) error {
+	return s.rds.Save(ctx, s.conf.Prefix+key, statusDone, s.conf.TTL)
+}
+
+// Abort is a function that releases a key.
+// It takes a context, a key, and the token of the claim and returns an error.
+// The key is only deleted while it holds the token, so a key claimed or handled by another consumer is kept.
+func (s *redisStore) Abort(ctx context.Context, key string, token string) error {
+	_, err := s.rds.DeleteIfEquals(ctx, s.conf.Prefix+key, token)
+	return err
+}
+
+// GoDedupPostgresStore is an interface that defines the methods for the dedup store backed by gopostgres.
+// It is used to create the dedup table and to delete the expired keys, besides the methods of the store.
+type GoDedupPostgresStore interface {
+	gorabbit.GoRabbitDedupStore
+	Migrate(ctx context.Context) error
+	Cleanup(ctx context.Context) error
+}
+
+// postgresStore is a struct that implements the GoDedupPostgresStore interface with gorm.
+type postgresStore struct {
+	db   *gorm.DB
+	conf GoDedupConfiguration
+}
+
+// NewPostgresStore is a function that creates a new dedup store backed by gopostgres.
+// It takes a pointer to a gorm.DB and a GoDedupConfiguration and returns a GoDedupPostgresStore.
+// A message is claimed with an INSERT ... ON CONFLICT that only takes over an expired key.
+// Cleanup should be called from time to time to delete the expired keys.
+func NewPostgresStore(db *gorm.DB, conf GoDedupConfiguration) GoDedupPostgresStore {
+	return &postgresStore{
+		db:   db,
+		conf: conf.withDefaults(),
+	}
+}
+
+// Migrate is a function that creates the dedup table.
+// It takes a context and returns an error.
+func (s *postgresStore) Migrate(ctx context.Context) error {
+	if err := s.db.WithContext(ctx).Exec(fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			key TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			token TEXT NOT NULL DEFAULT '',
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		s.conf.Table,
+	)).Error; err != nil {
+		return fmt.Errorf("error migrating dedup table: '%s': %w", s.conf.Table, err)
+	}
+	return nil
+}
+
+// Begin is a function that claims a key.
+// It takes a context and a key and returns the token of the claim, a bool, and an error.
+func (s *postgresStore) Begin(ctx context.Context, key string) (string, bool, error) {
+	now := time.Now()
+	token := uuid.New().String()
+
+	res := s.db.WithContext(ctx).Exec(fmt.Sprintf(
+		`INSERT INTO %[1]s (key, status, token, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE
+		SET status = EXCLUDED.status, token = EXCLUDED.token, expires_at = EXCLUDED.expires_at
+		WHERE %[1]s.expires_at < ?`,
+		s.conf.Table,
+	), key, statusProcessing, token, now.Add(s.conf.ProcessingTTL), now)
+	if res.Error != nil {
+		return "", false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return token, true, nil
+	}
+
+	var status string
+	if err := s.db.WithContext(ctx).
+		Table(s.conf.Table).
+		Select("status").
+		Where("key = ?", key).
+		Scan(&status).Error; err != nil {
+		return "", false, err
+	}
+
+	if status == statusDone {
+		return "", false, nil
+	}
+	return "", false, gorabbit.ErrDuplicateInProgress
+}
+
+// Commit is a function that marks a key as handled.
+// It takes a context and a key and returns an error.
+func (s *postgresStore) Commit(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).
+		Table(s.conf.Table).
+		Where("key = ?", key).
+		Updates(map[string]any{
+			"status":     statusDone,
+			"expires_at": time.Now().Add(s.conf.TTL),
+		}).Error
+}
+
+// Abort is a function that releases a key.
+// It takes a context, a key, and the token of the claim and returns an error.
+// The key is only deleted while it holds the claim of the token.
+func (s *postgresStore) Abort(ctx context.Context, key string, token string) error {
+	return s.db.WithContext(ctx).
+		Exec(
+			fmt.Sprintf("DELETE FROM %s WHERE key = ? AND status = ? AND token = ?", s.conf.Table),
+			key,
+			statusProcessing,
+			token,
+		).
+		Error
+}
+
+// Cleanup is a function that deletes the expired keys.
+// It takes a context and returns an error.
+func (s *postgresStore) Cleanup(ctx context.Context) error {
+	return s.db.WithContext(ctx).
+		Exec(fmt.Sprintf("DELETE FROM %s WHERE expires_at < ?", s.conf.Table), time.Now()).
+		Error
+}
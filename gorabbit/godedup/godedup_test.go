@@ -0,0 +1,215 @@
+package godedup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/the-lanky/go-utils/gorabbit"
+	"github.com/the-lanky/go-utils/goredis"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var _ goredis.GoRedis = (*fakeRedis)(nil)
+
+// fakeRedis is a goredis.GoRedis keeping the values in memory, encoded in JSON as goredis does.
+type fakeRedis struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{keys: make(map[string]string)}
+}
+
+func (f *fakeRedis) Save(_ context.Context, key string, value any, _ time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	f.keys[key] = string(b)
+	return nil
+}
+
+func (f *fakeRedis) SaveIfNotExists(_ context.Context, key string, value any, _ time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.keys[key]; ok {
+		return false, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	f.keys[key] = string(b)
+	return true, nil
+}
+
+func (f *fakeRedis) Get(_ context.Context, key string, dest any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, ok := f.keys[key]
+	if !ok {
+		return redis.Nil
+	}
+	return json.Unmarshal([]byte(v), dest)
+}
+
+func (f *fakeRedis) Delete(_ context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.keys, key)
+	return nil
+}
+
+func (f *fakeRedis) DeleteIfEquals(_ context.Context, key string, value any) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	if v, ok := f.keys[key]; !ok || v != string(b) {
+		return false, nil
+	}
+	delete(f.keys, key)
+	return true, nil
+}
+
+func (f *fakeRedis) DeleteByPattern(context.Context, string, int64) error {
+	return errors.New("not implemented")
+}
+
+// newTestRedisStore returns a Redis store backed by a fakeRedis.
+func newTestRedisStore(t *testing.T, f *fakeRedis) gorabbit.GoRabbitDedupStore {
+	t.Helper()
+
+	s, err := NewRedisStore(f, GoDedupConfiguration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// plainRedis is a goredis.GoRedis without the methods claiming and releasing keys.
+type plainRedis struct {
+	goredis.GoRedis
+}
+
+func TestNewRedisStoreUnsupported(t *testing.T) {
+	if _, err := NewRedisStore(plainRedis{}, GoDedupConfiguration{}); !errors.Is(err, ErrUnsupportedRedis) {
+		t.Fatalf("NewRedisStore error = %v, want ErrUnsupportedRedis", err)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	s := newTestRedisStore(t, newFakeRedis())
+
+	token, ok, err := s.Begin(ctx, "orders:1")
+	if err != nil || !ok || token == "" {
+		t.Fatalf("Begin = %q, %v, %v, want the claim", token, ok, err)
+	}
+
+	if _, _, err := s.Begin(ctx, "orders:1"); !errors.Is(err, gorabbit.ErrDuplicateInProgress) {
+		t.Fatalf("Begin of a claimed key error = %v, want ErrDuplicateInProgress", err)
+	}
+
+	if err := s.Commit(ctx, "orders:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok, err = s.Begin(ctx, "orders:1")
+	if err != nil || ok {
+		t.Fatalf("Begin of a handled key = %v, %v, want a duplicate", ok, err)
+	}
+}
+
+func TestRedisStoreAbort(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("in progress", func(t *testing.T) {
+		s := newTestRedisStore(t, newFakeRedis())
+
+		token, _, err := s.Begin(ctx, "orders:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Abort(ctx, "orders:1", token); err != nil {
+			t.Fatal(err)
+		}
+
+		_, ok, err := s.Begin(ctx, "orders:1")
+		if err != nil || !ok {
+			t.Fatalf("Begin of a released key = %v, %v, want the claim", ok, err)
+		}
+	})
+
+	t.Run("done", func(t *testing.T) {
+		s := newTestRedisStore(t, newFakeRedis())
+
+		token, _, err := s.Begin(ctx, "orders:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Commit(ctx, "orders:1"); err != nil {
+			t.Fatal(err)
+		}
+
+		// A late abort, e.g. of a consumer whose claim expired, must keep the done marker.
+		if err := s.Abort(ctx, "orders:1", token); err != nil {
+			t.Fatal(err)
+		}
+
+		_, ok, err := s.Begin(ctx, "orders:1")
+		if err != nil || ok {
+			t.Fatalf("Begin of a handled key = %v, %v, want a duplicate", ok, err)
+		}
+	})
+
+	t.Run("claimed by another consumer", func(t *testing.T) {
+		f := newFakeRedis()
+		s := newTestRedisStore(t, f)
+
+		stale, _, err := s.Begin(ctx, "orders:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The claim expires, and another consumer claims the redelivery.
+		if err := f.Delete(ctx, defaultPrefix+"orders:1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := s.Begin(ctx, "orders:1"); err != nil || !ok {
+			t.Fatalf("Begin of an expired key = %v, %v, want the claim", ok, err)
+		}
+
+		// The abort of the first consumer must keep the claim of the other one.
+		if err := s.Abort(ctx, "orders:1", stale); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := s.Begin(ctx, "orders:1"); !errors.Is(err, gorabbit.ErrDuplicateInProgress) {
+			t.Fatalf("Begin of a claimed key error = %v, want ErrDuplicateInProgress", err)
+		}
+	})
+}
+
+func TestConfigurationDefaults(t *testing.T) {
+	c := GoDedupConfiguration{}.withDefaults()
+
+	if c.Prefix != defaultPrefix || c.Table != defaultTable || c.TTL != defaultTTL || c.ProcessingTTL != defaultProcessingTTL {
+		t.Fatalf("withDefaults = %+v", c)
+	}
+}
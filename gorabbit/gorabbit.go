@@ -2,257 +2,201 @@ package gorabbit
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/json"
-	"errors"
+	"crypto/tls"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/the-lanky/go-utils/goencrypt"
 	"github.com/the-lanky/go-utils/gologger"
 
-	"github.com/google/uuid"
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
 )
 
 // ConsumerFunc is a type that represents the consumer function.
 // It is used to represent the consumer function.
-type ConsumerFunc func(msg amqp091.Delivery) error
+// The context is cancelled when the shutdown deadline is reached before the handler returned.
+type ConsumerFunc func(ctx context.Context, msg amqp091.Delivery) error
 
 // GoRabbitConsumer is a struct that represents the consumer.
 // It is used to represent the consumer.
+// Middlewares wrap Consume for this topic only, inside the global and the queue middlewares.
 type GoRabbitConsumer struct {
-	Consume ConsumerFunc
+	Consume     ConsumerFunc
+	Middlewares []ConsumerMiddleware
 }
 
 // GoRabbitPublisherOption is a struct that represents the publisher option.
 // It is used to represent the publisher option.
+// MessageId defaults to a new UUID. A fixed id lets the consumers deduplicate a message published more than once.
+// ContentType picks the Codec of the message, and defaults to the content type configured for the topic.
+// Delay, or DelayUntil which takes precedence, holds the message in a delay queue of the broker before it is
+// routed to the exchange.
+// The messages are persistent unless Transient is set, and Timestamp defaults to the publish time. Expiration
+// drops the message once it waited that long in a queue, and is not applied to a delayed message. RawHeaders
+// carries the headers that are not strings, and takes precedence over Headers.
 type GoRabbitPublisherOption struct {
-	Topic      string
-	Message    any
-	UserId     string
-	AppId      string
-	Retries    int
-	RetryDelay int
+	MessageId     string
+	Exchange      string
+	Topic         string
+	Message       any
+	ContentType   string
+	UserId        string
+	AppId         string
+	Headers       map[string]string
+	RawHeaders    amqp091.Table
+	Transient     bool
+	Priority      uint8
+	Expiration    time.Duration
+	Timestamp     time.Time
+	Type          string
+	ReplyTo       string
+	CorrelationId string
+	Delay         time.Duration
+	DelayUntil    time.Time
+	Retries       int
+	RetryDelay    int
 }
 
 // Publisher is an interface that defines the methods for the publisher.
 // It is used to define the methods for the publisher.
 type Publisher interface {
 	Publish(ctx context.Context, opt GoRabbitPublisherOption) error
+	PublishBatch(ctx context.Context, opts []GoRabbitPublisherOption) ([]GoRabbitPublishResult, error)
 }
 
 // GoRabbitConsumerMessages is a type that represents the consumer messages.
 // It is used to represent the consumer messages.
+// The topics are bound as binding keys, so they can use the "*" and "#" wildcards. A message is handled by the
+// consumer of its routing key, or else of the most specific topic matching it, or else by the FallbackTopic consumer.
 type GoRabbitConsumerMessages map[string]map[string]GoRabbitConsumer
 
 // GoRabbit is an interface that defines the methods for the GoRabbit.
 // It is used to define the methods for the GoRabbit.
 type GoRabbit interface {
 	Publisher() Publisher
-	Listen(consumers GoRabbitConsumerMessages)
+	Call(ctx context.Context, topic string, req any, resp any) error
+	Use(mw ...ConsumerMiddleware)
+	RegisterSchema(topic string, schema GoRabbitSchema) error
+	Listen(ctx context.Context, consumers GoRabbitConsumerMessages) error
+	Pause(queue string) error
+	Resume(queue string) error
+	Cancel(queue string) error
+	Stats() []GoRabbitQueueStats
+	State() GoRabbitConnectionState
+	NotifyState() <-chan GoRabbitConnectionEvent
+	Shutdown(ctx context.Context) error
 	Close()
 }
 
 // rbt is a struct that represents the rabbitmq.
 // It is used to represent the rabbitmq.
 type rbt struct {
+	mu                    sync.RWMutex
 	acn                   *amqp091.Connection
 	ach                   *amqp091.Channel
+	pub                   confirmPublisher
+	rpc                   rpcClient
 	log                   *logrus.Logger
 	withMessageEncryption bool
 	cr                    *crypto
 	conf                  GoRabbitConfiguration
+	dsn                   string
+	tls                   *tls.Config
+	listeners             []*listener
+	middlewares           []ConsumerMiddleware
+	delayMu               sync.Mutex
+	delays                map[string]time.Time
+	queues                queueRegistry
+	schemas               schemaRegistry
+	closing               bool
+	done                  chan struct{}
+
+	stateMu   sync.Mutex
+	state     GoRabbitConnectionState
+	notifiers []chan GoRabbitConnectionEvent
 }
 
-// Publish is a function that publishes the message.
-// It takes a context and a GoRabbitPublisherOption and returns an error.
-// This is used to publish the message.
-func (r *rbt) Publish(
-	ctx context.Context,
-	opt GoRabbitPublisherOption,
-) error {
-	r.log.Info("[GoRabbit] Publishing message...")
-
-	if r.trimSpace(opt.Topic) == "" {
-		return errors.New("topic is required")
-	}
+// Listen is a function that listens to the messages.
+// It takes a context and a GoRabbitConsumerMessages and returns an error.
+// This is used to listen to the messages.
+// The consumers are remembered so they can be declared and started again after a reconnection.
+// When the context is done the consumers are cancelled, the running handlers finish, and the
+// messages that were prefetched but not handled yet are requeued.
+func (r *rbt) Listen(ctx context.Context, consumers GoRabbitConsumerMessages) error {
+	l := newListener(ctx, consumers)
 
-	if opt.Message == nil {
-		return errors.New("message is required")
+	for queue := range consumers {
+		r.queues.register(queue)
 	}
 
-	var (
-		sb         = 1
-		sbInterval = 2000 * time.Millisecond
-		idxProcess = 0
-		success    = false
-		uid        = uuid.New().String()
-
-		mut sync.Mutex
-		err error
-	)
-
-	if opt.Retries > 0 {
-		sb = opt.Retries
+	r.mu.Lock()
+	if r.closing {
+		r.mu.Unlock()
+		return ErrNotConnected
 	}
+	conn, ch := r.acn, r.ach
+	r.mu.Unlock()
 
-	if opt.RetryDelay > 0 {
-		sbInterval = time.Duration(opt.RetryDelay) * time.Millisecond
+	if err := r.listen(conn, ch, l); err != nil {
+		l.stop(r)
+		r.log.Errorf("[GoRabbit] %s", err.Error())
+		return err
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-
-	for ok := true; ok; ok = idxProcess < sb && !success {
-		mut.Lock()
-
-		r.log.Infof(
-			"[GoRabbit] [%d] [%s] Publishing topic: '%s'",
-			idxProcess,
-			uid,
-			opt.Topic,
-		)
-		if r.conf.Debug {
-			r.log.Debug(opt.Message)
-		}
-
-		var (
-			msg  []byte
-			err2 error
-		)
-
-		if r.withMessageEncryption {
-			msg, err2 = r.cr.encrypt(opt.Message)
-			if err2 != nil {
-				r.log.Errorf(
-					"[GoRabbit] [%d] [%s] Error encrypting message: %s",
-					idxProcess,
-					uid,
-					err2.Error(),
-				)
-				idxProcess++
-				err = err2
-				mut.Unlock()
-				time.Sleep(sbInterval)
-				continue
-			}
-		} else {
-			msg, err2 = r.cr.toBytes(opt.Message)
-			if err2 != nil {
-				r.log.Errorf(
-					"[GoRabbit] [%d] [%s] Error converting message to bytes: %s",
-					idxProcess,
-					uid,
-					err2.Error(),
-				)
-				idxProcess++
-				err = err2
-				mut.Unlock()
-				time.Sleep(sbInterval)
-				continue
-			}
-		}
-
-		err2 = r.ach.PublishWithContext(
-			ctx,
-			"exchange",
-			opt.Topic,
-			false,
-			false,
-			amqp091.Publishing{
-				ContentType: "text/plain",
-				MessageId:   uid,
-				Body:        msg,
-			},
-		)
-		if err2 != nil {
-			r.log.Errorf(
-				"[GoRabbit] [%d] [%s] Error publishing message: %s",
-				idxProcess,
-				uid,
-				err2.Error(),
-			)
-			idxProcess++
-			err = err2
-			mut.Unlock()
-			time.Sleep(sbInterval)
-			continue
+	r.mu.Lock()
+	r.listeners = append(r.listeners, l)
+	r.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.log.Info("[GoRabbit] Listen context done, stopping consumers...")
+			r.removeListener(l)
+			l.stop(r)
+			l.wait()
+			l.cancel()
+		case <-l.done:
 		}
-
-		success = true
-		mut.Unlock()
-	}
-
-	if err != nil && !success {
-		r.log.Errorf(
-			"[GoRabbit] [%s] Error publishing message. Attempts: %d/%d",
-			uid,
-			idxProcess,
-			sb,
-		)
-	} else {
-		r.log.Infof(
-			"[GoRabbit] [%s] Message published successfully",
-			uid,
-		)
-	}
+	}()
 
 	return nil
 }
 
-// Listen is a function that listens to the messages.
-// It takes a GoRabbitConsumerMessages and returns nothing.
-// This is used to listen to the messages.
-func (r *rbt) Listen(consumers GoRabbitConsumerMessages) {
-	exc := "exchange"
-
-	if err := r.ach.ExchangeDeclare(
-		exc,
-		"topic",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	); err != nil {
-		r.log.Fatalf("[GoRabbit] Error declaring exchange: %s", err.Error())
+// listen is a function that declares the topology and starts the consumers on a connection.
+// It takes a pointer to an amqp091.Connection, a pointer to an amqp091.Channel, and a pointer to a listener and returns an error.
+// The topology is declared on the channel, and every queue is consumed on its own channel.
+// This is used by Listen and by the reconnection loop.
+func (r *rbt) listen(
+	conn *amqp091.Connection,
+	ch *amqp091.Channel,
+	l *listener,
+) error {
+	if err := r.declareExchanges(ch); err != nil {
+		return err
 	}
 
-	for queue := range consumers {
-		q, err := r.ach.QueueDeclare(
-			queue,
-			true,
-			false,
-			false,
-			false,
-			nil,
-		)
+	for queue := range l.consumers {
+		q, err := r.declareQueue(ch, queue)
 		if err != nil {
-			r.log.Fatalf("[GoRabbit] Error declaring queue: '%s'", err.Error())
+			return err
 		} else {
 			r.log.Infof("[GoRabbit] Group queue declared: '%s'", q.Name)
 		}
 
-		topics := consumers[queue]
+		if err := r.declareRetryTopology(ch, q.Name); err != nil {
+			return err
+		}
+
+		topics := l.consumers[queue]
 		for topic := range topics {
-			if err := r.ach.QueueBind(
-				q.Name,
-				topic,
-				"exchange",
-				false,
-				nil,
-			); err != nil {
-				r.log.Fatalf(
-					"[GoRabbit] Error binding queue: '%s' -> '%s'",
-					q.Name,
-					topic,
-				)
+			if topic == FallbackTopic {
+				continue
+			}
+			if err := r.bindQueue(ch, q.Name, topic); err != nil {
+				return err
 			} else {
 				r.log.Infof(
 					"[GoRabbit] Group queue binded: '%s' -> '%s'",
@@ -262,132 +206,126 @@ func (r *rbt) Listen(consumers GoRabbitConsumerMessages) {
 			}
 		}
 
-		messages, err := r.ach.Consume(
-			q.Name,
-			"",
-			true,
-			false,
-			false,
-			false,
-			nil,
-		)
-		if err != nil {
-			r.log.Fatalf("[GoRabbit] Error consuming queue: '%s'", err.Error())
+		// A paused or cancelled queue keeps its topology but is not consumed.
+		if state := r.queues.state(q.Name); state != GoRabbitQueueRunning {
+			r.log.Infof("[GoRabbit] Queue '%s' not started, it is %s", queue, state)
+			continue
 		}
 
-		go r.consumeMessages(consumers, queue, messages)
+		if err := r.consume(conn, l, q.Name, topics); err != nil {
+			return err
+		}
 
 		r.log.Infof("[GoRabbit] Queue '%s' started", queue)
 	}
+
+	return nil
 }
 
-// consumeMessages is a function that consumes the messages.
-// It takes a GoRabbitConsumerMessages, a string, and a channel of amqp091.Delivery and returns nothing.
-// This is used to consume the messages.
-func (r *rbt) consumeMessages(
-	consumers GoRabbitConsumerMessages,
-	queue string,
-	msgs <-chan amqp091.Delivery,
-) {
-	var (
-		topic     string
-		messageId string
-		mu        sync.Mutex
-
-		delay = 5000 * time.Millisecond
-	)
-
-	defer func(id *string, t *string) {
-		if rc := recover(); rc != nil {
-			r.log.Errorf(
-				"[GoRabbit] [%s] [%s] Consumer panic: %v",
-				*id,
-				*t,
-				rc,
-			)
-			r.log.Info("[GoRabbit] Rejoin rabbitmq...")
-			time.Sleep(delay)
-			r.Listen(consumers)
+// removeListener is a function that forgets a listener.
+// It takes a pointer to a listener and returns nothing.
+// This is used so a stopped listener is not started again after a reconnection.
+func (r *rbt) removeListener(l *listener) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, x := range r.listeners {
+		if x == l {
+			r.listeners = append(r.listeners[:i], r.listeners[i+1:]...)
+			return
 		}
-	}(&messageId, &topic)
-
-	for msg := range msgs {
-		mu.Lock()
-
-		messageId = msg.MessageId
-		topic = msg.RoutingKey
+	}
+}
 
-		r.log.Infof(
-			"[GoRabbit] [%s] [%s] Consuming topic...",
-			messageId,
-			topic,
-		)
+// Shutdown is a function that gracefully closes the rabbitmq.
+// It takes a context and returns an error.
+// The consumers are cancelled first, then the running handlers are awaited until the context is done,
+// and only then the channels and the connection are closed. When the context is done first, the
+// context of the running handlers is cancelled and the context error is returned.
+func (r *rbt) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	if r.closing {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closing = true
+	close(r.done)
+	ach, acn := r.ach, r.acn
+	listeners := r.listeners
+	r.listeners = nil
+	r.mu.Unlock()
 
-		qu := consumers[queue]
+	r.log.Info("[GoRabbit] Shutting down...")
 
-		if _, ok := qu[topic]; !ok {
-			r.log.Errorf(
-				"[GoRabbit] [%s] [%s] Consumer not found",
-				messageId,
-				topic,
-			)
-			mu.Unlock()
-			continue
-		}
+	for _, l := range listeners {
+		l.stop(r)
+	}
 
-		if r.withMessageEncryption {
-			decrypted, err := r.cr.decrypt(string(msg.Body))
-			if err != nil {
-				r.log.Errorf(
-					"[GoRabbit] [%s] [%s] Error decrypting message: %s",
-					messageId,
-					topic,
-					err.Error(),
-				)
-				mu.Unlock()
-				continue
-			}
-			msg.Body = decrypted
+	drained := make(chan struct{})
+	go func() {
+		for _, l := range listeners {
+			l.wait()
 		}
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		r.log.Info("[GoRabbit] Consumers drained successfully")
+	case <-ctx.Done():
+		err = ctx.Err()
+		r.log.Errorf("[GoRabbit] Consumers not drained before the deadline: %s", err.Error())
+	}
 
-		if r.conf.Debug {
-			r.log.Info(string(msg.Body))
-		}
+	for _, l := range listeners {
+		l.cancel()
+	}
 
-		if err := qu[topic].Consume(msg); err != nil {
-			r.log.Errorf(
-				"[GoRabbit] [%s] [%s] Error consuming message: %s",
-				messageId,
-				topic,
-				err.Error(),
-			)
-			mu.Unlock()
-			continue
-		}
+	if err := r.pub.close(); err != nil {
+		r.log.Errorf("[GoRabbit] Error closing publisher connection: %s", err.Error())
+	}
 
-		mu.Unlock()
+	if err := r.rpc.close(); err != nil {
+		r.log.Errorf("[GoRabbit] Error closing reply channel: %s", err.Error())
 	}
-}
 
-// Close is a function that closes the rabbitmq.
-// It takes nothing and returns nothing.
-// This is used to close the rabbitmq.
-func (r *rbt) Close() {
-	if r.ach != nil {
-		if err := r.ach.Close(); err != nil {
+	if ach != nil {
+		if err := ach.Close(); err != nil {
 			r.log.Errorf("[GoRabbit] Error closing channel: %s", err.Error())
 		} else {
 			r.log.Info("[GoRabbit] Channel closed successfully")
 		}
 	}
 
-	if r.acn != nil {
-		if err := r.acn.Close(); err != nil {
+	if acn != nil {
+		if err := acn.Close(); err != nil {
 			r.log.Errorf("[GoRabbit] Error closing connection: %s", err.Error())
 		} else {
 			r.log.Info("[GoRabbit] Connection closed successfully")
 		}
 	}
+
+	r.setState(GoRabbitStateClosed, 0, nil)
+	r.closeNotifiers()
+
+	return err
+}
+
+// Close is a function that closes the rabbitmq.
+// It takes nothing and returns nothing.
+// This is used to close the rabbitmq.
+// It calls Shutdown with the configured shutdown timeout.
+func (r *rbt) Close() {
+	timeout := defaultShutdownTimeout
+	if r.conf.ShutdownTimeout > 0 {
+		timeout = r.conf.ShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_ = r.Shutdown(ctx)
 }
 
 // trimSpace is a function that trims the space from the string.
@@ -406,6 +344,16 @@ func (r *rbt) Publisher() Publisher {
 
 // GoRabbitConfiguration is a struct that represents the configuration for the GoRabbit.
 // It is used to represent the configuration for the GoRabbit.
+// URL takes precedence over Host, Port, User, and Password, and VHost over the virtual host of the URL.
+// The connection uses AMQPS when the URL scheme is amqps or TLS is set. Heartbeat is 10 seconds by default,
+// and ConnectionName is shown in the management UI.
+// The messages are published on a connection of their own with a pool of PublisherChannels channels, 16 by default.
+// LegacyIV is the base64 IV of the messages encrypted in the legacy format, which are only decrypted with it.
+// ContentType is the content type of the published messages, JSON by default, and ContentTypes
+// overrides it by topic. Compression is the compression of the published messages, "gzip" or "zstd", and
+// Compressions overrides it by topic, "identity" disabling it. The messages are compressed before they are
+// encrypted, from CompressionThreshold bytes, 1024 by default.
+// The delays of the delayed messages are rounded up to DelayPrecision, 1 second by default.
 type GoRabbitConfiguration struct {
 	Host     string `mapstructure:"host"`
 	Port     string `mapstructure:"port"`
@@ -413,11 +361,49 @@ type GoRabbitConfiguration struct {
 	Password string `mapstructure:"password"`
 	Secret   string `mapstructure:"secret"`
 	Debug    bool   `mapstructure:"debug"`
+
+	URL            string                   `mapstructure:"url"`
+	VHost          string                   `mapstructure:"vhost"`
+	TLS            GoRabbitTLSConfiguration `mapstructure:"tls"`
+	Heartbeat      time.Duration            `mapstructure:"heartbeat"`
+	FrameSize      int                      `mapstructure:"frameSize"`
+	ChannelMax     uint16                   `mapstructure:"channelMax"`
+	ConnectionName string                   `mapstructure:"connectionName"`
+
+	PublisherChannels int `mapstructure:"publisherChannels"`
+
+	SecretKeyId             string          `mapstructure:"secretKeyId"`
+	PreviousSecrets         []goencrypt.Key `mapstructure:"previousSecrets"`
+	LegacyIV                string          `mapstructure:"legacyIv"`
+	DisableLegacyDecryption bool            `mapstructure:"disableLegacyDecryption"`
+
+	ReconnectDelay    time.Duration `mapstructure:"reconnectDelay"`
+	MaxReconnectDelay time.Duration `mapstructure:"maxReconnectDelay"`
+	ShutdownTimeout   time.Duration `mapstructure:"shutdownTimeout"`
+	RPCTimeout        time.Duration `mapstructure:"rpcTimeout"`
+
+	ContentType  string            `mapstructure:"contentType"`
+	ContentTypes map[string]string `mapstructure:"contentTypes"`
+
+	Compression          string            `mapstructure:"compression"`
+	Compressions         map[string]string `mapstructure:"compressions"`
+	CompressionThreshold int               `mapstructure:"compressionThreshold"`
+
+	Retry              GoRabbitRetryConfiguration `mapstructure:"retry"`
+	DeadLetterExchange string                     `mapstructure:"deadLetterExchange"`
+
+	DelayExchange  string        `mapstructure:"delayExchange"`
+	DelayPrecision time.Duration `mapstructure:"delayPrecision"`
+
+	Exchange  string                   `mapstructure:"exchange"`
+	Exchanges []GoRabbitExchange       `mapstructure:"exchanges"`
+	Queues    map[string]GoRabbitQueue `mapstructure:"queues"`
 }
 
 // New is a function that creates a new GoRabbit.
 // It takes a GoRabbitConfiguration and a pointer to a logrus.Logger and returns a GoRabbit.
 // This is used to create a new GoRabbit.
+// It exits the process when the connection fails, see Connect for the error-returning version.
 func New(
 	opt GoRabbitConfiguration,
 	log *logrus.Logger,
@@ -427,138 +413,90 @@ func New(
 		log = gologger.Logger
 	}
 
-	dsn := fmt.Sprintf(
-		"amqp://%s:%s@%s:%s/",
-		opt.User,
-		opt.Password,
-		opt.Host,
-		opt.Port,
-	)
-
-	conn, err := amqp091.Dial(dsn)
+	r, err := Connect(context.Background(), opt, log)
 	if err != nil {
-		log.Fatalf("[GoRabbit] Error connecting to RabbitMQ: %s", err.Error())
+		log.Fatalf("[GoRabbit] %s", err.Error())
 	}
 
-	ch, err := conn.Channel()
-	if err != nil {
-		log.Fatalf("[GoRabbit] Error opening channel: %s", err.Error())
-	}
+	return r
+}
 
-	if len(opt.Secret) > 0 {
-		if len(opt.Secret) < 24 {
-			log.Fatalf("[GoRabbit] Secret must be at least 24 characters long")
-		}
+// Connect is a function that creates a new GoRabbit.
+// It takes a context, a GoRabbitConfiguration, and a pointer to a logrus.Logger and returns a GoRabbit and an error.
+// The context bounds the first connection only, the reconnections are not bound by it.
+// It returns an error wrapping ErrInvalidConfig when the URL, the TLS settings, or a compression cannot be used,
+// ErrSecretTooShort or an error wrapping ErrInvalidSecret when the secret cannot be used,
+// an error wrapping ErrConnectionFailed when the broker cannot be reached, and an error wrapping
+// ErrTopologyFailed when the exchanges cannot be declared.
+func Connect(
+	ctx context.Context,
+	opt GoRabbitConfiguration,
+	log *logrus.Logger,
+) (GoRabbit, error) {
+	if log == nil {
+		gologger.New()
+		log = gologger.Logger
 	}
 
-	withMessageEncryption := len(opt.Secret) > 0
-
-	return &rbt{
-		acn:                   conn,
-		ach:                   ch,
-		log:                   log,
-		withMessageEncryption: withMessageEncryption,
-		cr:                    initCrypto(opt.Secret),
-		conf:                  opt,
+	uri, err := opt.uri()
+	if err != nil {
+		return nil, err
 	}
-}
-
-// crypto is a struct that represents the crypto.
-// It is used to represent the crypto.
-type crypto struct {
-	secret string
-	size   []byte
-}
-
-// toBytes is a function that converts the data to bytes.
-// It takes a any and returns a []byte and an error.
-// This is used to convert the data to bytes.
-func (c *crypto) toBytes(data any) ([]byte, error) {
-	return json.Marshal(data)
-}
-
-// encode is a function that encodes the data to base64.
-// It takes a []byte and returns a string.
-// This is used to encode the data to base64.
-func (c *crypto) encode(data []byte) string {
-	return base64.StdEncoding.EncodeToString(data)
-}
 
-// decode is a function that decodes the data from base64.
-// It takes a string and returns a []byte and an error.
-// This is used to decode the data from base64.
-func (c *crypto) decode(data string) ([]byte, error) {
-	return base64.StdEncoding.DecodeString(data)
-}
-
-// getBlock is a function that returns the block.
-// It takes nothing and returns a cipher.Block and an error.
-// This is used to return the block.
-func (c *crypto) getBlock() (cipher.Block, error) {
-	return aes.NewCipher([]byte(c.secret))
-}
-
-// encrypt is a function that encrypts the message.
-// It takes a any and returns a []byte and an error.
-// This is used to encrypt the message.
-func (c *crypto) encrypt(message any) ([]byte, error) {
-	var (
-		enc  string
-		encB []byte
-		err  error
-	)
-
-	b, err := c.toBytes(message)
+	tlsConf, err := opt.TLS.config()
 	if err != nil {
 		return nil, err
 	}
 
-	blk, err := c.getBlock()
-	if err != nil {
+	if err := validateCompression(opt); err != nil {
 		return nil, err
 	}
 
-	cfb := cipher.NewCFBEncrypter(blk, c.size)
-	cText := make([]byte, len(b))
-	cfb.XORKeyStream(cText, b)
+	cr, err := initCrypto(opt)
+	if err != nil {
+		return nil, err
+	}
 
-	enc = c.encode(cText)
-	encB = []byte(enc)
+	r := &rbt{
+		log:                   log,
+		withMessageEncryption: cr != nil,
+		cr:                    cr,
+		conf:                  opt,
+		dsn:                   uri.String(),
+		tls:                   tlsConf,
+		done:                  make(chan struct{}),
+	}
 
-	return encB, nil
-}
+	r.setState(GoRabbitStateConnecting, 0, nil)
 
-// decrypt is a function that decrypts the message.
-// It takes a string and returns a []byte and an error.
-// This is used to decrypt the message.
-func (c *crypto) decrypt(message string) ([]byte, error) {
-	blk, err := c.getBlock()
+	conn, ch, err := r.dial(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	cText, err := c.decode(message)
+	pconn, err := r.dialPublisher(ctx)
 	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
 
-	cfb := cipher.NewCFBDecrypter(blk, c.size)
-	plain := make([]byte, len(cText))
-	cfb.XORKeyStream(plain, cText)
+	if err := r.pub.open(pconn, opt.PublisherChannels); err != nil {
+		_ = conn.Close()
+		_ = pconn.Close()
+		return nil, fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+	}
 
-	return plain, nil
-}
+	if err := r.declareExchanges(ch); err != nil {
+		_ = conn.Close()
+		_ = pconn.Close()
+		return nil, fmt.Errorf("%w: %w", ErrTopologyFailed, err)
+	}
 
-// initCrypto is a function that initializes the crypto.
-// It takes a string and returns a *crypto.
-// This is used to initialize the crypto.
-func initCrypto(secret string) *crypto {
-	bb := make([]byte, 16)
-	rand.Read(bb)
+	r.acn = conn
+	r.ach = ch
+	r.setState(GoRabbitStateConnected, 0, nil)
 
-	if len(strings.TrimSpace(secret)) < 24 {
-		panic("secret for encryption must be at least 24 characters long")
-	}
+	go r.watch(conn, pconn, ch)
 
-	return &crypto{secret: secret, size: bb}
+	return r, nil
 }
@@ -0,0 +1,149 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestShutdownDrainsHandlers(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(context.Context, amqp091.Delivery) error {
+				close(started)
+				<-release
+				return nil
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+
+	sctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := m.Shutdown(sctx); err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+
+	// The running handler finished before Shutdown returned.
+	if n := len(m.Acked()); n != 1 {
+		t.Fatalf("acked %d messages, want 1", n)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "hello"}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Publish after Shutdown error = %v, want ErrNotConnected", err)
+	}
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{"orders": {"order.created": {}}}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Listen after Shutdown error = %v, want ErrNotConnected", err)
+	}
+	if err := m.Shutdown(ctx); err != nil {
+		t.Errorf("second Shutdown = %v", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(hctx context.Context, _ amqp091.Delivery) error {
+				close(started)
+				<-hctx.Done()
+				close(cancelled)
+				return hctx.Err()
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	sctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(sctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The handlers still running at the deadline get their context cancelled.
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler context was not cancelled")
+	}
+}
+
+func TestListenContext(t *testing.T) {
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(context.Context, amqp091.Delivery) error { return nil }},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	// The queue stays bound, and the messages wait in it once the consumers stopped.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_ = m.Publish(context.Background(), GoRabbitPublisherOption{Topic: "order.created", Message: "hello"})
+		waitIdle(t, m)
+		if len(m.Queued("orders")) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the consumers did not stop with the context")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShutdownNotConnected(t *testing.T) {
+	r := &rbt{log: testLogger(), done: make(chan struct{})}
+	ch := r.NotifyState()
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	if s := r.State(); s != GoRabbitStateClosed {
+		t.Fatalf("State = %s, want closed", s)
+	}
+	if evt := nextEvent(t, ch); evt.State != GoRabbitStateClosed {
+		t.Fatalf("event = %+v, want closed", evt)
+	}
+
+	if err := r.Listen(context.Background(), GoRabbitConsumerMessages{"orders": {"order.created": {}}}); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Listen after Shutdown error = %v, want ErrNotConnected", err)
+	}
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("second Shutdown = %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package gorabbit
+
+import (
+	"context"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// GoRabbitMeta is a struct that represents the metadata of a consumed message.
+// It is used to pass the AMQP properties to the typed handlers.
+// RoutingKey and Exchange are the original ones, also for a retried message. RequestId and UserId are read
+// from the headers set by the publisher.
+type GoRabbitMeta struct {
+	MessageId     string
+	RequestId     string
+	CorrelationId string
+	UserId        string
+	AppId         string
+	Exchange      string
+	RoutingKey    string
+	ContentType   string
+	Type          string
+	Priority      uint8
+	ReplyTo       string
+	Timestamp     time.Time
+	Headers       amqp091.Table
+	Redelivered   bool
+	RetryCount    int
+}
+
+// MetaFromDelivery is a function that builds the metadata of a delivery.
+// It takes an amqp091.Delivery and returns a GoRabbitMeta.
+// This is used by Handle and can be used by raw ConsumerFunc handlers as well.
+func MetaFromDelivery(msg amqp091.Delivery) GoRabbitMeta {
+	return GoRabbitMeta{
+		MessageId:     msg.MessageId,
+		RequestId:     deliveryRequestId(msg),
+		CorrelationId: msg.CorrelationId,
+		UserId:        deliveryUserId(msg),
+		AppId:         msg.AppId,
+		Exchange:      msg.Exchange,
+		RoutingKey:    msg.RoutingKey,
+		ContentType:   msg.ContentType,
+		Type:          msg.Type,
+		Priority:      msg.Priority,
+		ReplyTo:       msg.ReplyTo,
+		Timestamp:     msg.Timestamp,
+		Headers:       msg.Headers,
+		Redelivered:   msg.Redelivered,
+		RetryCount:    RetryCount(msg),
+	}
+}
+
+// Handle is a function that creates a consumer with a typed handler.
+// It takes a handler receiving the decoded message and its metadata and returns a GoRabbitConsumer.
+// The body, already decrypted, is decoded into T with the Codec of its content type. A body that cannot be decoded is not retried
+// but dead-lettered, with an error wrapping ErrDecode.
+//
+//	consumers := gorabbit.GoRabbitConsumerMessages{
+//		"orders": {
+//			"order.created": gorabbit.Handle(func(ctx context.Context, o Order, meta gorabbit.GoRabbitMeta) error {
+//				return svc.Create(ctx, o)
+//			}),
+//		},
+//	}
+func Handle[T any](fn func(ctx context.Context, msg T, meta GoRabbitMeta) error) GoRabbitConsumer {
+	return GoRabbitConsumer{
+		Consume: func(ctx context.Context, d amqp091.Delivery) error {
+			var msg T
+			if err := Decode(d, &msg); err != nil {
+				return NonRetryable(err)
+			}
+			return fn(ctx, msg, MetaFromDelivery(d))
+		},
+	}
+}
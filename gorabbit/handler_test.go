@@ -0,0 +1,126 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// testOrder is the message of the typed handler tests.
+type testOrder struct {
+	Id    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+func TestHandle(t *testing.T) {
+	var (
+		got  testOrder
+		meta GoRabbitMeta
+	)
+	c := Handle(func(_ context.Context, o testOrder, m GoRabbitMeta) error {
+		got, meta = o, m
+		return nil
+	})
+
+	ts := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	err := c.Consume(context.Background(), amqp091.Delivery{
+		MessageId:   "order-1",
+		RoutingKey:  "order.created",
+		ContentType: ContentTypeJSON,
+		Timestamp:   ts,
+		Headers: amqp091.Table{
+			HeaderRequestID:  "req-1",
+			HeaderUserId:     "user-1",
+			HeaderRetryCount: int32(2),
+		},
+		Body: []byte(`{"id":"a","total":1}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != (testOrder{Id: "a", Total: 1}) {
+		t.Errorf("decoded %+v", got)
+	}
+	if meta.MessageId != "order-1" || meta.RoutingKey != "order.created" || meta.RequestId != "req-1" ||
+		meta.UserId != "user-1" || meta.RetryCount != 2 || !meta.Timestamp.Equal(ts) {
+		t.Errorf("meta = %+v", meta)
+	}
+}
+
+func TestHandleDecodeError(t *testing.T) {
+	called := false
+	c := Handle(func(context.Context, testOrder, GoRabbitMeta) error {
+		called = true
+		return nil
+	})
+
+	err := c.Consume(context.Background(), amqp091.Delivery{ContentType: ContentTypeJSON, Body: []byte(`{"id":`)})
+	if !errors.Is(err, ErrDecode) || !errors.Is(err, ErrNonRetryable) {
+		t.Fatalf("Consume error = %v, want a non-retryable ErrDecode", err)
+	}
+	if called {
+		t.Fatal("the handler was called with a body that cannot be decoded")
+	}
+}
+
+func TestHandleRetriedMessage(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	var metas []GoRabbitMeta
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.*": Handle(func(_ context.Context, _ testOrder, meta GoRabbitMeta) error {
+				metas = append(metas, meta)
+				if meta.RetryCount == 0 {
+					return errors.New("temporary failure")
+				}
+				return nil
+			}),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{
+		Topic:   "order.created",
+		Message: testOrder{Id: "a", Total: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	if len(metas) != 2 {
+		t.Fatalf("handler called %d times, want 2", len(metas))
+	}
+	// The retried message is handled as the original one.
+	if retried := metas[1]; retried.RetryCount != 1 || retried.RoutingKey != "order.created" || retried.Exchange != defaultExchange {
+		t.Fatalf("meta of the retried message = %+v", retried)
+	}
+}
+
+func TestHandleDeadLettersUndecodable(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": Handle(func(context.Context, testOrder, GoRabbitMeta) error { return nil }),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "not an order"}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	if dead := m.DeadLettered(); len(dead) != 1 || dead[0].RetryCount != 0 || !errors.Is(dead[0].Err, ErrDecode) {
+		t.Fatalf("dead-lettered = %+v, want the message without retries", dead)
+	}
+}
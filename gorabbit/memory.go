@@ -0,0 +1,1112 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ GoRabbit = (*GoRabbitMemory)(nil)
+
+// GoRabbitMemoryMessage is a struct that represents a message seen by the in-memory broker.
+// It is used to assert the published, handled, and dead-lettered messages in tests.
+// Body is the plain body, decrypted when the message encryption is enabled, and Err is the handler
+// error of a dead-lettered message.
+type GoRabbitMemoryMessage struct {
+	Queue         string
+	Exchange      string
+	RoutingKey    string
+	MessageId     string
+	CorrelationId string
+	AppId         string
+	ContentType   string
+	Headers       amqp091.Table
+	Body          []byte
+	RetryCount    int
+	Err           error
+}
+
+// Decode is a function that decodes the body of the message.
+// It takes a pointer to the destination and returns an error.
+// The body is decoded with the Codec of the content type of the message.
+func (m GoRabbitMemoryMessage) Decode(dest any) error {
+	return Decode(amqp091.Delivery{ContentType: m.ContentType, Body: m.Body}, dest)
+}
+
+// memoryBinding is a struct that represents a binding of an in-memory queue.
+type memoryBinding struct {
+	exchange string
+	pattern  string
+}
+
+// memoryQueue is a struct that represents an in-memory queue.
+type memoryQueue struct {
+	name      string
+	bindings  []memoryBinding
+	ready     []amqp091.Delivery
+	consumers int
+}
+
+// memoryListener is a struct that represents the consumers started by a Listen call of the in-memory broker.
+type memoryListener struct {
+	hctx    context.Context
+	cancel  context.CancelFunc
+	queues  []string
+	stopped bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// memoryDelayed is a struct that represents a delayed message of the in-memory broker.
+type memoryDelayed struct {
+	exchange string
+	key      string
+	pub      amqp091.Publishing
+	timer    *time.Timer
+}
+
+// memoryAck is a struct that implements the amqp091.Acknowledger interface for the in-memory broker.
+// A message that is nacked or rejected with requeue goes back to its queue as redelivered.
+type memoryAck struct {
+	m       *GoRabbitMemory
+	queue   string
+	msg     amqp091.Delivery
+	settled bool
+}
+
+// GoRabbitMemory is a struct that represents an in-memory broker implementing GoRabbit.
+// It is used to run the handler tests without a broker. It routes like topic exchanges, with the * and #
+// wildcards, and through the default exchange by queue name, and runs the same middlewares, encryption,
+// retries, dead-lettering, and replies as GoRabbit. The retries are not delayed, and the ordering keys, the
+// priorities, and the expirations are ignored.
+// The delayed messages are routed once due, or right away with DeliverDelayed.
+//
+//	r := gorabbit.NewMemory(gorabbit.GoRabbitConfiguration{}, nil)
+//	_ = r.Listen(ctx, consumers)
+//	_ = r.Publisher().Publish(ctx, gorabbit.GoRabbitPublisherOption{Topic: "order.created", Message: order})
+//	_ = r.WaitIdle(ctx)
+//	acked := r.Acked()
+type GoRabbitMemory struct {
+	core *rbt
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	queues       map[string]*memoryQueue
+	listeners    []*memoryListener
+	calls        map[string]chan amqp091.Delivery
+	published    []GoRabbitMemoryMessage
+	delayed      []*memoryDelayed
+	acked        []GoRabbitMemoryMessage
+	deadLettered []GoRabbitMemoryMessage
+	inflight     int
+	tag          uint64
+	closed       bool
+	notifiers    []chan GoRabbitConnectionEvent
+}
+
+// NewMemory is a function that creates a new in-memory broker.
+// It takes a GoRabbitConfiguration and a pointer to a logrus.Logger and returns a pointer to a GoRabbitMemory.
+// Only the secret, the content type, the compression, the retry, the exchange, and the queue settings of the configuration are used.
+// The logs are discarded when the logger is nil. It panics when the secret or a compression is invalid.
+func NewMemory(opt GoRabbitConfiguration, log *logrus.Logger) *GoRabbitMemory {
+	if log == nil {
+		log = logrus.New()
+		log.SetOutput(io.Discard)
+	}
+
+	cr, err := initCrypto(opt)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := validateCompression(opt); err != nil {
+		panic(err)
+	}
+
+	m := &GoRabbitMemory{
+		core: &rbt{
+			log:                   log,
+			withMessageEncryption: len(opt.Secret) > 0,
+			cr:                    cr,
+			conf:                  opt,
+			done:                  make(chan struct{}),
+			state:                 GoRabbitStateConnected,
+		},
+		queues: make(map[string]*memoryQueue),
+		calls:  make(map[string]chan amqp091.Delivery),
+	}
+	m.cond = sync.NewCond(&m.mu)
+
+	return m
+}
+
+// Publisher is a function that returns the publisher.
+// It takes nothing and returns a Publisher.
+func (m *GoRabbitMemory) Publisher() Publisher {
+	return m
+}
+
+// Use is a function that registers global consumer middlewares.
+// It takes a list of ConsumerMiddleware and returns nothing.
+func (m *GoRabbitMemory) Use(mw ...ConsumerMiddleware) {
+	m.core.Use(mw...)
+}
+
+// RegisterSchema is a function that registers the schema of a topic.
+// It takes a topic and a GoRabbitSchema and returns an error.
+func (m *GoRabbitMemory) RegisterSchema(topic string, schema GoRabbitSchema) error {
+	return m.core.RegisterSchema(topic, schema)
+}
+
+// ValidateSchema is a function that validates a message with the schema of its topic.
+// It takes a GoRabbitPublisherOption and returns an error.
+func (m *GoRabbitMemory) ValidateSchema(opt GoRabbitPublisherOption) error {
+	return m.core.ValidateSchema(opt)
+}
+
+// State is a function that returns the state of the in-memory broker.
+// It takes nothing and returns a GoRabbitConnectionState.
+// The broker is connected until it is shut down.
+func (m *GoRabbitMemory) State() GoRabbitConnectionState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return GoRabbitStateClosed
+	}
+	return GoRabbitStateConnected
+}
+
+// NotifyState is a function that returns a channel of the state changes.
+// It takes nothing and returns a receive-only channel of GoRabbitConnectionEvent.
+// The only change of the in-memory broker is the shutdown.
+func (m *GoRabbitMemory) NotifyState() <-chan GoRabbitConnectionEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan GoRabbitConnectionEvent, 1)
+	if m.closed {
+		close(ch)
+		return ch
+	}
+	m.notifiers = append(m.notifiers, ch)
+	return ch
+}
+
+// Publish is a function that publishes the message to the in-memory broker.
+// It takes a context and a GoRabbitPublisherOption and returns an error.
+// As with GoRabbit, a *GoRabbitPublishError wrapping ErrPublishUnroutable is returned when no queue
+// is bound for the topic. The message is recorded as published in any case. A delayed message is held until due,
+// and dropped then when no queue is bound for the topic, as by the broker.
+func (m *GoRabbitMemory) Publish(ctx context.Context, opt GoRabbitPublisherOption) error {
+	if m.core.trimSpace(opt.Topic) == "" {
+		return ErrTopicRequired
+	}
+
+	if opt.Message == nil {
+		return ErrMessageRequired
+	}
+
+	uid := opt.MessageId
+	if uid == "" {
+		uid = uuid.New().String()
+	}
+
+	exchange := opt.Exchange
+	if exchange == "" {
+		exchange = m.core.exchangeName()
+	}
+
+	pub, err := m.core.publishing(ctx, opt, uid)
+	if err != nil {
+		return &GoRabbitPublishError{MessageId: uid, Topic: opt.Topic, Err: err}
+	}
+
+	if delay := delayOf(opt, time.Now()); delay > 0 {
+		pub.Expiration = ""
+		return m.schedule(exchange, opt.Topic, pub, delay)
+	}
+
+	if err := m.route(exchange, opt.Topic, pub, true); err != nil {
+		return &GoRabbitPublishError{MessageId: uid, Topic: opt.Topic, Attempts: 1, Err: err}
+	}
+
+	return nil
+}
+
+// PublishBatch is a function that publishes a batch of messages to the in-memory broker.
+// It takes a context and a list of GoRabbitPublisherOption and returns a list of GoRabbitPublishResult and an error.
+// The messages are published one after the other as by Publish, and the results and the error are the ones
+// of GoRabbit.
+func (m *GoRabbitMemory) PublishBatch(
+	ctx context.Context,
+	opts []GoRabbitPublisherOption,
+) ([]GoRabbitPublishResult, error) {
+	results := make([]GoRabbitPublishResult, len(opts))
+	failed := 0
+
+	for i, opt := range opts {
+		if opt.MessageId == "" {
+			opt.MessageId = uuid.New().String()
+		}
+
+		results[i] = GoRabbitPublishResult{MessageId: opt.MessageId, Topic: opt.Topic}
+
+		err := m.Publish(ctx, opt)
+		if err == nil {
+			continue
+		}
+
+		var perr *GoRabbitPublishError
+		if !errors.As(err, &perr) {
+			err = &GoRabbitPublishError{MessageId: opt.MessageId, Topic: opt.Topic, Err: err}
+		}
+		results[i].Err = err
+		failed++
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("%w: %d/%d", ErrPublishBatchFailed, failed, len(opts))
+	}
+	return results, nil
+}
+
+// Call is a function that sends a request to the in-memory broker and waits for its reply.
+// It takes a context, a topic, a request, and a pointer to the response and returns an error.
+// It behaves like the Call of GoRabbit.
+func (m *GoRabbitMemory) Call(ctx context.Context, topic string, req any, resp any) error {
+	if m.core.trimSpace(topic) == "" {
+		return ErrTopicRequired
+	}
+
+	if req == nil {
+		return ErrMessageRequired
+	}
+
+	ctx, cancel := m.core.callContext(ctx)
+	defer cancel()
+
+	uid := uuid.New().String()
+
+	codec, err := m.core.codec("", topic)
+	if err != nil {
+		return err
+	}
+
+	headers, err := m.core.versioned(topic, codec, req, contextHeaders(ctx, "", nil, nil))
+	if err != nil {
+		return err
+	}
+
+	body, encoding, err := m.core.encode(codec, topic, req)
+	if err != nil {
+		return err
+	}
+
+	res := make(chan amqp091.Delivery, 1)
+
+	m.mu.Lock()
+	m.calls[uid] = res
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.calls, uid)
+		m.mu.Unlock()
+	}()
+
+	if err := m.route(m.core.exchangeName(), topic, amqp091.Publishing{
+		Headers:         headers,
+		ContentType:     codec.ContentType(),
+		ContentEncoding: encoding,
+		MessageId:       uid,
+		AppId:           contextAppId(ctx, ""),
+		CorrelationId:   uid,
+		ReplyTo:         directReplyTo,
+		Body:            body,
+	}, true); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case msg := <-res:
+		return m.core.decodeReply(topic, msg, resp)
+	}
+}
+
+// reply is a function that hands the reply of a request over to the waiting call.
+// It takes a context, the request, the response, and the handler error and returns an error.
+// A reply to a call that already gave up is dropped.
+func (m *GoRabbitMemory) reply(_ context.Context, msg amqp091.Delivery, resp any, err error) error {
+	codec := replyCodec(msg)
+	out := amqp091.Delivery{
+		ContentType:   codec.ContentType(),
+		MessageId:     uuid.New().String(),
+		CorrelationId: msg.CorrelationId,
+	}
+
+	if err != nil {
+		out.Headers = amqp091.Table{HeaderReplyError: err.Error()}
+	} else {
+		body, encoding, err := m.core.encode(codec, "", resp)
+		if err != nil {
+			return NonRetryable(fmt.Errorf("error encoding reply: %w", err))
+		}
+		out.Body = body
+		out.ContentEncoding = encoding
+	}
+
+	m.mu.Lock()
+	res, ok := m.calls[msg.CorrelationId]
+	m.mu.Unlock()
+
+	if ok {
+		select {
+		case res <- out:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Bind is a function that declares a queue and binds it to topics without consuming it.
+// It takes a queue name and a list of topics and returns nothing.
+// This is used to make the topics routable when only the publishing is tested, see Queued.
+func (m *GoRabbitMemory) Bind(queue string, topics ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bind(queue, topics...)
+}
+
+// Listen is a function that starts consuming the queues of the consumers.
+// It takes a context and a GoRabbitConsumerMessages and returns an error.
+// The consumers are stopped when the context is done, leaving the messages not handled yet in their queues.
+func (m *GoRabbitMemory) Listen(ctx context.Context, consumers GoRabbitConsumerMessages) error {
+	for queue := range consumers {
+		if err := m.core.queueConfig(queue).validate(queue); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrNotConnected
+	}
+
+	hctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	l := &memoryListener{
+		hctx:   hctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	for queue, topics := range consumers {
+		names := make([]string, 0, len(topics))
+		for topic := range topics {
+			if topic != FallbackTopic {
+				names = append(names, topic)
+			}
+		}
+
+		m.core.queues.register(queue)
+
+		q := m.bind(queue, names...)
+		q.consumers++
+		l.queues = append(l.queues, queue)
+
+		handlers := m.core.handlers(queue, topics)
+		for range concurrency(m.core.queueConfig(queue)) {
+			l.wg.Add(1)
+			go m.work(l, q, handlers)
+		}
+	}
+
+	m.listeners = append(m.listeners, l)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.stop(l)
+			l.wg.Wait()
+			l.cancel()
+		case <-l.done:
+		}
+	}()
+
+	return nil
+}
+
+// Pause is a function that pauses the consumers of a queue.
+// It takes a queue name and returns an error.
+// The messages stay in the queue until it is resumed, and the errors are the ones of GoRabbit.
+func (m *GoRabbitMemory) Pause(queue string) error {
+	return m.transition(queue, GoRabbitQueuePaused)
+}
+
+// Resume is a function that resumes the consumers of a paused queue.
+// It takes a queue name and returns an error.
+func (m *GoRabbitMemory) Resume(queue string) error {
+	return m.transition(queue, GoRabbitQueueRunning)
+}
+
+// Cancel is a function that cancels the consumers of a queue for good.
+// It takes a queue name and returns an error.
+// The messages stay in the queue until it is consumed by a new Listen call.
+func (m *GoRabbitMemory) Cancel(queue string) error {
+	return m.transition(queue, GoRabbitQueueCancelled)
+}
+
+// Stats is a function that returns the counters of the consumed queues.
+// It takes nothing and returns a list of GoRabbitQueueStats sorted by queue name.
+func (m *GoRabbitMemory) Stats() []GoRabbitQueueStats {
+	return m.core.queues.snapshot()
+}
+
+// transition is a function that changes the state of a queue and wakes up its workers.
+// It takes a queue name and a GoRabbitQueueState and returns an error.
+func (m *GoRabbitMemory) transition(queue string, state GoRabbitQueueState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.core.queues.transition(queue, state); err != nil {
+		return err
+	}
+	m.cond.Broadcast()
+	return nil
+}
+
+// Shutdown is a function that stops the consumers and closes the in-memory broker.
+// It takes a context and returns an error.
+// The running handlers are awaited until the context is done.
+func (m *GoRabbitMemory) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	listeners := m.listeners
+	m.listeners = nil
+	m.stopDelayed()
+	m.mu.Unlock()
+
+	for _, l := range listeners {
+		m.stop(l)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		for _, l := range listeners {
+			l.wg.Wait()
+		}
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	for _, l := range listeners {
+		l.cancel()
+	}
+
+	m.mu.Lock()
+	for _, ch := range m.notifiers {
+		ch <- GoRabbitConnectionEvent{State: GoRabbitStateClosed}
+		close(ch)
+	}
+	m.notifiers = nil
+	m.cond.Broadcast()
+	m.mu.Unlock()
+
+	return err
+}
+
+// Close is a function that closes the in-memory broker.
+// It takes nothing and returns nothing.
+func (m *GoRabbitMemory) Close() {
+	_ = m.Shutdown(context.Background())
+}
+
+// Published is a function that returns the published messages.
+// It takes nothing and returns a list of GoRabbitMemoryMessage.
+// The calls and the retries are not included, as they are not published by Publish.
+func (m *GoRabbitMemory) Published() []GoRabbitMemoryMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]GoRabbitMemoryMessage(nil), m.published...)
+}
+
+// PublishedTo is a function that returns the messages published with a topic.
+// It takes a topic and returns a list of GoRabbitMemoryMessage.
+func (m *GoRabbitMemory) PublishedTo(topic string) []GoRabbitMemoryMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var msgs []GoRabbitMemoryMessage
+	for _, msg := range m.published {
+		if msg.RoutingKey == topic {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Acked is a function that returns the messages handled successfully.
+// It takes nothing and returns a list of GoRabbitMemoryMessage.
+func (m *GoRabbitMemory) Acked() []GoRabbitMemoryMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]GoRabbitMemoryMessage(nil), m.acked...)
+}
+
+// DeadLettered is a function that returns the dead-lettered messages.
+// It takes nothing and returns a list of GoRabbitMemoryMessage.
+// Err is the error the message was dead-lettered with.
+func (m *GoRabbitMemory) DeadLettered() []GoRabbitMemoryMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]GoRabbitMemoryMessage(nil), m.deadLettered...)
+}
+
+// Queued is a function that returns the messages waiting in a queue.
+// It takes a queue name and returns a list of GoRabbitMemoryMessage.
+func (m *GoRabbitMemory) Queued(queue string) []GoRabbitMemoryMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[queue]
+	if !ok {
+		return nil
+	}
+
+	msgs := make([]GoRabbitMemoryMessage, 0, len(q.ready))
+	for _, d := range q.ready {
+		msgs = append(msgs, m.message(queue, d, m.plain(d.ContentEncoding, d.Body), nil))
+	}
+	return msgs
+}
+
+// Delayed is a function that returns the delayed messages that are not due yet.
+// It takes nothing and returns a list of GoRabbitMemoryMessage.
+func (m *GoRabbitMemory) Delayed() []GoRabbitMemoryMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msgs := make([]GoRabbitMemoryMessage, 0, len(m.delayed))
+	for _, d := range m.delayed {
+		msgs = append(msgs, m.message("", m.delivery(nil, d.exchange, d.key, d.pub), m.plain(d.pub.ContentEncoding, d.pub.Body), nil))
+	}
+	return msgs
+}
+
+// DeliverDelayed is a function that routes the delayed messages right away.
+// It takes nothing and returns nothing.
+// This is used to test the handling of a delayed message without waiting for it.
+func (m *GoRabbitMemory) DeliverDelayed() {
+	m.mu.Lock()
+	delayed := m.delayed
+	m.delayed = nil
+	for _, d := range delayed {
+		d.timer.Stop()
+	}
+	m.mu.Unlock()
+
+	for _, d := range delayed {
+		_ = m.route(d.exchange, d.key, d.pub, false)
+	}
+}
+
+// Reset is a function that forgets the recorded messages and empties the queues.
+// It takes nothing and returns nothing. The delayed messages are dropped.
+// The queues, the bindings, and the consumers are kept.
+func (m *GoRabbitMemory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, q := range m.queues {
+		q.ready = nil
+	}
+	m.stopDelayed()
+	m.published = nil
+	m.acked = nil
+	m.deadLettered = nil
+	m.cond.Broadcast()
+}
+
+// WaitIdle is a function that waits until the consumed queues are empty and no handler is running.
+// The paused and cancelled queues are not waited for.
+// It takes a context and returns an error.
+// It returns the context error when the context is done first.
+func (m *GoRabbitMemory) WaitIdle(ctx context.Context) error {
+	return m.wait(ctx, func() bool {
+		if m.inflight > 0 {
+			return false
+		}
+		for _, q := range m.queues {
+			if q.consumers > 0 && len(q.ready) > 0 && m.core.queues.running(q.name) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// WaitHandled is a function that waits until a number of messages are acked or dead-lettered.
+// It takes a context and a number and returns an error.
+// It returns the context error when the context is done first.
+func (m *GoRabbitMemory) WaitHandled(ctx context.Context, n int) error {
+	return m.wait(ctx, func() bool {
+		return len(m.acked)+len(m.deadLettered) >= n
+	})
+}
+
+// wait is a function that waits until a condition holds.
+// It takes a context and a condition checked with the lock held and returns an error.
+func (m *GoRabbitMemory) wait(ctx context.Context, cond func() bool) error {
+	stop := context.AfterFunc(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.cond.Broadcast()
+	})
+	defer stop()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for !cond() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m.cond.Wait()
+	}
+	return nil
+}
+
+// bind is a function that declares a queue and binds it to topics.
+// It takes a queue name and a list of topics and returns a pointer to a memoryQueue.
+// The lock must be held.
+func (m *GoRabbitMemory) bind(queue string, topics ...string) *memoryQueue {
+	q, ok := m.queues[queue]
+	if !ok {
+		q = &memoryQueue{name: queue}
+		m.queues[queue] = q
+	}
+
+	exchange := m.core.queueConfig(queue).Exchange
+	if exchange == "" {
+		exchange = m.core.exchangeName()
+	}
+
+	for _, topic := range topics {
+		b := memoryBinding{exchange: exchange, pattern: topic}
+		found := false
+		for _, x := range q.bindings {
+			if x == b {
+				found = true
+				break
+			}
+		}
+		if !found {
+			q.bindings = append(q.bindings, b)
+		}
+	}
+
+	return q
+}
+
+// route is a function that routes a message to the queues bound for it.
+// It takes an exchange, a routing key, an amqp091.Publishing, and whether to record the message as published
+// and returns an error.
+// The default exchange routes to the queue named by the routing key.
+func (m *GoRabbitMemory) route(exchange string, key string, pub amqp091.Publishing, record bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrNotConnected
+	}
+
+	if record {
+		m.record(exchange, key, pub)
+	}
+
+	var queues []*memoryQueue
+	if exchange == "" {
+		if q, ok := m.queues[key]; ok {
+			queues = append(queues, q)
+		}
+	} else {
+		for _, q := range m.queues {
+			for _, b := range q.bindings {
+				if b.exchange == exchange && topicMatch(b.pattern, key) {
+					queues = append(queues, q)
+					break
+				}
+			}
+		}
+	}
+
+	if len(queues) == 0 {
+		return fmt.Errorf("%w: %d %s", ErrPublishUnroutable, amqp091.NoRoute, "NO_ROUTE")
+	}
+
+	for _, q := range queues {
+		q.ready = append(q.ready, m.delivery(q, exchange, key, pub))
+	}
+	m.cond.Broadcast()
+
+	return nil
+}
+
+// record is a function that records a message as published.
+// It takes an exchange, a routing key, and an amqp091.Publishing and returns nothing.
+// The lock must be held.
+func (m *GoRabbitMemory) record(exchange string, key string, pub amqp091.Publishing) {
+	d := m.delivery(nil, exchange, key, pub)
+	m.published = append(m.published, m.message("", d, m.plain(d.ContentEncoding, d.Body), nil))
+}
+
+// schedule is a function that holds a delayed message until it is due.
+// It takes an exchange, a routing key, an amqp091.Publishing, and the delay and returns an error.
+// The message is recorded as published right away.
+func (m *GoRabbitMemory) schedule(exchange string, key string, pub amqp091.Publishing, delay time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrNotConnected
+	}
+
+	m.record(exchange, key, pub)
+
+	d := &memoryDelayed{exchange: exchange, key: key, pub: pub}
+	d.timer = time.AfterFunc(delay, func() {
+		if m.takeDelayed(d) {
+			_ = m.route(d.exchange, d.key, d.pub, false)
+		}
+	})
+	m.delayed = append(m.delayed, d)
+
+	return nil
+}
+
+// takeDelayed is a function that removes a delayed message from the waiting ones.
+// It takes a pointer to a memoryDelayed and returns whether it was still waiting.
+func (m *GoRabbitMemory) takeDelayed(d *memoryDelayed) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, x := range m.delayed {
+		if x == d {
+			m.delayed = append(m.delayed[:i], m.delayed[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// stopDelayed is a function that drops the delayed messages.
+// It takes nothing and returns nothing.
+// The lock must be held.
+func (m *GoRabbitMemory) stopDelayed() {
+	for _, d := range m.delayed {
+		d.timer.Stop()
+	}
+	m.delayed = nil
+}
+
+// delivery is a function that builds the delivery of a message to a queue.
+// It takes a pointer to a memoryQueue, an exchange, a routing key, and an amqp091.Publishing and returns an amqp091.Delivery.
+// The lock must be held.
+func (m *GoRabbitMemory) delivery(q *memoryQueue, exchange string, key string, pub amqp091.Publishing) amqp091.Delivery {
+	headers := amqp091.Table{}
+	for k, v := range pub.Headers {
+		headers[k] = v
+	}
+
+	m.tag++
+	d := amqp091.Delivery{
+		Headers:         headers,
+		ContentType:     pub.ContentType,
+		ContentEncoding: pub.ContentEncoding,
+		DeliveryMode:    pub.DeliveryMode,
+		Priority:        pub.Priority,
+		CorrelationId:   pub.CorrelationId,
+		ReplyTo:         pub.ReplyTo,
+		Expiration:      pub.Expiration,
+		MessageId:       pub.MessageId,
+		Timestamp:       pub.Timestamp,
+		Type:            pub.Type,
+		UserId:          pub.UserId,
+		AppId:           pub.AppId,
+		DeliveryTag:     m.tag,
+		Exchange:        exchange,
+		RoutingKey:      key,
+		Body:            pub.Body,
+	}
+
+	if q != nil {
+		ack := &memoryAck{m: m, queue: q.name}
+		d.Acknowledger = ack
+		ack.msg = d
+	}
+
+	return d
+}
+
+// stop is a function that stops the consumers of a listener.
+// It takes a pointer to a memoryListener and returns nothing.
+func (m *GoRabbitMemory) stop(l *memoryListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	close(l.done)
+
+	for _, queue := range l.queues {
+		if q, ok := m.queues[queue]; ok {
+			q.consumers--
+		}
+	}
+	m.cond.Broadcast()
+}
+
+// work is a function that runs a worker of a queue.
+// It takes a pointer to a memoryListener, a pointer to a memoryQueue, and the handlers of its topics and returns nothing.
+// It waits while the queue is paused, and returns once the listener is stopped or the queue is cancelled.
+func (m *GoRabbitMemory) work(l *memoryListener, q *memoryQueue, handlers *dispatcher) {
+	defer l.wg.Done()
+
+	for {
+		m.mu.Lock()
+		for !l.stopped && (len(q.ready) == 0 || m.core.queues.state(q.name) == GoRabbitQueuePaused) {
+			m.cond.Wait()
+		}
+		if l.stopped || m.core.queues.state(q.name) == GoRabbitQueueCancelled {
+			m.mu.Unlock()
+			return
+		}
+
+		msg := q.ready[0]
+		q.ready = q.ready[1:]
+		m.inflight++
+		m.mu.Unlock()
+
+		m.handle(l.hctx, q.name, handlers, msg)
+
+		m.mu.Lock()
+		m.inflight--
+		m.cond.Broadcast()
+		m.mu.Unlock()
+	}
+}
+
+// handle is a function that handles a message like the consumers of GoRabbit.
+// It takes the handler context, a queue name, the handlers of its topics, and an amqp091.Delivery and returns nothing.
+func (m *GoRabbitMemory) handle(
+	ctx context.Context,
+	queue string,
+	handlers *dispatcher,
+	msg amqp091.Delivery,
+) {
+	done := m.core.queues.stats(queue).begin(msg)
+	succeeded := false
+	defer func() { done(succeeded) }()
+
+	msg = originalDelivery(msg)
+	body := msg.Body
+
+	handle, ok := handlers.match(msg.RoutingKey)
+	if !ok {
+		m.deadLetter(queue, msg, body, errors.New("consumer not found"))
+		return
+	}
+
+	plain, err := m.core.open(msg)
+	if err != nil {
+		m.deadLetter(queue, msg, body, err)
+		return
+	}
+
+	plain, err = m.core.conform(plain)
+	switch {
+	case errors.Is(err, ErrNonRetryable):
+		m.deadLetter(queue, msg, body, err)
+		return
+	case err != nil:
+		m.retry(queue, msg, body, err)
+		return
+	}
+
+	ctx = deliveryContext(ctx, msg)
+	ctx = context.WithValue(ctx, replierKey{}, replier(m))
+	ctx = context.WithValue(ctx, queueKey{}, queue)
+
+	err = func() (err error) {
+		defer func() {
+			if rc := recover(); rc != nil {
+				err = fmt.Errorf("consumer panic: %v", rc)
+			}
+		}()
+		return handle(ctx, plain)
+	}()
+
+	switch {
+	case err == nil:
+		succeeded = true
+		m.mu.Lock()
+		m.acked = append(m.acked, m.message(queue, msg, plain.Body, nil))
+		m.mu.Unlock()
+		_ = msg.Ack(false)
+	case errors.Is(err, ErrNonRetryable):
+		m.deadLetter(queue, msg, body, err)
+	default:
+		m.retry(queue, msg, body, err)
+	}
+}
+
+// retry is a function that sends a failed message back to its queue, or to the dead-letter messages
+// once the attempts are used up.
+// It takes a queue name, the delivery, the original body, and the handler error and returns nothing.
+// The message comes back through the default exchange, as from a retry queue of GoRabbit, but without the delay.
+func (m *GoRabbitMemory) retry(queue string, msg amqp091.Delivery, body []byte, cause error) {
+	count := RetryCount(msg) + 1
+	if count >= m.core.retryConfig(queue).MaxAttempts {
+		m.deadLetter(queue, msg, body, cause)
+		return
+	}
+
+	if err := m.route("", queue, republishing(msg, body, amqp091.Table{
+		HeaderRetryCount: int32(count),
+		HeaderError:      cause.Error(),
+	}), false); err != nil {
+		_ = msg.Nack(false, true)
+		return
+	}
+
+	_ = msg.Ack(false)
+}
+
+// deadLetter is a function that records a message as dead-lettered.
+// It takes a queue name, the delivery, the original body, and the error and returns nothing.
+func (m *GoRabbitMemory) deadLetter(queue string, msg amqp091.Delivery, body []byte, cause error) {
+	m.mu.Lock()
+	m.deadLettered = append(m.deadLettered, m.message(queue, msg, m.plain(msg.ContentEncoding, body), cause))
+	m.mu.Unlock()
+
+	_ = msg.Ack(false)
+}
+
+// plain is a function that returns the plain body of a message.
+// It takes the content encoding and a []byte and returns a []byte.
+// The body is returned as is when it cannot be decrypted or decompressed.
+func (m *GoRabbitMemory) plain(encoding string, body []byte) []byte {
+	plain, err := m.core.open(amqp091.Delivery{ContentEncoding: encoding, Body: body})
+	if err != nil {
+		return body
+	}
+	return plain.Body
+}
+
+// message is a function that builds the record of a message.
+// It takes a queue name, the delivery, the plain body, and an error and returns a GoRabbitMemoryMessage.
+func (m *GoRabbitMemory) message(queue string, d amqp091.Delivery, body []byte, err error) GoRabbitMemoryMessage {
+	return GoRabbitMemoryMessage{
+		Queue:         queue,
+		Exchange:      d.Exchange,
+		RoutingKey:    d.RoutingKey,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		AppId:         d.AppId,
+		ContentType:   d.ContentType,
+		Headers:       d.Headers,
+		Body:          body,
+		RetryCount:    RetryCount(d),
+		Err:           err,
+	}
+}
+
+// settle is a function that settles a delivery once.
+// It takes nothing and returns an error.
+func (a *memoryAck) settle() error {
+	a.m.mu.Lock()
+	defer a.m.mu.Unlock()
+
+	if a.settled {
+		return errors.New("delivery already acknowledged")
+	}
+	a.settled = true
+	return nil
+}
+
+// Ack is a function that acknowledges the delivery.
+// It takes a delivery tag and a bool and returns an error.
+func (a *memoryAck) Ack(_ uint64, _ bool) error {
+	return a.settle()
+}
+
+// Nack is a function that negatively acknowledges the delivery.
+// It takes a delivery tag and two bools and returns an error.
+// The delivery goes back to its queue when requeue is true, and is dropped otherwise.
+func (a *memoryAck) Nack(_ uint64, _ bool, requeue bool) error {
+	if err := a.settle(); err != nil {
+		return err
+	}
+
+	if requeue {
+		a.requeue()
+	}
+	return nil
+}
+
+// Reject is a function that rejects the delivery.
+// It takes a delivery tag and a bool and returns an error.
+func (a *memoryAck) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// requeue is a function that puts the delivery back in its queue as redelivered.
+// It takes nothing and returns nothing.
+func (a *memoryAck) requeue() {
+	a.m.mu.Lock()
+	defer a.m.mu.Unlock()
+
+	q, ok := a.m.queues[a.queue]
+	if !ok {
+		return
+	}
+
+	a.m.tag++
+	d := a.msg
+	d.Redelivered = true
+	d.DeliveryTag = a.m.tag
+	ack := &memoryAck{m: a.m, queue: a.queue}
+	d.Acknowledger = ack
+	ack.msg = d
+
+	q.ready = append([]amqp091.Delivery{d}, q.ready...)
+	a.m.cond.Broadcast()
+}
@@ -0,0 +1,270 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestMemoryPublishHandle(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	got := make(chan testOrder, 1)
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": Handle(func(_ context.Context, o testOrder, meta GoRabbitMeta) error {
+				got <- o
+				return nil
+			}),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{
+		MessageId: "order-1",
+		Topic:     "order.created",
+		Message:   testOrder{Id: "a", Total: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	if o := <-got; o != (testOrder{Id: "a", Total: 1}) {
+		t.Errorf("handled %+v", o)
+	}
+
+	published := m.PublishedTo("order.created")
+	if len(published) != 1 || published[0].MessageId != "order-1" {
+		t.Fatalf("published = %+v", published)
+	}
+	var o testOrder
+	if err := published[0].Decode(&o); err != nil || o.Id != "a" {
+		t.Errorf("Decode = %+v, %v", o, err)
+	}
+
+	if acked := m.Acked(); len(acked) != 1 || acked[0].Queue != "orders" {
+		t.Fatalf("acked = %+v", acked)
+	}
+}
+
+func TestMemoryUnroutable(t *testing.T) {
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	err := m.Publish(context.Background(), GoRabbitPublisherOption{Topic: "order.created", Message: "hello"})
+
+	var perr *GoRabbitPublishError
+	if !errors.As(err, &perr) || !errors.Is(err, ErrPublishUnroutable) {
+		t.Fatalf("Publish error = %v, want a *GoRabbitPublishError wrapping ErrPublishUnroutable", err)
+	}
+	// The message is recorded as published anyway.
+	if n := len(m.Published()); n != 1 {
+		t.Fatalf("published %d messages, want 1", n)
+	}
+}
+
+func TestMemoryWildcardRouting(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	consumer := func(name string) GoRabbitConsumer {
+		return GoRabbitConsumer{Consume: func(_ context.Context, msg amqp091.Delivery) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, name+" "+msg.RoutingKey)
+			return nil
+		}}
+	}
+
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.*": consumer("orders"),
+		},
+		"audit": {
+			"#": consumer("audit"),
+		},
+		"eu": {
+			"*.created.eu": consumer("eu"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, topic := range []string{"order.created", "order.created.eu", "user"} {
+		if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: topic, Message: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitIdle(t, m)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	slices.Sort(got)
+	want := []string{
+		"audit order.created",
+		"audit order.created.eu",
+		"audit user",
+		"eu order.created.eu",
+		"orders order.created",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("handled %v, want %v", got, want)
+	}
+}
+
+func TestMemoryRetryDeadLetter(t *testing.T) {
+	tests := []struct {
+		name        string
+		conf        GoRabbitConfiguration
+		fail        int
+		wantCalls   int
+		wantAcked   int
+		wantDead    int
+		wantRetries int
+	}{
+		{name: "succeeds", fail: 0, wantCalls: 1, wantAcked: 1},
+		{name: "retried", fail: 2, wantCalls: 3, wantAcked: 1, wantRetries: 2},
+		{name: "dead-lettered", fail: 3, wantCalls: 3, wantDead: 1, wantRetries: 2},
+		{
+			name:        "queue attempts",
+			conf:        GoRabbitConfiguration{Queues: map[string]GoRabbitQueue{"orders": {Retry: &GoRabbitRetryConfiguration{MaxAttempts: 5}}}},
+			fail:        10,
+			wantCalls:   5,
+			wantDead:    1,
+			wantRetries: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := NewMemory(tt.conf, nil)
+
+			var (
+				mu    sync.Mutex
+				calls int
+			)
+			if err := m.Listen(ctx, GoRabbitConsumerMessages{
+				"orders": {
+					"order.created": {Consume: func(context.Context, amqp091.Delivery) error {
+						mu.Lock()
+						defer mu.Unlock()
+						calls++
+						if calls <= tt.fail {
+							return errors.New("temporary failure")
+						}
+						return nil
+					}},
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "hello"}); err != nil {
+				t.Fatal(err)
+			}
+			waitIdle(t, m)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if calls != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+
+			acked, dead := m.Acked(), m.DeadLettered()
+			if len(acked) != tt.wantAcked || len(dead) != tt.wantDead {
+				t.Fatalf("acked %d, dead-lettered %d, want %d and %d", len(acked), len(dead), tt.wantAcked, tt.wantDead)
+			}
+
+			var last GoRabbitMemoryMessage
+			if len(acked) > 0 {
+				last = acked[0]
+			} else {
+				last = dead[0]
+				if last.Err == nil || string(last.Body) != `"hello"` {
+					t.Errorf("dead-lettered = %+v", last)
+				}
+			}
+			if last.RetryCount != tt.wantRetries {
+				t.Errorf("retry count = %d, want %d", last.RetryCount, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestMemoryNonRetryable(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	var calls int
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(context.Context, amqp091.Delivery) error {
+				calls++
+				return NonRetryable(errors.New("invalid order"))
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+	if dead := m.DeadLettered(); len(dead) != 1 || dead[0].RetryCount != 0 {
+		t.Fatalf("dead-lettered = %+v, want the message without retries", dead)
+	}
+}
+
+func TestMemoryCall(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	errNotFound := errors.New("order not found")
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders-rpc": {
+			"order.get": Reply(func(_ context.Context, id string, _ GoRabbitMeta) (testOrder, error) {
+				if id != "a" {
+					return testOrder{}, errNotFound
+				}
+				return testOrder{Id: id, Total: 1}, nil
+			}),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var o testOrder
+	if err := m.Call(ctx, "order.get", "a", &o); err != nil {
+		t.Fatal(err)
+	}
+	if o != (testOrder{Id: "a", Total: 1}) {
+		t.Errorf("reply = %+v", o)
+	}
+
+	err := m.Call(ctx, "order.get", "b", &o)
+	var rerr *GoRabbitRemoteError
+	if !errors.As(err, &rerr) || rerr.Message != errNotFound.Error() {
+		t.Fatalf("Call error = %v, want the handler error", err)
+	}
+
+	if err := m.Call(ctx, "order.get", nil, &o); !errors.Is(err, ErrMessageRequired) {
+		t.Fatalf("Call error = %v, want ErrMessageRequired", err)
+	}
+}
@@ -0,0 +1,294 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/the-lanky/go-utils/gocontext"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ConsumerMiddleware is a type that represents a consumer middleware.
+// It takes the next ConsumerFunc and returns a ConsumerFunc wrapping it.
+// The middlewares registered with Use run first, then the ones of the queue, then the ones of the topic.
+type ConsumerMiddleware func(next ConsumerFunc) ConsumerFunc
+
+// queueKey is the context key of the queue of the consumed message.
+type queueKey struct{}
+
+// Queue is a function that returns the queue of the consumed message.
+// It takes a context and returns a string.
+// This is used by the middlewares, which only get the context and the delivery.
+func Queue(ctx context.Context) string {
+	q, _ := ctx.Value(queueKey{}).(string)
+	return q
+}
+
+// Use is a function that registers global consumer middlewares.
+// It takes a list of ConsumerMiddleware and returns nothing.
+// The middlewares apply to the queues consumed by the Listen calls made afterwards.
+func (r *rbt) Use(mw ...ConsumerMiddleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middlewares = append(r.middlewares, mw...)
+}
+
+// handlers is a function that builds the handler of every topic of a queue.
+// It takes a queue name and the consumers of its topics and returns a pointer to a dispatcher.
+// The handler of a topic is its ConsumerFunc wrapped in the global, queue, and topic middlewares.
+func (r *rbt) handlers(queue string, topics map[string]GoRabbitConsumer) *dispatcher {
+	r.mu.RLock()
+	global := append([]ConsumerMiddleware(nil), r.middlewares...)
+	r.mu.RUnlock()
+
+	queueMw := r.queueConfig(queue).Middlewares
+
+	handlers := make(map[string]ConsumerFunc, len(topics))
+	for topic, consumer := range topics {
+		if consumer.Consume == nil {
+			continue
+		}
+
+		fn := chain(consumer.Consume, consumer.Middlewares)
+		fn = chain(fn, queueMw)
+		handlers[topic] = chain(fn, global)
+	}
+
+	return newDispatcher(handlers)
+}
+
+// chain is a function that wraps a ConsumerFunc in middlewares.
+// It takes a ConsumerFunc and a list of ConsumerMiddleware and returns a ConsumerFunc.
+// The first middleware is the outermost one.
+func chain(fn ConsumerFunc, mw []ConsumerMiddleware) ConsumerFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		if mw[i] != nil {
+			fn = mw[i](fn)
+		}
+	}
+	return fn
+}
+
+// Recovery is a function that creates a middleware recovering the panics of the handler.
+// It takes a pointer to a logrus.Logger and returns a ConsumerMiddleware.
+// The panic is logged with its stack trace and returned as an error wrapping ErrConsumerPanic, so the message is retried.
+func Recovery(log *logrus.Logger) ConsumerMiddleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) (err error) {
+			defer func() {
+				if rc := recover(); rc != nil {
+					if log != nil {
+						log.WithFields(gocontext.Fields(ctx)).Errorf(
+							"[GoRabbit] [%s] [%s] Consumer panic: %v\n%s",
+							msg.MessageId,
+							msg.RoutingKey,
+							rc,
+							debug.Stack(),
+						)
+					}
+					err = fmt.Errorf("%w: %v", ErrConsumerPanic, rc)
+				}
+			}()
+
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Timeout is a function that creates a middleware limiting the run time of the handler.
+// It takes a time.Duration and returns a ConsumerMiddleware.
+// The context of the handler is cancelled after the duration, so the handler must honour it.
+func Timeout(d time.Duration) ConsumerMiddleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Logging is a function that creates a middleware logging every handled message.
+// It takes a pointer to a logrus.Logger and returns a ConsumerMiddleware.
+// The entry carries the queue, the topic, the message id, the retry count, the duration, the error,
+// and the request id and trace id of the context.
+func Logging(log *logrus.Logger) ConsumerMiddleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) error {
+			start := time.Now()
+			err := next(ctx, msg)
+
+			entry := log.WithFields(gocontext.Fields(ctx)).WithFields(logrus.Fields{
+				"queue":      Queue(ctx),
+				"topic":      msg.RoutingKey,
+				"messageId":  msg.MessageId,
+				"retryCount": RetryCount(msg),
+				"duration":   time.Since(start).String(),
+			})
+			if err != nil {
+				entry.WithError(err).Error("[GoRabbit] Message failed")
+			} else {
+				entry.Info("[GoRabbit] Message handled")
+			}
+
+			return err
+		}
+	}
+}
+
+// GoRabbitMetrics is an interface that defines the method for recording the consumer metrics.
+// It is used by the Metrics middleware, so any metrics library can be plugged in.
+type GoRabbitMetrics interface {
+	ObserveConsume(queue string, topic string, duration time.Duration, err error)
+}
+
+// Metrics is a function that creates a middleware recording the duration and the outcome of every handled message.
+// It takes a GoRabbitMetrics and returns a ConsumerMiddleware.
+func Metrics(m GoRabbitMetrics) ConsumerMiddleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			m.ObserveConsume(Queue(ctx), msg.RoutingKey, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// GoRabbitDedupStore is an interface that defines the methods for remembering the handled messages.
+// It is used by the Dedup middleware, see the godedup package for the Redis and Postgres stores.
+// Begin claims a key and returns the token of the claim, or false when the key is already handled, or an error
+// wrapping ErrDuplicateInProgress when it is claimed but not handled yet. Commit marks the key as handled, and
+// Abort releases the claim of the token so the message can be handled again. A claim that expired and was taken
+// over by another consumer holds another token, so it is not released.
+type GoRabbitDedupStore interface {
+	Begin(ctx context.Context, key string) (string, bool, error)
+	Commit(ctx context.Context, key string) error
+	Abort(ctx context.Context, key string, token string) error
+}
+
+// Dedup is a function that creates a middleware skipping the messages that were already handled.
+// It takes a GoRabbitDedupStore and returns a ConsumerMiddleware.
+// The messages are keyed by queue and message id. A duplicate of a handled message is acked without running
+// the handler, a duplicate of a message still being handled is retried later, and a failed message is released
+// so its retry can run. Dedup should run inside Recovery, so a panic
+// releases the message as well.
+func Dedup(store GoRabbitDedupStore) ConsumerMiddleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) error {
+			if msg.MessageId == "" {
+				return next(ctx, msg)
+			}
+
+			key := Queue(ctx) + ":" + msg.MessageId
+
+			token, ok, err := store.Begin(ctx, key)
+			if err != nil {
+				return fmt.Errorf("error claiming message: %w", err)
+			}
+			if !ok {
+				return nil
+			}
+
+			if err := next(ctx, msg); err != nil {
+				if aerr := store.Abort(context.WithoutCancel(ctx), key, token); aerr != nil {
+					return errors.Join(err, fmt.Errorf("error releasing message: %w", aerr))
+				}
+				return err
+			}
+
+			// The message is handled, so a failed commit is not an error of the message.
+			// It only lets a redelivery of the message run again.
+			_ = store.Commit(context.WithoutCancel(ctx), key)
+
+			return nil
+		}
+	}
+}
+
+// memoryDedupStore is a struct that implements the GoRabbitDedupStore interface in memory.
+// It is used when the consumers run in a single process.
+type memoryDedupStore struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	keys  map[string]memoryDedupKey
+	sweep time.Time
+}
+
+// memoryDedupKey is a struct that represents a key of the in-memory store.
+type memoryDedupKey struct {
+	token   string
+	done    bool
+	expires time.Time
+}
+
+// NewMemoryDedupStore is a function that creates a new in-memory GoRabbitDedupStore.
+// It takes a time.Duration and returns a GoRabbitDedupStore.
+// The keys are remembered for the duration, which should cover the redelivery window of the messages.
+func NewMemoryDedupStore(ttl time.Duration) GoRabbitDedupStore {
+	return &memoryDedupStore{
+		ttl:  ttl,
+		keys: make(map[string]memoryDedupKey),
+	}
+}
+
+// Begin is a function that claims a key.
+// It takes a context and a key and returns the token of the claim, a bool, and an error.
+// The expired keys are dropped at most once per TTL.
+func (s *memoryDedupStore) Begin(_ context.Context, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.sweep) {
+		for k, v := range s.keys {
+			if now.After(v.expires) {
+				delete(s.keys, k)
+			}
+		}
+		s.sweep = now.Add(s.ttl)
+	}
+
+	if v, ok := s.keys[key]; ok && now.Before(v.expires) {
+		if !v.done {
+			return "", false, ErrDuplicateInProgress
+		}
+		return "", false, nil
+	}
+
+	token := uuid.New().String()
+	s.keys[key] = memoryDedupKey{token: token, expires: now.Add(s.ttl)}
+	return token, true, nil
+}
+
+// Commit is a function that marks a key as handled.
+// It takes a context and a key and returns an error.
+func (s *memoryDedupStore) Commit(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key] = memoryDedupKey{done: true, expires: time.Now().Add(s.ttl)}
+	return nil
+}
+
+// Abort is a function that releases a key.
+// It takes a context, a key, and the token of the claim and returns an error.
+// The key is only deleted while it holds the claim of the token.
+func (s *memoryDedupStore) Abort(_ context.Context, key string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.keys[key]; ok && !v.done && v.token == token {
+		delete(s.keys, key)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package gorabbit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// waitIdle waits until the in-memory broker handled every message.
+func waitIdle(t *testing.T, m *GoRabbitMemory) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := m.WaitIdle(ctx); err != nil {
+		t.Fatalf("WaitIdle: %v", err)
+	}
+}
+
+// recordMiddleware is a function that creates a middleware appending its name to calls before and after the handler.
+func recordMiddleware(mu *sync.Mutex, calls *[]string, name string) ConsumerMiddleware {
+	return func(next ConsumerFunc) ConsumerFunc {
+		return func(ctx context.Context, msg amqp091.Delivery) error {
+			mu.Lock()
+			*calls = append(*calls, name)
+			mu.Unlock()
+
+			err := next(ctx, msg)
+
+			mu.Lock()
+			*calls = append(*calls, "/"+name)
+			mu.Unlock()
+			return err
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{
+		Queues: map[string]GoRabbitQueue{"orders": {
+			Middlewares: []ConsumerMiddleware{recordMiddleware(&mu, &calls, "queue")},
+		}},
+	}, nil)
+	m.Use(recordMiddleware(&mu, &calls, "global 1"), recordMiddleware(&mu, &calls, "global 2"))
+
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {
+				Consume: func(ctx context.Context, _ amqp091.Delivery) error {
+					mu.Lock()
+					defer mu.Unlock()
+					calls = append(calls, "handler "+Queue(ctx))
+					return nil
+				},
+				Middlewares: []ConsumerMiddleware{nil, recordMiddleware(&mu, &calls, "topic")},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []string{"global 1", "global 2", "queue", "topic", "handler orders", "/topic", "/queue", "/global 2", "/global 1"}
+	if !slices.Equal(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	fn := Recovery(nil)(func(context.Context, amqp091.Delivery) error {
+		panic("boom")
+	})
+
+	if err := fn(context.Background(), amqp091.Delivery{}); !errors.Is(err, ErrConsumerPanic) {
+		t.Fatalf("error = %v, want ErrConsumerPanic", err)
+	}
+
+	errFailed := errors.New("failed")
+	fn = Recovery(nil)(func(context.Context, amqp091.Delivery) error { return errFailed })
+	if err := fn(context.Background(), amqp091.Delivery{}); err != errFailed {
+		t.Fatalf("error = %v, want the handler error", err)
+	}
+}
+
+func TestRecoveryRetries(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+	m.Use(Recovery(testLogger()))
+
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(context.Context, amqp091.Delivery) error {
+				panic("boom")
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	dead := m.DeadLettered()
+	if len(dead) != 1 || !errors.Is(dead[0].Err, ErrConsumerPanic) || dead[0].RetryCount != defaultRetryMaxAttempts-1 {
+		t.Fatalf("dead-lettered = %+v, want the retried message", dead)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	fn := Timeout(10 * time.Millisecond)(func(ctx context.Context, _ amqp091.Delivery) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if err := fn(context.Background(), amqp091.Delivery{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	log.SetFormatter(&logrus.JSONFormatter{})
+
+	fn := Logging(log)(func(context.Context, amqp091.Delivery) error {
+		return errors.New("failed")
+	})
+
+	ctx := context.WithValue(context.Background(), queueKey{}, "orders")
+	ctx = deliveryContext(ctx, amqp091.Delivery{MessageId: "order-1"})
+	_ = fn(ctx, amqp091.Delivery{MessageId: "order-1", RoutingKey: "order.created"})
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["queue"] != "orders" || entry["topic"] != "order.created" || entry["messageId"] != "order-1" ||
+		entry["requestId"] != "order-1" || entry["traceId"] == nil || entry["error"] != "failed" || entry["level"] != "error" {
+		t.Fatalf("entry = %v", entry)
+	}
+}
+
+// testMetrics is a GoRabbitMetrics recording the observations.
+type testMetrics struct {
+	queue string
+	topic string
+	err   error
+}
+
+func (m *testMetrics) ObserveConsume(queue string, topic string, _ time.Duration, err error) {
+	m.queue, m.topic, m.err = queue, topic, err
+}
+
+func TestMetrics(t *testing.T) {
+	errFailed := errors.New("failed")
+	rec := &testMetrics{}
+	fn := Metrics(rec)(func(context.Context, amqp091.Delivery) error { return errFailed })
+
+	ctx := context.WithValue(context.Background(), queueKey{}, "orders")
+	_ = fn(ctx, amqp091.Delivery{RoutingKey: "order.created"})
+
+	if rec.queue != "orders" || rec.topic != "order.created" || rec.err != errFailed {
+		t.Fatalf("observed %+v", rec)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+	m.Use(Dedup(NewMemoryDedupStore(time.Hour)))
+
+	var calls atomic.Int32
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(context.Context, amqp091.Delivery) error {
+				calls.Add(1)
+				return nil
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := m.Publish(ctx, GoRabbitPublisherOption{
+			MessageId: "order-1",
+			Topic:     "order.created",
+			Message:   "hello",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitIdle(t, m)
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("handler called %d times, want 1", n)
+	}
+	// The duplicate is acked without running the handler.
+	if n := len(m.Acked()); n != 2 {
+		t.Fatalf("acked %d messages, want 2", n)
+	}
+}
+
+func TestDedupReleasesFailedMessage(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+	m.Use(Dedup(NewMemoryDedupStore(time.Hour)))
+
+	var calls atomic.Int32
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(context.Context, amqp091.Delivery) error {
+				if calls.Add(1) == 1 {
+					return errors.New("temporary failure")
+				}
+				return nil
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Publish(ctx, GoRabbitPublisherOption{
+		MessageId: "order-1",
+		Topic:     "order.created",
+		Message:   "hello",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	// The failed attempt released the message, so its retry ran the handler again.
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("handler called %d times, want 2", n)
+	}
+	if acked := m.Acked(); len(acked) != 1 || acked[0].RetryCount != 1 {
+		t.Fatalf("acked = %+v, want the retried message", acked)
+	}
+}
+
+func TestMemoryDedupStoreAbort(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryDedupStore(time.Hour).(*memoryDedupStore)
+
+	stale, _, err := s.Begin(ctx, "orders:order-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The claim expires, and another consumer claims the redelivery.
+	s.keys["orders:order-1"] = memoryDedupKey{token: stale, expires: time.Now().Add(-time.Second)}
+	if _, ok, err := s.Begin(ctx, "orders:order-1"); err != nil || !ok {
+		t.Fatalf("Begin of an expired key = %v, %v, want the claim", ok, err)
+	}
+
+	// The abort of the first consumer must keep the claim of the other one.
+	if err := s.Abort(ctx, "orders:order-1", stale); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Begin(ctx, "orders:order-1"); !errors.Is(err, ErrDuplicateInProgress) {
+		t.Fatalf("Begin of a claimed key error = %v, want ErrDuplicateInProgress", err)
+	}
+}
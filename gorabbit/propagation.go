@@ -0,0 +1,139 @@
+package gorabbit
+
+import (
+	"context"
+	"strings"
+
+	"github.com/the-lanky/go-utils/gocontext"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// HeaderRequestID is the header that carries the request id of the message.
+	HeaderRequestID = "x-request-id"
+	// HeaderTraceParent is the header that carries the W3C traceparent of the message.
+	HeaderTraceParent = gocontext.HeaderTraceParent
+	// HeaderTraceState is the header that carries the W3C tracestate of the message.
+	HeaderTraceState = gocontext.HeaderTraceState
+	// HeaderUserId is the header that carries the user id of the message.
+	// The AMQP user-id property is not used, as the broker rejects it unless it matches the connection user.
+	HeaderUserId = "x-user-id"
+)
+
+// contextHeaders is a function that builds the headers of a message from a context.
+// It takes a context, a user id, custom headers, and custom headers of any type and returns an amqp091.Table.
+// The request id, the trace context, the user id, and the custom headers of the context are sent,
+// the user id and the custom headers of the publisher option taking precedence.
+func contextHeaders(ctx context.Context, userId string, custom map[string]string, raw amqp091.Table) amqp091.Table {
+	headers := amqp091.Table{}
+
+	for k, v := range gocontext.Headers(ctx) {
+		headers[k] = v
+	}
+	for k, v := range custom {
+		headers[k] = v
+	}
+	for k, v := range raw {
+		headers[k] = v
+	}
+
+	if v := gocontext.RequestID(ctx); v != "" {
+		headers[HeaderRequestID] = v
+	}
+	if v := gocontext.TraceParent(ctx); v != "" {
+		headers[HeaderTraceParent] = v
+	}
+	if v := gocontext.TraceState(ctx); v != "" {
+		headers[HeaderTraceState] = v
+	}
+
+	if userId == "" {
+		userId = gocontext.UserID(ctx)
+	}
+	if userId != "" {
+		headers[HeaderUserId] = userId
+	}
+
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+// contextAppId is a function that returns the app id of a message.
+// It takes a context and the app id of the publisher option and returns a string.
+func contextAppId(ctx context.Context, appId string) string {
+	if appId != "" {
+		return appId
+	}
+	return gocontext.AppID(ctx)
+}
+
+// deliveryContext is a function that rebuilds the context of a consumed message.
+// It takes the handler context and an amqp091.Delivery and returns a context.
+// The request id defaults to the message id, and a new trace is started when the message carries none,
+// so every consumed message can be traced in the logs.
+func deliveryContext(ctx context.Context, msg amqp091.Delivery) context.Context {
+	ctx = gocontext.WithRequestID(ctx, deliveryRequestId(msg))
+
+	if tp := headerString(msg.Headers, HeaderTraceParent); tp != "" {
+		ctx = gocontext.WithTraceParent(ctx, tp)
+		if ts := headerString(msg.Headers, HeaderTraceState); ts != "" {
+			ctx = gocontext.WithTraceState(ctx, ts)
+		}
+	} else {
+		ctx = gocontext.WithTraceParent(ctx, gocontext.NewTraceParent())
+	}
+
+	if userId := deliveryUserId(msg); userId != "" {
+		ctx = gocontext.WithUserID(ctx, userId)
+	}
+	if msg.AppId != "" {
+		ctx = gocontext.WithAppID(ctx, msg.AppId)
+	}
+
+	for k, v := range msg.Headers {
+		s, ok := v.(string)
+		if !ok || reservedHeader(k) {
+			continue
+		}
+		ctx = gocontext.WithHeader(ctx, k, s)
+	}
+
+	return ctx
+}
+
+// deliveryRequestId is a function that returns the request id of a message.
+// It takes an amqp091.Delivery and returns a string.
+// It returns the message id when the message carries no request id.
+func deliveryRequestId(msg amqp091.Delivery) string {
+	if v := headerString(msg.Headers, HeaderRequestID); v != "" {
+		return v
+	}
+	return msg.MessageId
+}
+
+// deliveryUserId is a function that returns the user id of a message.
+// It takes an amqp091.Delivery and returns a string.
+// The user id header is preferred over the AMQP user-id property.
+func deliveryUserId(msg amqp091.Delivery) string {
+	if v := headerString(msg.Headers, HeaderUserId); v != "" {
+		return v
+	}
+	return msg.UserId
+}
+
+// reservedHeader is a function that reports whether a header is set by gorabbit or the broker.
+// It takes a header name and returns a bool.
+// The other string headers are restored as custom headers of the consumer context.
+func reservedHeader(k string) bool {
+	switch k {
+	case HeaderRequestID, HeaderTraceParent, HeaderTraceState, HeaderUserId:
+		return true
+	}
+	return strings.HasPrefix(k, "x-gorabbit-") ||
+		strings.HasPrefix(k, "x-death") ||
+		strings.HasPrefix(k, "x-first-death-") ||
+		strings.HasPrefix(k, "x-last-death-")
+}
@@ -0,0 +1,118 @@
+package gorabbit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/the-lanky/go-utils/gocontext"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const testTraceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+func TestContextHeaders(t *testing.T) {
+	ctx := gocontext.WithRequestID(context.Background(), "req-1")
+	ctx = gocontext.WithTraceParent(ctx, testTraceParent)
+	ctx = gocontext.WithUserID(ctx, "user-1")
+	ctx = gocontext.WithHeader(ctx, "x-tenant", "acme")
+	ctx = gocontext.WithHeader(ctx, "x-region", "eu")
+
+	got := contextHeaders(ctx, "user-2", map[string]string{"x-region": "us"}, amqp091.Table{"x-count": int32(1)})
+	want := amqp091.Table{
+		HeaderRequestID:   "req-1",
+		HeaderTraceParent: testTraceParent,
+		HeaderUserId:      "user-2",
+		"x-tenant":        "acme",
+		"x-region":        "us",
+		"x-count":         int32(1),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("contextHeaders = %v, want %v", got, want)
+	}
+
+	if got := contextHeaders(context.Background(), "", nil, nil); got != nil {
+		t.Fatalf("contextHeaders of an empty context = %v, want nil", got)
+	}
+}
+
+func TestDeliveryContext(t *testing.T) {
+	ctx := deliveryContext(context.Background(), amqp091.Delivery{
+		MessageId: "order-1",
+		AppId:     "shop",
+		UserId:    "guest",
+		Headers: amqp091.Table{
+			HeaderRequestID:   "req-1",
+			HeaderTraceParent: testTraceParent,
+			HeaderTraceState:  "vendor=1",
+			HeaderUserId:      "user-1",
+			HeaderRetryCount:  int32(1),
+			HeaderRoutingKey:  "order.created",
+			"x-tenant":        "acme",
+			"x-count":         int32(1),
+		},
+	})
+
+	if v := gocontext.RequestID(ctx); v != "req-1" {
+		t.Errorf("RequestID = %q", v)
+	}
+	if v := gocontext.TraceParent(ctx); v != testTraceParent {
+		t.Errorf("TraceParent = %q", v)
+	}
+	if v := gocontext.TraceState(ctx); v != "vendor=1" {
+		t.Errorf("TraceState = %q", v)
+	}
+	if v := gocontext.UserID(ctx); v != "user-1" {
+		t.Errorf("UserID = %q, want the header over the AMQP property", v)
+	}
+	if v := gocontext.AppID(ctx); v != "shop" {
+		t.Errorf("AppID = %q", v)
+	}
+	// Only the custom string headers are restored.
+	if h := gocontext.Headers(ctx); !reflect.DeepEqual(h, map[string]string{"x-tenant": "acme"}) {
+		t.Errorf("Headers = %v", h)
+	}
+}
+
+func TestDeliveryContextDefaults(t *testing.T) {
+	ctx := deliveryContext(context.Background(), amqp091.Delivery{MessageId: "order-1"})
+
+	if v := gocontext.RequestID(ctx); v != "order-1" {
+		t.Errorf("RequestID = %q, want the message id", v)
+	}
+	if gocontext.TraceID(ctx) == "" {
+		t.Error("no trace was started")
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	got := make(chan context.Context, 1)
+	if err := m.Listen(context.Background(), GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(ctx context.Context, _ amqp091.Delivery) error {
+				got <- ctx
+				return nil
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := gocontext.WithRequestID(context.Background(), "req-1")
+	ctx = gocontext.WithTraceParent(ctx, testTraceParent)
+	ctx = gocontext.WithAppID(ctx, "shop")
+	ctx = gocontext.WithHeader(ctx, "x-tenant", "acme")
+	if err := m.Publish(ctx, GoRabbitPublisherOption{Topic: "order.created", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, m)
+
+	hctx := <-got
+	if gocontext.RequestID(hctx) != "req-1" || gocontext.TraceParent(hctx) != testTraceParent ||
+		gocontext.AppID(hctx) != "shop" || gocontext.Headers(hctx)["x-tenant"] != "acme" {
+		t.Fatalf("handler context fields = %v, headers = %v", gocontext.Fields(hctx), gocontext.Headers(hctx))
+	}
+}
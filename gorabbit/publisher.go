@@ -0,0 +1,566 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultPublishRetryDelay = 2000 * time.Millisecond
+	defaultPublishTimeout    = 10 * time.Second
+	defaultPublisherChannels = 16
+)
+
+// HeaderPublishId is the header that carries the id of a mandatory publish.
+// It is used to match a message returned by the broker with its publish, as the message ids
+// of different messages can be the same or empty.
+const HeaderPublishId = "x-gorabbit-publish-id"
+
+// pendingConfirm is a struct that represents a message waiting for the broker confirmation.
+// It is used to hand the confirmation over to the publishing goroutine.
+type pendingConfirm struct {
+	publishId string
+	returned  *amqp091.Return
+	done      chan error
+}
+
+// confirmChannel is a struct that represents a channel in confirm mode.
+// It is used to track the messages published on that channel by their delivery tag.
+type confirmChannel struct {
+	ch      *amqp091.Channel
+	mu      sync.Mutex
+	closed  bool
+	pending map[uint64]*pendingConfirm
+	byId    map[string]*pendingConfirm
+}
+
+// newPendingConfirm is a function that creates the pendingConfirm of a message.
+// It takes a pointer to an amqp091.Publishing and a mandatory flag and returns a pointer to a pendingConfirm.
+// A mandatory message is stamped with a new publish id, so the broker return can be matched with it.
+// The headers are copied first, as the same publishing is sent again on a retry.
+func newPendingConfirm(msg *amqp091.Publishing, mandatory bool) *pendingConfirm {
+	w := &pendingConfirm{done: make(chan error, 1)}
+	if !mandatory {
+		return w
+	}
+
+	w.publishId = uuid.NewString()
+
+	headers := make(amqp091.Table, len(msg.Headers)+1)
+	maps.Copy(headers, msg.Headers)
+	headers[HeaderPublishId] = w.publishId
+	msg.Headers = headers
+
+	return w
+}
+
+// confirmPublisher is a struct that represents the confirm-mode publisher.
+// It is used to publish messages and wait for the broker ack, nack, or return.
+// The messages are published on a connection of their own, so the flow control of the consumers
+// cannot block the publishers.
+type confirmPublisher struct {
+	mu   sync.RWMutex
+	pool *channelPool
+}
+
+// channelPool is a struct that represents the confirm-mode channels of the publisher connection.
+// It is used to publish from many goroutines at once. A channel is used by one publish at a time,
+// and is handed back once the message is sent, so the confirmations are awaited concurrently.
+type channelPool struct {
+	conn  *amqp091.Connection
+	idle  chan *confirmChannel
+	slots chan struct{}
+}
+
+// open is a function that creates the channel pool of the publisher connection.
+// It takes a pointer to an amqp091.Connection and the size of the pool and returns an error.
+// This is used to (re)create the publishing channels after connecting. The publisher owns the connection
+// from then on and closes it on close.
+func (p *confirmPublisher) open(conn *amqp091.Connection, size int) error {
+	if size <= 0 {
+		size = defaultPublisherChannels
+	}
+
+	pool := &channelPool{
+		conn:  conn,
+		idle:  make(chan *confirmChannel, size),
+		slots: make(chan struct{}, size),
+	}
+
+	// A channel is opened right away, so a connection that cannot publish fails to connect.
+	cc, err := pool.acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	pool.release(cc)
+
+	p.mu.Lock()
+	p.pool = pool
+	p.mu.Unlock()
+
+	return nil
+}
+
+// connection is a function that returns the publisher connection.
+// It takes nothing and returns a pointer to an amqp091.Connection.
+// It returns nil when the publisher is closed.
+func (p *confirmPublisher) connection() *amqp091.Connection {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.pool == nil {
+		return nil
+	}
+	return p.pool.conn
+}
+
+// openConfirmChannel is a function that opens a new channel in confirm mode.
+// It takes a pointer to an amqp091.Connection and returns a pointer to a confirmChannel and an error.
+// The confirmations and the returns of the channel are dispatched until it is closed.
+func openConfirmChannel(conn *amqp091.Connection) (*confirmChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("error opening publisher channel: %w", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		_ = ch.Close()
+		return nil, fmt.Errorf("error enabling publisher confirms: %w", err)
+	}
+
+	// Both notification channels are unbuffered on purpose: the broker sends a
+	// basic.return before the basic.ack of the same message, and unbuffered
+	// channels make the loop observe them in that order.
+	cc := &confirmChannel{
+		ch:      ch,
+		pending: make(map[uint64]*pendingConfirm),
+		byId:    make(map[string]*pendingConfirm),
+	}
+	confirms := ch.NotifyPublish(make(chan amqp091.Confirmation))
+	returns := ch.NotifyReturn(make(chan amqp091.Return))
+
+	go cc.loop(confirms, returns)
+
+	return cc, nil
+}
+
+// close is a function that closes the publisher connection.
+// It takes nothing and returns an error.
+// This is used on Close.
+func (p *confirmPublisher) close() error {
+	p.mu.Lock()
+	pool := p.pool
+	p.pool = nil
+	p.mu.Unlock()
+
+	if pool == nil || pool.conn.IsClosed() {
+		return nil
+	}
+	return pool.conn.Close()
+}
+
+// acquire is a function that takes a channel of the pool.
+// It takes a context and returns a pointer to a confirmChannel and an error.
+// An idle channel is reused, a new one is opened while the pool is not full, and the call waits for a
+// channel to be released otherwise.
+func (p *channelPool) acquire(ctx context.Context) (*confirmChannel, error) {
+	for {
+		var cc *confirmChannel
+
+		select {
+		case cc = <-p.idle:
+		default:
+			select {
+			case cc = <-p.idle:
+			case p.slots <- struct{}{}:
+				if p.conn.IsClosed() {
+					<-p.slots
+					return nil, ErrNotConnected
+				}
+				cc, err := openConfirmChannel(p.conn)
+				if err != nil {
+					<-p.slots
+					return nil, err
+				}
+				return cc, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		// A channel exception, e.g. publishing to an exchange that does not exist,
+		// closes only the channel, so it is dropped and another one is taken.
+		if !cc.ch.IsClosed() {
+			return cc, nil
+		}
+		<-p.slots
+	}
+}
+
+// release is a function that hands a channel back to the pool.
+// It takes a pointer to a confirmChannel and returns nothing.
+// A closed channel is dropped, which frees its slot.
+func (p *channelPool) release(cc *confirmChannel) {
+	if cc.ch.IsClosed() {
+		<-p.slots
+		return
+	}
+	p.idle <- cc
+}
+
+// publish is a function that publishes a message and waits for the broker confirmation.
+// It takes a context, an exchange, a routing key, a mandatory flag, and an amqp091.Publishing and returns an error.
+// This is used to know whether the broker accepted the message.
+func (p *confirmPublisher) publish(
+	ctx context.Context,
+	exchange string,
+	key string,
+	mandatory bool,
+	msg amqp091.Publishing,
+) error {
+	p.mu.RLock()
+	pool := p.pool
+	p.mu.RUnlock()
+
+	if pool == nil {
+		return ErrNotConnected
+	}
+
+	cc, err := pool.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := newPendingConfirm(&msg, mandatory)
+
+	// The delivery tag is only known while the channel is held, so the message is registered before it is sent.
+	seq := cc.ch.GetNextPublishSeqNo()
+	if !cc.add(seq, w) {
+		pool.release(cc)
+		return ErrNotConnected
+	}
+
+	if err := cc.ch.PublishWithContext(ctx, exchange, key, mandatory, false, msg); err != nil {
+		cc.remove(seq)
+		pool.release(cc)
+		return err
+	}
+
+	pool.release(cc)
+
+	return cc.wait(ctx, seq, w)
+}
+
+// add is a function that registers a message waiting for its confirmation.
+// It takes a delivery tag and a pointer to a pendingConfirm and returns a bool.
+// It returns false when the channel is already closed.
+func (c *confirmChannel) add(seq uint64, w *pendingConfirm) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.pending[seq] = w
+	if w.publishId != "" {
+		c.byId[w.publishId] = w
+	}
+	return true
+}
+
+// wait is a function that waits for the confirmation of a message.
+// It takes a context, a delivery tag, and a pointer to the pendingConfirm and returns an error.
+// The message is forgotten when the context is done first.
+func (c *confirmChannel) wait(ctx context.Context, seq uint64, w *pendingConfirm) error {
+	select {
+	case err := <-w.done:
+		return err
+	case <-ctx.Done():
+		c.remove(seq)
+		return ctx.Err()
+	}
+}
+
+// remove is a function that forgets a message waiting for its confirmation.
+// It takes a delivery tag and returns the removed pendingConfirm, if any.
+// This is used when the publish failed or the waiting goroutine gave up.
+func (c *confirmChannel) remove(seq uint64) *pendingConfirm {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := c.pending[seq]
+	if w == nil {
+		return nil
+	}
+	delete(c.pending, seq)
+	if w.publishId != "" {
+		delete(c.byId, w.publishId)
+	}
+	return w
+}
+
+// loop is a function that dispatches the confirmations and the returns of the channel.
+// It takes a channel of amqp091.Confirmation and a channel of amqp091.Return and returns nothing.
+// It fails every pending message once the channel is closed.
+func (c *confirmChannel) loop(
+	confirms <-chan amqp091.Confirmation,
+	returns <-chan amqp091.Return,
+) {
+	for confirms != nil || returns != nil {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			publishId, _ := ret.Headers[HeaderPublishId].(string)
+			c.mu.Lock()
+			if w := c.byId[publishId]; w != nil {
+				w.returned = &ret
+			}
+			c.mu.Unlock()
+		case cf, ok := <-confirms:
+			if !ok {
+				confirms = nil
+				continue
+			}
+			w := c.remove(cf.DeliveryTag)
+			if w == nil {
+				continue
+			}
+			switch {
+			case !cf.Ack:
+				w.done <- ErrPublishNacked
+			case w.returned != nil:
+				w.done <- fmt.Errorf(
+					"%w: %d %s",
+					ErrPublishUnroutable,
+					w.returned.ReplyCode,
+					w.returned.ReplyText,
+				)
+			default:
+				w.done <- nil
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for seq, w := range c.pending {
+		w.done <- ErrPublishNotConfirmed
+		delete(c.pending, seq)
+	}
+	c.byId = make(map[string]*pendingConfirm)
+}
+
+// Publish is a function that publishes the message.
+// It takes a context and a GoRabbitPublisherOption and returns an error.
+// This is used to publish the message.
+// The message is published as mandatory on one of the confirm-mode channels of the publisher connection,
+// so Publish is safe to call from many goroutines at once. Every attempt waits for the
+// broker ack, and a *GoRabbitPublishError is returned once the retries are used up.
+// An unroutable message is not retried. The message is encoded with the Codec of its content type,
+// which is set on the message along with its content encoding when it is compressed or encrypted.
+// A delayed message is published to its delay queue, declared on demand, and is routed to the exchange
+// once due, so it is only unroutable when the delay queue cannot be declared.
+func (r *rbt) Publish(
+	ctx context.Context,
+	opt GoRabbitPublisherOption,
+) error {
+	r.log.Info("[GoRabbit] Publishing message...")
+
+	if r.trimSpace(opt.Topic) == "" {
+		return ErrTopicRequired
+	}
+
+	if opt.Message == nil {
+		return ErrMessageRequired
+	}
+
+	var (
+		sb         = 1
+		sbInterval = defaultPublishRetryDelay
+		idxProcess = 0
+		uid        = uuid.New().String()
+
+		err error
+	)
+
+	if opt.MessageId != "" {
+		uid = opt.MessageId
+	}
+
+	exchange := opt.Exchange
+	if exchange == "" {
+		exchange = r.exchangeName()
+	}
+
+	if opt.Retries > 0 {
+		sb = opt.Retries
+	}
+
+	if opt.RetryDelay > 0 {
+		sbInterval = time.Duration(opt.RetryDelay) * time.Millisecond
+	}
+
+	if r.conf.Debug {
+		r.log.Debug(opt.Message)
+	}
+
+	pub, err := r.publishing(ctx, opt, uid)
+	if err != nil {
+		r.log.Errorf("[GoRabbit] [%s] Error encoding message: %s", uid, err.Error())
+		return &GoRabbitPublishError{MessageId: uid, Topic: opt.Topic, Err: err}
+	}
+
+	target, delay := r.delayed(opt, exchange, &pub)
+
+	for idxProcess < sb {
+		if idxProcess > 0 && !sleep(ctx, sbInterval) {
+			err = ctx.Err()
+			break
+		}
+
+		r.log.Infof(
+			"[GoRabbit] [%d] [%s] Publishing topic: '%s' on '%s'",
+			idxProcess,
+			uid,
+			opt.Topic,
+			exchange,
+		)
+
+		idxProcess++
+
+		if delay > 0 {
+			if err = r.declareDelay(exchange, delay); err != nil {
+				r.log.Errorf("[GoRabbit] [%d] [%s] %s", idxProcess-1, uid, err.Error())
+				continue
+			}
+		}
+
+		pctx, cancel := context.WithTimeout(ctx, defaultPublishTimeout)
+		err = r.pub.publish(
+			pctx,
+			target,
+			opt.Topic,
+			true,
+			pub,
+		)
+		cancel()
+
+		if err == nil {
+			r.log.Infof(
+				"[GoRabbit] [%s] Message published successfully",
+				uid,
+			)
+			return nil
+		}
+
+		r.log.Errorf(
+			"[GoRabbit] [%d] [%s] Error publishing message: %s",
+			idxProcess-1,
+			uid,
+			err.Error(),
+		)
+
+		if errors.Is(err, ErrPublishUnroutable) {
+			// The delay queue is gone, so it is declared again on the next attempt.
+			if delay > 0 {
+				r.forgetDelay(exchange, delay)
+				continue
+			}
+			break
+		}
+	}
+
+	r.log.Errorf(
+		"[GoRabbit] [%s] Error publishing message. Attempts: %d/%d",
+		uid,
+		idxProcess,
+		sb,
+	)
+
+	return &GoRabbitPublishError{
+		MessageId: uid,
+		Topic:     opt.Topic,
+		Attempts:  idxProcess,
+		Err:       err,
+	}
+}
+
+// publishing is a function that builds the publishing of a message.
+// It takes a context, a GoRabbitPublisherOption, and the message id and returns an amqp091.Publishing and an error.
+// The message is encoded with the Codec of its content type, and the properties of the option are set with
+// their defaults.
+func (r *rbt) publishing(ctx context.Context, opt GoRabbitPublisherOption, uid string) (amqp091.Publishing, error) {
+	codec, err := r.codec(opt.ContentType, opt.Topic)
+	if err != nil {
+		return amqp091.Publishing{}, err
+	}
+
+	headers, err := r.versioned(opt.Topic, codec, opt.Message, contextHeaders(ctx, opt.UserId, opt.Headers, opt.RawHeaders))
+	if err != nil {
+		return amqp091.Publishing{}, err
+	}
+
+	body, encoding, err := r.encode(codec, opt.Topic, opt.Message)
+	if err != nil {
+		return amqp091.Publishing{}, err
+	}
+
+	mode := amqp091.Persistent
+	if opt.Transient {
+		mode = amqp091.Transient
+	}
+
+	ts := opt.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	exp := ""
+	if opt.Expiration > 0 {
+		exp = strconv.FormatInt(max(opt.Expiration.Milliseconds(), 1), 10)
+	}
+
+	return amqp091.Publishing{
+		Headers:         headers,
+		ContentType:     codec.ContentType(),
+		ContentEncoding: encoding,
+		DeliveryMode:    mode,
+		Priority:        opt.Priority,
+		CorrelationId:   opt.CorrelationId,
+		ReplyTo:         opt.ReplyTo,
+		Expiration:      exp,
+		MessageId:       uid,
+		Timestamp:       ts,
+		Type:            opt.Type,
+		AppId:           contextAppId(ctx, opt.AppId),
+		Body:            body,
+	}, nil
+}
+
+// sleep is a function that waits for the duration or until the context is done.
+// It takes a context and a time.Duration and returns a bool.
+// It returns false when the context is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,257 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/the-lanky/go-utils/gocontext"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestNewPendingConfirm(t *testing.T) {
+	headers := amqp091.Table{"x-tenant": "acme"}
+	msg := amqp091.Publishing{MessageId: "order-1", Headers: headers}
+
+	w := newPendingConfirm(&msg, true)
+	if w.publishId == "" || msg.Headers[HeaderPublishId] != w.publishId {
+		t.Fatalf("publish id = %q, header = %v", w.publishId, msg.Headers[HeaderPublishId])
+	}
+	if msg.Headers["x-tenant"] != "acme" {
+		t.Errorf("headers = %v, want the original headers kept", msg.Headers)
+	}
+	// The publishing is sent again on a retry, so the caller's table must not change.
+	if _, ok := headers[HeaderPublishId]; ok {
+		t.Error("the original headers were changed")
+	}
+
+	if again := newPendingConfirm(&msg, true); again.publishId == w.publishId {
+		t.Error("two publishes got the same publish id")
+	}
+
+	msg = amqp091.Publishing{}
+	if w := newPendingConfirm(&msg, false); w.publishId != "" || msg.Headers != nil {
+		t.Errorf("a message that is not mandatory was stamped: %q, %v", w.publishId, msg.Headers)
+	}
+}
+
+func TestConfirmChannelReturns(t *testing.T) {
+	cc := &confirmChannel{
+		pending: make(map[uint64]*pendingConfirm),
+		byId:    make(map[string]*pendingConfirm),
+	}
+
+	// The first two messages share a message id, and the third has none.
+	msgs := []amqp091.Publishing{{MessageId: "order-1"}, {MessageId: "order-1"}, {}}
+	ws := make([]*pendingConfirm, len(msgs))
+	for i := range msgs {
+		ws[i] = newPendingConfirm(&msgs[i], true)
+		if !cc.add(uint64(i+1), ws[i]) {
+			t.Fatal("add on an open channel failed")
+		}
+	}
+
+	confirms := make(chan amqp091.Confirmation)
+	returns := make(chan amqp091.Return)
+	done := make(chan struct{})
+	go func() {
+		cc.loop(confirms, returns)
+		close(done)
+	}()
+
+	// The broker sends the return of a message before its ack.
+	returns <- amqp091.Return{ReplyCode: 312, ReplyText: "NO_ROUTE", MessageId: "order-1", Headers: msgs[0].Headers}
+	confirms <- amqp091.Confirmation{DeliveryTag: 1, Ack: true}
+	confirms <- amqp091.Confirmation{DeliveryTag: 2, Ack: true}
+	returns <- amqp091.Return{ReplyCode: 312, ReplyText: "NO_ROUTE", Headers: msgs[2].Headers}
+	confirms <- amqp091.Confirmation{DeliveryTag: 3, Ack: true}
+
+	tests := []struct {
+		name    string
+		wantErr error
+	}{
+		{name: "returned", wantErr: ErrPublishUnroutable},
+		{name: "same message id", wantErr: nil},
+		{name: "no message id", wantErr: ErrPublishUnroutable},
+	}
+
+	for i, tt := range tests {
+		if err := <-ws[i].done; !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if len(cc.pending) != 0 || len(cc.byId) != 0 {
+		t.Errorf("pending = %d, byId = %d, want the confirmed messages forgotten", len(cc.pending), len(cc.byId))
+	}
+
+	close(confirms)
+	close(returns)
+	<-done
+}
+
+func TestConfirmChannelClosed(t *testing.T) {
+	cc := &confirmChannel{
+		pending: make(map[uint64]*pendingConfirm),
+		byId:    make(map[string]*pendingConfirm),
+	}
+
+	msg := amqp091.Publishing{}
+	w := newPendingConfirm(&msg, true)
+	cc.add(1, w)
+
+	confirms := make(chan amqp091.Confirmation)
+	returns := make(chan amqp091.Return)
+	close(confirms)
+	close(returns)
+	cc.loop(confirms, returns)
+
+	if err := <-w.done; !errors.Is(err, ErrPublishNotConfirmed) {
+		t.Fatalf("error = %v, want ErrPublishNotConfirmed", err)
+	}
+	if cc.add(2, newPendingConfirm(&msg, true)) {
+		t.Fatal("add on a closed channel succeeded")
+	}
+}
+
+func TestPublishing(t *testing.T) {
+	r := &rbt{}
+	ts := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	ctx := gocontext.WithAppID(context.Background(), "context-app")
+
+	pub, err := r.publishing(ctx, GoRabbitPublisherOption{
+		Topic:         "order.created",
+		Message:       testOrder{Id: "a", Total: 1},
+		UserId:        "user-1",
+		Headers:       map[string]string{"x-tenant": "acme"},
+		RawHeaders:    amqp091.Table{"x-count": int32(1)},
+		Transient:     true,
+		Priority:      5,
+		Expiration:    1500 * time.Microsecond,
+		Timestamp:     ts,
+		Type:          "OrderCreated",
+		ReplyTo:       "replies",
+		CorrelationId: "corr-1",
+	}, "order-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := amqp091.Publishing{
+		Headers:       amqp091.Table{"x-tenant": "acme", "x-count": int32(1), HeaderUserId: "user-1"},
+		ContentType:   ContentTypeJSON,
+		DeliveryMode:  amqp091.Transient,
+		Priority:      5,
+		CorrelationId: "corr-1",
+		ReplyTo:       "replies",
+		Expiration:    "1",
+		MessageId:     "order-1",
+		Timestamp:     ts,
+		Type:          "OrderCreated",
+		AppId:         "context-app",
+		Body:          []byte(`{"id":"a","total":1}`),
+	}
+	if !reflect.DeepEqual(pub, want) {
+		t.Fatalf("publishing =\n%+v\nwant\n%+v", pub, want)
+	}
+}
+
+func TestPublishingDefaults(t *testing.T) {
+	r := &rbt{}
+
+	before := time.Now()
+	pub, err := r.publishing(context.Background(), GoRabbitPublisherOption{
+		Topic:   "order.created",
+		Message: "hello",
+		AppId:   "shop",
+	}, "order-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pub.DeliveryMode != amqp091.Persistent {
+		t.Errorf("delivery mode = %d, want persistent", pub.DeliveryMode)
+	}
+	if pub.Timestamp.Before(before) {
+		t.Errorf("timestamp = %v, want the publish time", pub.Timestamp)
+	}
+	if pub.Expiration != "" || pub.Headers != nil || pub.AppId != "shop" {
+		t.Errorf("publishing = %+v", pub)
+	}
+}
+
+func TestConfirmPublisherClosed(t *testing.T) {
+	p := &confirmPublisher{}
+
+	if conn := p.connection(); conn != nil {
+		t.Fatalf("connection = %v, want nil", conn)
+	}
+	if err := p.publish(context.Background(), "orders", "order.created", true, amqp091.Publishing{}); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("publish error = %v, want ErrNotConnected", err)
+	}
+	if err := p.close(); err != nil {
+		t.Fatalf("close error = %v", err)
+	}
+}
+
+func TestChannelPool(t *testing.T) {
+	p := &channelPool{
+		idle:  make(chan *confirmChannel, 1),
+		slots: make(chan struct{}, 1),
+	}
+
+	// The only channel of the pool is in use.
+	cc := &confirmChannel{ch: &amqp091.Channel{}}
+	p.slots <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire on a full pool = %v, want context.DeadlineExceeded", err)
+	}
+
+	// A waiting publish takes the channel once it is released.
+	got := make(chan *confirmChannel, 1)
+	go func() {
+		cc, err := p.acquire(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		got <- cc
+	}()
+	p.release(cc)
+
+	select {
+	case acquired := <-got:
+		if acquired != cc {
+			t.Fatalf("acquired %p, want the released channel %p", acquired, cc)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the released channel was not acquired")
+	}
+}
+
+func TestConfirmChannelWait(t *testing.T) {
+	cc := &confirmChannel{
+		pending: make(map[uint64]*pendingConfirm),
+		byId:    make(map[string]*pendingConfirm),
+	}
+
+	msg := amqp091.Publishing{}
+	w := newPendingConfirm(&msg, true)
+	cc.add(1, w)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The message is forgotten when the publish gives up waiting.
+	if err := cc.wait(ctx, 1, w); !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait error = %v, want context.Canceled", err)
+	}
+	if len(cc.pending) != 0 || len(cc.byId) != 0 {
+		t.Errorf("pending = %d, byId = %d, want the message forgotten", len(cc.pending), len(cc.byId))
+	}
+}
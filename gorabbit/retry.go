@@ -0,0 +1,337 @@
+package gorabbit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// HeaderRetryCount is the header that carries how many times the message has been retried.
+	HeaderRetryCount = "x-gorabbit-retry-count"
+	// HeaderRoutingKey is the header that carries the original routing key of a retried or dead-lettered message.
+	HeaderRoutingKey = "x-gorabbit-routing-key"
+	// HeaderExchange is the header that carries the original exchange of a retried or dead-lettered message.
+	HeaderExchange = "x-gorabbit-exchange"
+	// HeaderError is the header that carries the last handler error of a retried or dead-lettered message.
+	HeaderError = "x-gorabbit-error"
+
+	defaultDeadLetterExchange = "gorabbit.dead-letter"
+	defaultRetryMaxAttempts   = 3
+	defaultRetryInitialDelay  = 1 * time.Second
+	defaultRetryMultiplier    = 2
+	defaultRetryMaxDelay      = 5 * time.Minute
+)
+
+// GoRabbitRetryConfiguration is a struct that represents the retry configuration of the consumers.
+// It is used to build the retry queues and to decide when a message is dead-lettered.
+// MaxAttempts counts every run of the handler, so 1 disables the retries.
+type GoRabbitRetryConfiguration struct {
+	MaxAttempts  int           `mapstructure:"maxAttempts"`
+	InitialDelay time.Duration `mapstructure:"initialDelay"`
+	Multiplier   float64       `mapstructure:"multiplier"`
+	MaxDelay     time.Duration `mapstructure:"maxDelay"`
+}
+
+// withDefaults is a function that fills the zero values with the defaults.
+// It takes nothing and returns a GoRabbitRetryConfiguration.
+// This is used to read the retry configuration.
+func (c GoRabbitRetryConfiguration) withDefaults() GoRabbitRetryConfiguration {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if c.InitialDelay <= 0 {
+		c.InitialDelay = defaultRetryInitialDelay
+	}
+	if c.Multiplier < 1 {
+		c.Multiplier = defaultRetryMultiplier
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = defaultRetryMaxDelay
+	}
+	return c
+}
+
+// delay is a function that returns the backoff before the given retry.
+// It takes the retry number, starting at 1, and returns a time.Duration.
+// This is used as the TTL of the retry queue.
+func (c GoRabbitRetryConfiguration) delay(retry int) time.Duration {
+	d := float64(c.InitialDelay) * math.Pow(c.Multiplier, float64(retry-1))
+	if d > float64(c.MaxDelay) {
+		return c.MaxDelay
+	}
+	return time.Duration(d).Truncate(time.Millisecond)
+}
+
+// RetryCount is a function that returns how many times the message has been retried.
+// It takes an amqp091.Delivery and returns an int.
+// It returns 0 on the first delivery.
+func RetryCount(msg amqp091.Delivery) int {
+	return headerInt(msg.Headers, HeaderRetryCount)
+}
+
+// headerInt is a function that reads an integer header.
+// It takes an amqp091.Table and a key and returns an int.
+// This is used because the integer type of a header depends on how it was encoded.
+func headerInt(headers amqp091.Table, key string) int {
+	switch v := headers[key].(type) {
+	case int:
+		return v
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint8:
+		return int(v)
+	case uint16:
+		return int(v)
+	case uint32:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+// headerString is a function that reads a string header.
+// It takes an amqp091.Table and a key and returns a string.
+// It returns an empty string when the header is missing.
+func headerString(headers amqp091.Table, key string) string {
+	if v, ok := headers[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// retryConfig is a function that returns the retry configuration of a queue.
+// It takes a queue name and returns a GoRabbitRetryConfiguration.
+// This is used to read the retry configuration with the defaults applied.
+func (r *rbt) retryConfig(queue string) GoRabbitRetryConfiguration {
+	if conf := r.queueConfig(queue).Retry; conf != nil {
+		return conf.withDefaults()
+	}
+	return r.conf.Retry.withDefaults()
+}
+
+// deadLetterExchange is a function that returns the name of the dead-letter exchange.
+// It takes nothing and returns a string.
+// This is used to declare and publish to the dead-letter exchange.
+func (r *rbt) deadLetterExchange() string {
+	if r.conf.DeadLetterExchange != "" {
+		return r.conf.DeadLetterExchange
+	}
+	return defaultDeadLetterExchange
+}
+
+// retryQueueName is a function that returns the name of the retry queue for a delay.
+// It takes a queue name and a time.Duration and returns a string.
+// The delay is part of the name so changing the backoff never conflicts with an existing queue.
+func retryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%d", queue, delay.Milliseconds())
+}
+
+// deadLetterQueueName is a function that returns the name of the dead-letter queue.
+// It takes a queue name and returns a string.
+// This is used to declare the dead-letter queue of a queue.
+func deadLetterQueueName(queue string) string {
+	return queue + ".dead-letter"
+}
+
+// declareRetryTopology is a function that declares the retry queues and the dead-letter queue of a queue.
+// It takes a pointer to an amqp091.Channel and a queue name and returns an error.
+// A retry queue holds the message for its TTL and then dead-letters it back to the queue through the default exchange.
+func (r *rbt) declareRetryTopology(ch *amqp091.Channel, queue string) error {
+	conf := r.retryConfig(queue)
+
+	declared := make(map[string]bool)
+	for retry := 1; retry < conf.MaxAttempts; retry++ {
+		delay := conf.delay(retry)
+		name := retryQueueName(queue, delay)
+		if declared[name] {
+			continue
+		}
+		declared[name] = true
+
+		if _, err := ch.QueueDeclare(
+			name,
+			true,
+			false,
+			false,
+			false,
+			amqp091.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue,
+			},
+		); err != nil {
+			return fmt.Errorf("error declaring retry queue: '%s': %w", name, err)
+		}
+	}
+
+	dlx := r.deadLetterExchange()
+	if err := ch.ExchangeDeclare(
+		dlx,
+		amqp091.ExchangeDirect,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		return fmt.Errorf("error declaring dead-letter exchange: '%s': %w", dlx, err)
+	}
+
+	dlq := deadLetterQueueName(queue)
+	if _, err := ch.QueueDeclare(
+		dlq,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		return fmt.Errorf("error declaring dead-letter queue: '%s': %w", dlq, err)
+	}
+
+	if err := ch.QueueBind(dlq, queue, dlx, false, nil); err != nil {
+		return fmt.Errorf("error binding dead-letter queue: '%s': %w", dlq, err)
+	}
+
+	return nil
+}
+
+// originalDelivery is a function that restores the original exchange and routing key of a retried message.
+// It takes an amqp091.Delivery and returns an amqp091.Delivery.
+// A retried message comes back through the default exchange with the queue name as the routing key.
+func originalDelivery(msg amqp091.Delivery) amqp091.Delivery {
+	if key := headerString(msg.Headers, HeaderRoutingKey); key != "" {
+		msg.RoutingKey = key
+		msg.Exchange = headerString(msg.Headers, HeaderExchange)
+	}
+	return msg
+}
+
+// republishing is a function that copies a delivery into a publishing.
+// It takes an amqp091.Delivery, the original body, and extra headers and returns an amqp091.Publishing.
+// The expiration and the user id are not copied, so the message neither expires in the retry queue
+// nor gets rejected by the broker user validation.
+func republishing(msg amqp091.Delivery, body []byte, extra amqp091.Table) amqp091.Publishing {
+	headers := amqp091.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	for k, v := range extra {
+		headers[k] = v
+	}
+	headers[HeaderRoutingKey] = msg.RoutingKey
+	headers[HeaderExchange] = msg.Exchange
+
+	return amqp091.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		AppId:           msg.AppId,
+		Body:            body,
+	}
+}
+
+// retry is a function that sends a failed message to its retry queue, or to the dead-letter exchange
+// once the attempts are used up.
+// It takes a queue name, the delivery, the original body, and the handler error and returns nothing.
+// The delivery is acked once the broker confirmed the new message, and requeued when that failed.
+func (r *rbt) retry(queue string, msg amqp091.Delivery, body []byte, cause error) {
+	conf := r.retryConfig(queue)
+	count := RetryCount(msg) + 1
+
+	if count >= conf.MaxAttempts {
+		r.deadLetter(queue, msg, body, cause)
+		return
+	}
+
+	delay := conf.delay(count)
+	name := retryQueueName(queue, delay)
+
+	r.log.Infof(
+		"[GoRabbit] [%s] [%s] Retrying message in %s (%d/%d)",
+		msg.MessageId,
+		msg.RoutingKey,
+		delay,
+		count,
+		conf.MaxAttempts-1,
+	)
+
+	r.forward(
+		msg,
+		"",
+		name,
+		republishing(msg, body, amqp091.Table{
+			HeaderRetryCount: int32(count),
+			HeaderError:      cause.Error(),
+		}),
+	)
+}
+
+// deadLetter is a function that sends a message to the dead-letter exchange.
+// It takes a queue name, the delivery, the original body, and the error and returns nothing.
+// The message is routed to the dead-letter queue of the queue it was consumed from.
+func (r *rbt) deadLetter(queue string, msg amqp091.Delivery, body []byte, cause error) {
+	r.log.Errorf(
+		"[GoRabbit] [%s] [%s] Dead-lettering message: %s",
+		msg.MessageId,
+		msg.RoutingKey,
+		cause.Error(),
+	)
+
+	r.forward(
+		msg,
+		r.deadLetterExchange(),
+		queue,
+		republishing(msg, body, amqp091.Table{
+			HeaderError: cause.Error(),
+		}),
+	)
+}
+
+// forward is a function that publishes a copy of a delivery and settles the delivery.
+// It takes the delivery, an exchange, a routing key, and an amqp091.Publishing and returns nothing.
+// This is used to move a message to a retry queue or to the dead-letter exchange.
+func (r *rbt) forward(
+	msg amqp091.Delivery,
+	exchange string,
+	key string,
+	pub amqp091.Publishing,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultPublishTimeout)
+	defer cancel()
+
+	if err := r.pub.publish(ctx, exchange, key, true, pub); err != nil {
+		r.log.Errorf(
+			"[GoRabbit] [%s] [%s] Error forwarding message to '%s', requeueing: %s",
+			msg.MessageId,
+			msg.RoutingKey,
+			key,
+			err.Error(),
+		)
+		if err := msg.Nack(false, true); err != nil {
+			r.log.Errorf("[GoRabbit] [%s] Error requeueing message: %s", msg.MessageId, err.Error())
+		}
+		return
+	}
+
+	if err := msg.Ack(false); err != nil {
+		r.log.Errorf("[GoRabbit] [%s] Error acknowledging message: %s", msg.MessageId, err.Error())
+	}
+}
@@ -0,0 +1,156 @@
+package gorabbit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// testAcknowledger is an amqp091.Acknowledger recording how a delivery was settled.
+type testAcknowledger struct {
+	acked    bool
+	requeued bool
+}
+
+func (a *testAcknowledger) Ack(uint64, bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *testAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	a.requeued = requeue
+	return nil
+}
+
+func (a *testAcknowledger) Reject(_ uint64, requeue bool) error {
+	return a.Nack(0, false, requeue)
+}
+
+func TestRetryConfiguration(t *testing.T) {
+	c := GoRabbitRetryConfiguration{}.withDefaults()
+	if c.MaxAttempts != defaultRetryMaxAttempts || c.InitialDelay != defaultRetryInitialDelay ||
+		c.Multiplier != defaultRetryMultiplier || c.MaxDelay != defaultRetryMaxDelay {
+		t.Fatalf("withDefaults = %+v", c)
+	}
+
+	c = GoRabbitRetryConfiguration{InitialDelay: time.Second, Multiplier: 3, MaxDelay: 20 * time.Second}.withDefaults()
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{retry: 1, want: time.Second},
+		{retry: 2, want: 3 * time.Second},
+		{retry: 3, want: 9 * time.Second},
+		{retry: 4, want: 20 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := c.delay(tt.retry); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.retry, got, tt.want)
+		}
+	}
+}
+
+func TestRetryConfigByQueue(t *testing.T) {
+	r := &rbt{conf: GoRabbitConfiguration{
+		Retry:  GoRabbitRetryConfiguration{MaxAttempts: 4},
+		Queues: map[string]GoRabbitQueue{"orders": {Retry: &GoRabbitRetryConfiguration{MaxAttempts: 1}}},
+	}}
+
+	if n := r.retryConfig("orders").MaxAttempts; n != 1 {
+		t.Errorf("orders attempts = %d, want 1", n)
+	}
+	if n := r.retryConfig("users").MaxAttempts; n != 4 {
+		t.Errorf("users attempts = %d, want 4", n)
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  int
+	}{
+		{name: "missing", want: 0},
+		{name: "int32", value: int32(2), want: 2},
+		{name: "int64", value: int64(3), want: 3},
+		{name: "uint8", value: uint8(4), want: 4},
+		{name: "string", value: "5", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := amqp091.Delivery{Headers: amqp091.Table{}}
+			if tt.value != nil {
+				msg.Headers[HeaderRetryCount] = tt.value
+			}
+			if got := RetryCount(msg); got != tt.want {
+				t.Fatalf("RetryCount = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepublishing(t *testing.T) {
+	msg := amqp091.Delivery{
+		Exchange:     "orders",
+		RoutingKey:   "order.created",
+		Headers:      amqp091.Table{"x-tenant": "acme", HeaderRetryCount: int32(1)},
+		ContentType:  "application/json",
+		DeliveryMode: amqp091.Persistent,
+		MessageId:    "order-1",
+		Expiration:   "1000",
+		UserId:       "guest",
+	}
+
+	pub := republishing(msg, []byte("body"), amqp091.Table{HeaderRetryCount: int32(2)})
+
+	if pub.Headers[HeaderRetryCount] != int32(2) || pub.Headers["x-tenant"] != "acme" {
+		t.Errorf("headers = %v", pub.Headers)
+	}
+	if pub.Headers[HeaderRoutingKey] != "order.created" || pub.Headers[HeaderExchange] != "orders" {
+		t.Errorf("headers = %v, want the original routing", pub.Headers)
+	}
+	if pub.Expiration != "" || pub.UserId != "" {
+		t.Errorf("expiration = %q, user id = %q, want them dropped", pub.Expiration, pub.UserId)
+	}
+	if pub.MessageId != "order-1" || pub.DeliveryMode != amqp091.Persistent || string(pub.Body) != "body" {
+		t.Errorf("publishing = %+v", pub)
+	}
+	// The headers of the delivery are not changed.
+	if msg.Headers[HeaderRetryCount] != int32(1) {
+		t.Errorf("delivery headers changed to %v", msg.Headers)
+	}
+
+	// The message comes back through the default exchange, and is handled as the original one.
+	back := originalDelivery(amqp091.Delivery{Exchange: "", RoutingKey: "orders", Headers: pub.Headers})
+	if back.Exchange != "orders" || back.RoutingKey != "order.created" {
+		t.Errorf("originalDelivery = %q %q", back.Exchange, back.RoutingKey)
+	}
+}
+
+func TestRetryNamesAndExchange(t *testing.T) {
+	if got := retryQueueName("orders", 1500*time.Millisecond); got != "orders.retry.1500" {
+		t.Errorf("retryQueueName = %q", got)
+	}
+	if got := deadLetterQueueName("orders"); got != "orders.dead-letter" {
+		t.Errorf("deadLetterQueueName = %q", got)
+	}
+	if got := (&rbt{}).deadLetterExchange(); got != defaultDeadLetterExchange {
+		t.Errorf("deadLetterExchange = %q", got)
+	}
+}
+
+func TestForwardNotConnected(t *testing.T) {
+	r := &rbt{log: testLogger()}
+	ack := &testAcknowledger{}
+	msg := amqp091.Delivery{Acknowledger: ack, RoutingKey: "order.created"}
+
+	// The message cannot be moved to its retry queue, so it goes back to its queue.
+	r.retry("orders", msg, nil, errors.New("temporary failure"))
+
+	if ack.acked || !ack.requeued {
+		t.Fatalf("acked = %v, requeued = %v, want the delivery requeued", ack.acked, ack.requeued)
+	}
+}
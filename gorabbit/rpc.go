@@ -0,0 +1,404 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// HeaderReplyError is the header that carries the handler error of a reply.
+	HeaderReplyError = "x-gorabbit-reply-error"
+
+	directReplyTo     = "amq.rabbitmq.reply-to"
+	defaultRPCTimeout = 30 * time.Second
+)
+
+// rpcResult is a struct that represents the outcome of a call.
+// It is used to hand the reply over to the calling goroutine.
+type rpcResult struct {
+	msg amqp091.Delivery
+	err error
+}
+
+// rpcClient is a struct that represents the client side of the calls.
+// It is used to keep the channel consuming the direct reply-to pseudo queue.
+// The channel is opened on the first call and opened again after it was closed.
+type rpcClient struct {
+	mu      sync.Mutex
+	current *rpcChannel
+}
+
+// rpcChannel is a struct that represents a channel the calls are published and answered on.
+// It is used to route the replies to the pending calls by correlation id.
+type rpcChannel struct {
+	ch *amqp091.Channel
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[string]chan rpcResult
+}
+
+// channel is a function that returns the open reply channel, opening it when needed.
+// It takes a pointer to an amqp091.Connection and returns a pointer to a rpcChannel and an error.
+// Direct reply-to needs the request to be published on the channel consuming the replies.
+func (c *rpcClient) channel(conn *amqp091.Connection) (*rpcChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current != nil && !c.current.isClosed() {
+		return c.current, nil
+	}
+
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("error opening reply channel: %w", err)
+	}
+
+	msgs, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		_ = ch.Close()
+		return nil, fmt.Errorf("error consuming replies: %w", err)
+	}
+
+	rc := &rpcChannel{
+		ch:      ch,
+		pending: make(map[string]chan rpcResult),
+	}
+	returns := ch.NotifyReturn(make(chan amqp091.Return))
+
+	go rc.loop(msgs, returns)
+
+	c.current = rc
+	return rc, nil
+}
+
+// close is a function that closes the reply channel.
+// It takes nothing and returns an error.
+// This is used on shutdown.
+func (c *rpcClient) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current == nil || c.current.ch.IsClosed() {
+		return nil
+	}
+	return c.current.ch.Close()
+}
+
+// add is a function that registers a pending call.
+// It takes a correlation id and returns a channel of rpcResult and a bool.
+// It returns false when the channel is already closed.
+func (c *rpcChannel) add(id string) (chan rpcResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, false
+	}
+
+	res := make(chan rpcResult, 1)
+	c.pending[id] = res
+	return res, true
+}
+
+// remove is a function that forgets a pending call.
+// It takes a correlation id and returns nothing.
+func (c *rpcChannel) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, id)
+}
+
+// resolve is a function that hands the outcome over to a pending call.
+// It takes a correlation id and a rpcResult and returns nothing.
+// Replies to calls that already gave up are dropped.
+func (c *rpcChannel) resolve(id string, res rpcResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if w, ok := c.pending[id]; ok {
+		delete(c.pending, id)
+		w <- res
+	}
+}
+
+// isClosed is a function that reports whether the channel is closed.
+// It takes nothing and returns a bool.
+func (c *rpcChannel) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// loop is a function that routes the replies and the returned requests to the pending calls.
+// It takes the channel of the replies and the channel of the returns and returns nothing.
+// The pending calls fail with ErrReplyLost once the channel is closed.
+func (c *rpcChannel) loop(msgs <-chan amqp091.Delivery, returns <-chan amqp091.Return) {
+	for msgs != nil || returns != nil {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				msgs = nil
+				continue
+			}
+			c.resolve(msg.CorrelationId, rpcResult{msg: msg})
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			c.resolve(ret.CorrelationId, rpcResult{
+				err: fmt.Errorf("%w: %d %s", ErrPublishUnroutable, ret.ReplyCode, ret.ReplyText),
+			})
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	_ = c.ch.Close()
+	for id, w := range c.pending {
+		w <- rpcResult{err: ErrReplyLost}
+		delete(c.pending, id)
+	}
+}
+
+// Call is a function that sends a request and waits for its reply.
+// It takes a context, a topic, a request, and a pointer to the response and returns an error.
+// The request is published to the default exchange with a correlation id and the direct reply-to address,
+// and expires when the context is done. The context gets the configured RPC timeout when it has no deadline.
+// The reply is decoded into resp, which can be nil to ignore it. A *GoRabbitRemoteError is returned
+// when the handler failed, and an error wrapping ErrPublishUnroutable when no queue is bound for the topic.
+func (r *rbt) Call(ctx context.Context, topic string, req any, resp any) error {
+	if r.trimSpace(topic) == "" {
+		return ErrTopicRequired
+	}
+
+	if req == nil {
+		return ErrMessageRequired
+	}
+
+	ctx, cancel := r.callContext(ctx)
+	defer cancel()
+
+	uid := uuid.New().String()
+
+	codec, err := r.codec("", topic)
+	if err != nil {
+		r.log.Errorf("[GoRabbit] [%s] Error encoding request: %s", uid, err.Error())
+		return err
+	}
+
+	headers, err := r.versioned(topic, codec, req, contextHeaders(ctx, "", nil, nil))
+	if err != nil {
+		r.log.Errorf("[GoRabbit] [%s] Error encoding request: %s", uid, err.Error())
+		return err
+	}
+
+	body, encoding, err := r.encode(codec, topic, req)
+	if err != nil {
+		r.log.Errorf("[GoRabbit] [%s] Error encoding request: %s", uid, err.Error())
+		return err
+	}
+
+	r.mu.RLock()
+	conn := r.acn
+	r.mu.RUnlock()
+
+	rc, err := r.rpc.channel(conn)
+	if err != nil {
+		r.log.Errorf("[GoRabbit] [%s] %s", uid, err.Error())
+		return err
+	}
+
+	res, ok := rc.add(uid)
+	if !ok {
+		return ErrReplyLost
+	}
+	defer rc.remove(uid)
+
+	exp := ""
+	if deadline, ok := ctx.Deadline(); ok {
+		exp = strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10)
+	}
+
+	r.log.Infof(
+		"[GoRabbit] [%s] Calling topic: '%s' on '%s'",
+		uid,
+		topic,
+		r.exchangeName(),
+	)
+
+	if err := rc.ch.PublishWithContext(
+		ctx,
+		r.exchangeName(),
+		topic,
+		true,
+		false,
+		amqp091.Publishing{
+			Headers:         headers,
+			ContentType:     codec.ContentType(),
+			ContentEncoding: encoding,
+			MessageId:       uid,
+			AppId:           contextAppId(ctx, ""),
+			CorrelationId:   uid,
+			ReplyTo:         directReplyTo,
+			Expiration:      exp,
+			Body:            body,
+		},
+	); err != nil {
+		r.log.Errorf("[GoRabbit] [%s] Error publishing request: %s", uid, err.Error())
+		return err
+	}
+
+	var out rpcResult
+	select {
+	case <-ctx.Done():
+		r.log.Errorf("[GoRabbit] [%s] Call not answered: %s", uid, ctx.Err().Error())
+		return ctx.Err()
+	case out = <-res:
+	}
+
+	if out.err != nil {
+		r.log.Errorf("[GoRabbit] [%s] Error calling topic: %s", uid, out.err.Error())
+		return out.err
+	}
+
+	return r.decodeReply(topic, out.msg, resp)
+}
+
+// decodeReply is a function that decodes the reply of a call.
+// It takes a topic, the reply, and a pointer to the response and returns an error.
+// A *GoRabbitRemoteError is returned when the reply carries the handler error. The reply is decoded
+// with the Codec of its content type.
+func (r *rbt) decodeReply(topic string, msg amqp091.Delivery, resp any) error {
+	if e, ok := msg.Headers[HeaderReplyError]; ok {
+		return &GoRabbitRemoteError{Topic: topic, Message: fmt.Sprint(e)}
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	plain, err := r.open(msg)
+	if err != nil {
+		r.log.Errorf("[GoRabbit] [%s] Error opening reply: %s", msg.CorrelationId, err.Error())
+		return err
+	}
+
+	return Decode(plain, resp)
+}
+
+// callContext is a function that returns the context of a call.
+// It takes a context and returns a context and a context.CancelFunc.
+// The context gets the configured RPC timeout when it has no deadline.
+func (r *rbt) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+
+	timeout := defaultRPCTimeout
+	if r.conf.RPCTimeout > 0 {
+		timeout = r.conf.RPCTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// replierKey is the context key of the replier of the consumed message.
+type replierKey struct{}
+
+// replier is an interface that defines the method for replying to a request.
+// It is used by Reply, and is put in the handler context by the consumers.
+type replier interface {
+	reply(ctx context.Context, msg amqp091.Delivery, resp any, err error) error
+}
+
+// reply is a function that publishes the reply of a request.
+// It takes a context, the request, the response, and the handler error and returns an error.
+// The reply carries the correlation id of the request and goes to its reply-to address. A handler
+// error is sent in the HeaderReplyError header instead of a response. The response is encoded with the
+// Codec of the request.
+func (r *rbt) reply(ctx context.Context, msg amqp091.Delivery, resp any, err error) error {
+	codec := replyCodec(msg)
+	pub := amqp091.Publishing{
+		ContentType:   codec.ContentType(),
+		MessageId:     uuid.New().String(),
+		CorrelationId: msg.CorrelationId,
+	}
+
+	if err != nil {
+		r.log.Errorf(
+			"[GoRabbit] [%s] [%s] Replying with error: %s",
+			msg.MessageId,
+			msg.RoutingKey,
+			err.Error(),
+		)
+		pub.Headers = amqp091.Table{HeaderReplyError: err.Error()}
+	} else {
+		body, encoding, err := r.encode(codec, "", resp)
+		if err != nil {
+			return NonRetryable(fmt.Errorf("error encoding reply: %w", err))
+		}
+		pub.Body = body
+		pub.ContentEncoding = encoding
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, defaultPublishTimeout)
+	defer cancel()
+
+	if err := r.pub.publish(pctx, "", msg.ReplyTo, false, pub); err != nil {
+		return fmt.Errorf("error publishing reply: %w", err)
+	}
+
+	return nil
+}
+
+// Reply is a function that creates a consumer answering the calls of a topic.
+// It takes a handler receiving the decoded request and its metadata and returns a GoRabbitConsumer.
+// The request, already decrypted, is decoded into Req, and the returned Resp is sent back to the caller,
+// encrypted when the message encryption is enabled. A handler error, or a request that cannot be decoded,
+// is sent back as a *GoRabbitRemoteError and the request is not retried. The request is only retried
+// when the reply cannot be published.
+//
+//	consumers := gorabbit.GoRabbitConsumerMessages{
+//		"users": {
+//			"user.get": gorabbit.Reply(func(ctx context.Context, req GetUser, meta gorabbit.GoRabbitMeta) (User, error) {
+//				return svc.Get(ctx, req.Id)
+//			}),
+//		},
+//	}
+func Reply[Req any, Resp any](fn func(ctx context.Context, req Req, meta GoRabbitMeta) (Resp, error)) GoRabbitConsumer {
+	return GoRabbitConsumer{
+		Consume: func(ctx context.Context, d amqp091.Delivery) error {
+			rp, ok := ctx.Value(replierKey{}).(replier)
+			if !ok {
+				return NonRetryable(errors.New("reply handler used outside of a gorabbit consumer"))
+			}
+
+			if d.ReplyTo == "" {
+				return NonRetryable(ErrReplyToRequired)
+			}
+
+			var req Req
+			if err := Decode(d, &req); err != nil {
+				return rp.reply(ctx, d, nil, err)
+			}
+
+			resp, err := fn(ctx, req, MetaFromDelivery(d))
+			return rp.reply(ctx, d, resp, err)
+		},
+	}
+}
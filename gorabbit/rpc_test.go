@@ -0,0 +1,178 @@
+package gorabbit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// testReplier is a replier recording the reply.
+type testReplier struct {
+	resp any
+	err  error
+}
+
+func (r *testReplier) reply(_ context.Context, _ amqp091.Delivery, resp any, err error) error {
+	r.resp, r.err = resp, err
+	return nil
+}
+
+func TestReply(t *testing.T) {
+	c := Reply(func(_ context.Context, id string, _ GoRabbitMeta) (testOrder, error) {
+		return testOrder{Id: id}, nil
+	})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		msg      amqp091.Delivery
+		wantErr  error
+		wantResp any
+		replyErr error
+	}{
+		{
+			name:    "outside of a consumer",
+			ctx:     context.Background(),
+			msg:     amqp091.Delivery{ReplyTo: directReplyTo, ContentType: ContentTypeJSON, Body: []byte(`"a"`)},
+			wantErr: ErrNonRetryable,
+		},
+		{
+			name:    "no reply-to",
+			msg:     amqp091.Delivery{ContentType: ContentTypeJSON, Body: []byte(`"a"`)},
+			wantErr: ErrReplyToRequired,
+		},
+		{
+			name:     "reply",
+			msg:      amqp091.Delivery{ReplyTo: directReplyTo, ContentType: ContentTypeJSON, Body: []byte(`"a"`)},
+			wantResp: testOrder{Id: "a"},
+		},
+		{
+			name:     "undecodable request",
+			msg:      amqp091.Delivery{ReplyTo: directReplyTo, ContentType: ContentTypeJSON, Body: []byte(`1`)},
+			replyErr: ErrDecode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := &testReplier{}
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.WithValue(context.Background(), replierKey{}, replier(rp))
+			}
+
+			if err := c.Consume(ctx, tt.msg); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Consume error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantResp != nil && rp.resp != tt.wantResp {
+				t.Errorf("reply = %+v, want %+v", rp.resp, tt.wantResp)
+			}
+			if !errors.Is(rp.err, tt.replyErr) {
+				t.Errorf("reply error = %v, want %v", rp.err, tt.replyErr)
+			}
+		})
+	}
+}
+
+func TestRPCChannel(t *testing.T) {
+	rc := &rpcChannel{pending: make(map[string]chan rpcResult)}
+
+	res, ok := rc.add("call-1")
+	if !ok {
+		t.Fatal("add on an open channel failed")
+	}
+
+	// A reply to a call that gave up is dropped.
+	rc.resolve("call-2", rpcResult{err: ErrReplyLost})
+
+	rc.resolve("call-1", rpcResult{msg: amqp091.Delivery{CorrelationId: "call-1"}})
+	if out := <-res; out.msg.CorrelationId != "call-1" || out.err != nil {
+		t.Fatalf("result = %+v", out)
+	}
+	if len(rc.pending) != 0 {
+		t.Fatalf("%d calls pending, want 0", len(rc.pending))
+	}
+
+	rc.closed = true
+	if _, ok := rc.add("call-3"); ok {
+		t.Fatal("add on a closed channel succeeded")
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	r := &rbt{}
+	ctx, cancel := r.callContext(context.Background())
+	defer cancel()
+	if d, ok := ctx.Deadline(); !ok || time.Until(d) > defaultRPCTimeout {
+		t.Errorf("deadline = %v, want the default timeout", d)
+	}
+
+	r = &rbt{conf: GoRabbitConfiguration{RPCTimeout: time.Second}}
+	ctx, cancel = r.callContext(context.Background())
+	defer cancel()
+	if d, ok := ctx.Deadline(); !ok || time.Until(d) > time.Second {
+		t.Errorf("deadline = %v, want the configured timeout", d)
+	}
+
+	// The deadline of the caller is kept.
+	parent, pcancel := context.WithTimeout(context.Background(), time.Hour)
+	defer pcancel()
+	ctx, cancel = r.callContext(parent)
+	defer cancel()
+	if d, _ := ctx.Deadline(); time.Until(d) < time.Minute {
+		t.Errorf("deadline = %v, want the deadline of the caller", d)
+	}
+}
+
+func TestDecodeReply(t *testing.T) {
+	r := &rbt{log: testLogger()}
+
+	err := r.decodeReply("order.get", amqp091.Delivery{Headers: amqp091.Table{HeaderReplyError: "order not found"}}, nil)
+	var rerr *GoRabbitRemoteError
+	if !errors.As(err, &rerr) || rerr.Topic != "order.get" || rerr.Message != "order not found" {
+		t.Fatalf("decodeReply error = %v, want the remote error", err)
+	}
+
+	if err := r.decodeReply("order.get", amqp091.Delivery{Body: []byte("not json")}, nil); err != nil {
+		t.Fatalf("decodeReply without a response = %v", err)
+	}
+
+	var o testOrder
+	if err := r.decodeReply("order.get", amqp091.Delivery{ContentType: ContentTypeJSON, Body: []byte(`{"id":"a"}`)}, &o); err != nil || o.Id != "a" {
+		t.Fatalf("decodeReply = %+v, %v", o, err)
+	}
+}
+
+func TestMemoryCallErrors(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	if err := m.Call(ctx, "order.get", "a", nil); !errors.Is(err, ErrPublishUnroutable) {
+		t.Fatalf("Call error = %v, want ErrPublishUnroutable", err)
+	}
+	if err := m.Call(ctx, " ", "a", nil); !errors.Is(err, ErrTopicRequired) {
+		t.Fatalf("Call error = %v, want ErrTopicRequired", err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders-rpc": {
+			"order.get": Reply(func(context.Context, string, GoRabbitMeta) (testOrder, error) {
+				<-release
+				return testOrder{}, nil
+			}),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := m.Call(cctx, "order.get", "a", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,343 @@
+package gorabbit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// HeaderSchemaVersion is the header that carries the schema version of a message.
+const HeaderSchemaVersion = "x-gorabbit-schema-version"
+
+var (
+	_ SchemaValidator = (*rbt)(nil)
+	_ SchemaValidator = (*GoRabbitMemory)(nil)
+)
+
+// SchemaValidator is an interface that defines the method for validating a message with the schema of its topic.
+// It is implemented by the publishers of GoRabbit, and is used to reject a message that is published later,
+// e.g. by an outbox.
+type SchemaValidator interface {
+	ValidateSchema(opt GoRabbitPublisherOption) error
+}
+
+// Upcaster is a function type that upgrades the body of a message by one schema version.
+// It takes the plain body of a message, in its content type, and returns the body of the next version and an error.
+type Upcaster func(body []byte) ([]byte, error)
+
+// GoRabbitSchema is a struct that represents the schema of the messages of a topic.
+// Version is the current version of the messages, starting at 1. JSONSchema is a JSON Schema document
+// the messages are validated with, and Type is a value of the Go type of the messages, e.g. OrderCreated{}.
+// Either or both can be set. A message that is a json.RawMessage, or a []byte or a string sent with RawCodec, is
+// checked against Type by decoding it, and fails when it has a field Type does not have. Upcasters are the upcasters by the version they upgrade from, so a message
+// of version 1 goes through Upcasters[1], Upcasters[2], and so on up to the current version.
+//
+//	err := r.RegisterSchema("order.created", gorabbit.GoRabbitSchema{
+//		Version:    2,
+//		JSONSchema: orderCreatedSchema,
+//		Upcasters: map[int]gorabbit.Upcaster{
+//			1: upcastOrderCreatedV1,
+//		},
+//	})
+type GoRabbitSchema struct {
+	Version    int
+	JSONSchema []byte
+	Type       any
+	Upcasters  map[int]Upcaster
+}
+
+// schema is a struct that represents a registered GoRabbitSchema.
+type schema struct {
+	GoRabbitSchema
+	compiled *jsonschema.Schema
+	typ      reflect.Type
+}
+
+// schemaRegistry is a struct that represents the schemas of the topics.
+type schemaRegistry struct {
+	mu sync.RWMutex
+	m  map[string]*schema
+}
+
+// RegisterSchema is a function that registers the schema of a topic.
+// It takes a topic and a GoRabbitSchema and returns an error.
+// The published messages of the topic are validated with the schema and carry its version in the
+// HeaderSchemaVersion header, and a message that does not validate is rejected with an error wrapping
+// ErrSchemaValidation. The consumed messages are upcast to the current version and validated before
+// reaching the handler, and a message that cannot be upcast or does not validate is dead-lettered.
+// A message without the header was published before the schema was registered, and is upcast from
+// version 0 when Upcasters[0] is set, and taken as the current version otherwise.
+// The consumed messages are validated with the JSON Schema only when they are JSON. The schema
+// registered before for the topic is replaced, and an error wrapping ErrInvalidSchema is returned when
+// the schema cannot be used.
+func (r *rbt) RegisterSchema(topic string, s GoRabbitSchema) error {
+	if r.trimSpace(topic) == "" {
+		return ErrTopicRequired
+	}
+
+	if s.Version < 1 {
+		return fmt.Errorf("%w: topic '%s': version must be at least 1", ErrInvalidSchema, topic)
+	}
+
+	for from, up := range s.Upcasters {
+		if from < 0 || from >= s.Version || up == nil {
+			return fmt.Errorf("%w: topic '%s': invalid upcaster from version %d", ErrInvalidSchema, topic, from)
+		}
+	}
+
+	sc := &schema{GoRabbitSchema: s}
+
+	if len(s.JSONSchema) > 0 {
+		compiled, err := compileSchema(topic, s.JSONSchema)
+		if err != nil {
+			return fmt.Errorf("%w: topic '%s': %w", ErrInvalidSchema, topic, err)
+		}
+		sc.compiled = compiled
+	}
+
+	if s.Type != nil {
+		sc.typ = reflect.TypeOf(s.Type)
+		for sc.typ.Kind() == reflect.Pointer {
+			sc.typ = sc.typ.Elem()
+		}
+	}
+
+	r.schemas.mu.Lock()
+	defer r.schemas.mu.Unlock()
+
+	if r.schemas.m == nil {
+		r.schemas.m = make(map[string]*schema)
+	}
+	r.schemas.m[topic] = sc
+
+	r.log.Infof("[GoRabbit] Schema of topic '%s' registered. Version: %d", topic, s.Version)
+	return nil
+}
+
+// compileSchema is a function that compiles a JSON Schema document.
+// It takes a topic and the document and returns a pointer to a jsonschema.Schema and an error.
+func compileSchema(topic string, doc []byte) (*jsonschema.Schema, error) {
+	v, err := jsonschema.UnmarshalJSON(bytes.NewReader(doc))
+	if err != nil {
+		return nil, err
+	}
+
+	url := "gorabbit://schemas/" + topic + ".json"
+
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(url, v); err != nil {
+		return nil, err
+	}
+	return c.Compile(url)
+}
+
+// schema is a function that returns the schema of a topic.
+// It takes a topic and returns a pointer to a schema, nil when no schema is registered for the topic.
+func (r *rbt) schema(topic string) *schema {
+	r.schemas.mu.RLock()
+	defer r.schemas.mu.RUnlock()
+
+	return r.schemas.m[topic]
+}
+
+// ValidateSchema is a function that validates a message with the schema of its topic.
+// It takes a GoRabbitPublisherOption and returns an error.
+// The message is validated as it would be by Publish, and nil is returned when no schema is registered for the
+// topic. It returns an error wrapping ErrSchemaValidation when the message does not validate.
+func (r *rbt) ValidateSchema(opt GoRabbitPublisherOption) error {
+	if opt.Message == nil {
+		return ErrMessageRequired
+	}
+
+	codec, err := r.codec(opt.ContentType, opt.Topic)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.versioned(opt.Topic, codec, opt.Message, nil)
+	return err
+}
+
+// versioned is a function that validates a published message with the schema of its topic.
+// It takes a topic, the Codec, the message, and the headers and returns the headers and an error.
+// The headers are returned with the schema version, and as is when no schema is registered for the topic.
+// An error wrapping ErrSchemaValidation is returned when the message does not validate.
+func (r *rbt) versioned(topic string, codec Codec, message any, headers amqp091.Table) (amqp091.Table, error) {
+	sc := r.schema(topic)
+	if sc == nil {
+		return headers, nil
+	}
+
+	if sc.typ != nil {
+		if raw, ok := rawMessage(codec, message); ok {
+			// An encoded message, e.g. relayed by an outbox, is checked by decoding it.
+			if err := decodeStrict(raw, reflect.New(sc.typ).Interface()); err != nil {
+				return nil, fmt.Errorf("%w: topic '%s': %w", ErrSchemaValidation, topic, err)
+			}
+		} else {
+			typ := reflect.TypeOf(message)
+			for typ.Kind() == reflect.Pointer {
+				typ = typ.Elem()
+			}
+			if typ != sc.typ {
+				return nil, fmt.Errorf("%w: topic '%s': message is a %s, not a %s", ErrSchemaValidation, topic, typ, sc.typ)
+			}
+		}
+	}
+
+	if sc.compiled != nil {
+		var (
+			b   []byte
+			err error
+		)
+		switch codec.ContentType() {
+		case ContentTypeJSON, ContentTypeRaw:
+			b, err = codec.Marshal(message)
+		default:
+			b, err = json.Marshal(message)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: topic '%s': %w", ErrSchemaValidation, topic, err)
+		}
+
+		if err := sc.validate(b); err != nil {
+			return nil, fmt.Errorf("%w: topic '%s': %w", ErrSchemaValidation, topic, err)
+		}
+	}
+
+	if headers == nil {
+		headers = amqp091.Table{}
+	}
+	headers[HeaderSchemaVersion] = int32(sc.Version)
+
+	return headers, nil
+}
+
+// rawMessage is a function that returns the body of a message that is already encoded.
+// It takes the Codec and the message and returns a []byte and a bool.
+// It returns false when the message is a value to encode.
+func rawMessage(codec Codec, message any) ([]byte, bool) {
+	switch m := message.(type) {
+	case json.RawMessage:
+		return m, true
+	case []byte:
+		return m, codec.ContentType() == ContentTypeRaw
+	case string:
+		return []byte(m), codec.ContentType() == ContentTypeRaw
+	}
+	return nil, false
+}
+
+// decodeStrict is a function that decodes a JSON message into a value of the schema type.
+// It takes a []byte and a pointer to the value and returns an error.
+// It returns an error when the message has a field the type does not have, or data after the message,
+// so a message of another type is not taken for one of the schema type.
+func decodeStrict(raw []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("invalid data after the message")
+	}
+	return nil
+}
+
+// conform is a function that upcasts and validates a consumed message with the schema of its topic.
+// It takes the plain delivery and returns an amqp091.Delivery and an error.
+// The delivery is returned with the body and the HeaderSchemaVersion header of the current version, and as is
+// when no schema is registered for the topic. A message of a newer version is retried, as it may be
+// handled by a consumer that knows the version, and an error wrapping ErrNonRetryable is returned when
+// the message cannot be upcast or does not validate.
+func (r *rbt) conform(msg amqp091.Delivery) (amqp091.Delivery, error) {
+	sc := r.schema(msg.RoutingKey)
+	if sc == nil {
+		return msg, nil
+	}
+
+	version := sc.Version
+	if _, ok := msg.Headers[HeaderSchemaVersion]; ok {
+		version = headerInt(msg.Headers, HeaderSchemaVersion)
+	} else if sc.Upcasters[0] != nil {
+		version = 0
+	}
+
+	if version > sc.Version {
+		return msg, fmt.Errorf(
+			"%w: topic '%s': version %d is newer than %d",
+			ErrSchemaVersion,
+			msg.RoutingKey,
+			version,
+			sc.Version,
+		)
+	}
+
+	body := msg.Body
+	for v := version; v < sc.Version; v++ {
+		up := sc.Upcasters[v]
+		if up == nil {
+			return msg, NonRetryable(fmt.Errorf(
+				"%w: topic '%s': no upcaster from version %d",
+				ErrSchemaVersion,
+				msg.RoutingKey,
+				v,
+			))
+		}
+
+		b, err := up(body)
+		if err != nil {
+			return msg, NonRetryable(fmt.Errorf(
+				"%w: topic '%s': upcasting from version %d: %w",
+				ErrSchemaVersion,
+				msg.RoutingKey,
+				v,
+				err,
+			))
+		}
+		body = b
+	}
+
+	if sc.compiled != nil && normalizeContentType(msg.ContentType) == ContentTypeJSON {
+		if err := sc.validate(body); err != nil {
+			return msg, NonRetryable(fmt.Errorf("%w: topic '%s': %w", ErrSchemaValidation, msg.RoutingKey, err))
+		}
+	}
+
+	if sc.typ != nil {
+		if err := Decode(amqp091.Delivery{ContentType: msg.ContentType, Body: body}, reflect.New(sc.typ).Interface()); err != nil {
+			return msg, NonRetryable(fmt.Errorf("%w: topic '%s': %w", ErrSchemaValidation, msg.RoutingKey, err))
+		}
+	}
+
+	// The headers are copied, as the ones of the delivery are sent again when the message is retried.
+	headers := maps.Clone(msg.Headers)
+	if headers == nil {
+		headers = amqp091.Table{}
+	}
+	headers[HeaderSchemaVersion] = int32(sc.Version)
+
+	msg.Headers = headers
+	msg.Body = body
+
+	return msg, nil
+}
+
+// validate is a function that validates a JSON document with the JSON Schema.
+// It takes the document and returns an error.
+func (s *schema) validate(doc []byte) error {
+	v, err := jsonschema.UnmarshalJSON(bytes.NewReader(doc))
+	if err != nil {
+		return err
+	}
+	return s.compiled.Validate(v)
+}
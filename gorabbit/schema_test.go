@@ -0,0 +1,237 @@
+package gorabbit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const testOrderSchema = `{
+	"type": "object",
+	"required": ["id", "total"],
+	"properties": {"total": {"type": "integer", "minimum": 0}}
+}`
+
+// upcastAmount renames the amount of the version 1 orders to total.
+func upcastAmount(body []byte) ([]byte, error) {
+	return bytes.Replace(body, []byte(`"amount"`), []byte(`"total"`), 1), nil
+}
+
+func TestRegisterSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		schema  GoRabbitSchema
+		wantErr error
+	}{
+		{name: "valid", topic: "order.created", schema: GoRabbitSchema{Version: 1, JSONSchema: []byte(testOrderSchema)}},
+		{name: "no topic", schema: GoRabbitSchema{Version: 1}, wantErr: ErrTopicRequired},
+		{name: "no version", topic: "order.created", wantErr: ErrInvalidSchema},
+		{name: "invalid json schema", topic: "order.created", schema: GoRabbitSchema{Version: 1, JSONSchema: []byte(`{"type": 3}`)}, wantErr: ErrInvalidSchema},
+		{name: "upcaster from current version", topic: "order.created", schema: GoRabbitSchema{Version: 1, Upcasters: map[int]Upcaster{1: upcastAmount}}, wantErr: ErrInvalidSchema},
+		{name: "nil upcaster", topic: "order.created", schema: GoRabbitSchema{Version: 2, Upcasters: map[int]Upcaster{1: nil}}, wantErr: ErrInvalidSchema},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory(GoRabbitConfiguration{}, nil)
+			if err := m.RegisterSchema(tt.topic, tt.schema); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RegisterSchema error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublishSchemaValidation(t *testing.T) {
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+	if err := m.RegisterSchema("order.created", GoRabbitSchema{
+		Version:    2,
+		JSONSchema: []byte(testOrderSchema),
+		Type:       testOrder{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opt     GoRabbitPublisherOption
+		wantErr error
+	}{
+		{name: "valid", opt: GoRabbitPublisherOption{Message: testOrder{Id: "a", Total: 1}}},
+		{name: "valid pointer", opt: GoRabbitPublisherOption{Message: &testOrder{Id: "a", Total: 1}}},
+		{name: "valid raw json", opt: GoRabbitPublisherOption{Message: json.RawMessage(`{"id":"a","total":1}`)}},
+		{name: "invalid value", opt: GoRabbitPublisherOption{Message: testOrder{Id: "a", Total: -1}}, wantErr: ErrSchemaValidation},
+		{name: "other type", opt: GoRabbitPublisherOption{Message: map[string]any{"id": "a", "total": 1}}, wantErr: ErrSchemaValidation},
+		{name: "invalid raw json", opt: GoRabbitPublisherOption{Message: json.RawMessage(`{"id":"a","total":"1"}`)}, wantErr: ErrSchemaValidation},
+		{name: "raw json of another type", opt: GoRabbitPublisherOption{Message: json.RawMessage(`{"id":"a","total":1,"sku":"b"}`)}, wantErr: ErrSchemaValidation},
+		{name: "raw json with trailing data", opt: GoRabbitPublisherOption{Message: json.RawMessage(`{"id":"a","total":1}{}`)}, wantErr: ErrSchemaValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opt.Topic = "order.created"
+
+			if err := m.ValidateSchema(tt.opt); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateSchema error = %v, want %v", err, tt.wantErr)
+			}
+
+			// No queue is bound, so a valid message is unroutable instead of rejected.
+			err := m.Publish(context.Background(), tt.opt)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Publish error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && errors.Is(err, ErrSchemaValidation) {
+				t.Fatalf("Publish error = %v", err)
+			}
+		})
+	}
+}
+
+func TestConsumeSchemaVersions(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+
+	if err := m.RegisterSchema("order.created", GoRabbitSchema{Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu  sync.Mutex
+		got []amqp091.Delivery
+	)
+	if err := m.Listen(ctx, GoRabbitConsumerMessages{
+		"orders": {
+			"order.created": {Consume: func(_ context.Context, msg amqp091.Delivery) error {
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, msg)
+				return nil
+			}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Pause("orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{`{"id":"a","amount":1}`, `{"id":"b","amount":"x"}`} {
+		if err := m.Publish(ctx, GoRabbitPublisherOption{
+			Topic:   "order.created",
+			Message: json.RawMessage(body),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The consumers are upgraded while the version 1 messages are waiting.
+	if err := m.RegisterSchema("order.created", GoRabbitSchema{
+		Version:    2,
+		JSONSchema: []byte(testOrderSchema),
+		Upcasters:  map[int]Upcaster{1: upcastAmount},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Resume("orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := m.WaitIdle(wctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(got) != 1 {
+		t.Fatalf("handled %d messages, want 1", len(got))
+	}
+	if string(got[0].Body) != `{"id":"a","total":1}` {
+		t.Errorf("body = %s", got[0].Body)
+	}
+	if v := headerInt(got[0].Headers, HeaderSchemaVersion); v != 2 {
+		t.Errorf("schema version = %d, want 2", v)
+	}
+
+	dead := m.DeadLettered()
+	if len(dead) != 1 || !errors.Is(dead[0].Err, ErrSchemaValidation) {
+		t.Fatalf("dead-lettered = %+v, want the invalid message", dead)
+	}
+}
+
+func TestConform(t *testing.T) {
+	m := NewMemory(GoRabbitConfiguration{}, nil)
+	if err := m.RegisterSchema("order.created", GoRabbitSchema{
+		Version:    3,
+		JSONSchema: []byte(testOrderSchema),
+		Type:       testOrder{},
+		Upcasters: map[int]Upcaster{
+			0: func(body []byte) ([]byte, error) { return body, nil },
+			2: upcastAmount,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		version      any
+		body         string
+		want         string
+		wantErr      error
+		nonRetryable bool
+	}{
+		{name: "current", version: int32(3), body: `{"id":"a","total":1}`, want: `{"id":"a","total":1}`},
+		{name: "upcast", version: int32(2), body: `{"id":"a","amount":1}`, want: `{"id":"a","total":1}`},
+		{name: "no upcaster", version: int32(1), body: `{"id":"a","total":1}`, wantErr: ErrSchemaVersion, nonRetryable: true},
+		{name: "no header", body: `{"id":"a","total":1}`, wantErr: ErrSchemaVersion, nonRetryable: true},
+		{name: "newer", version: int64(4), body: `{"id":"a","total":1}`, wantErr: ErrSchemaVersion},
+		{name: "invalid", version: int32(3), body: `{"id":"a"}`, wantErr: ErrSchemaValidation, nonRetryable: true},
+		{name: "wrong type", version: int32(3), body: `{"id":1,"total":1}`, wantErr: ErrSchemaValidation, nonRetryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := amqp091.Delivery{
+				RoutingKey:  "order.created",
+				ContentType: ContentTypeJSON,
+				Body:        []byte(tt.body),
+			}
+			if tt.version != nil {
+				msg.Headers = amqp091.Table{HeaderSchemaVersion: tt.version}
+			}
+
+			got, err := m.core.conform(msg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("conform error = %v, want %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrNonRetryable) != tt.nonRetryable {
+				t.Fatalf("conform error = %v, non-retryable = %v", err, tt.nonRetryable)
+			}
+			if err != nil {
+				return
+			}
+
+			if string(got.Body) != tt.want {
+				t.Errorf("body = %s, want %s", got.Body, tt.want)
+			}
+			if v := headerInt(got.Headers, HeaderSchemaVersion); v != 3 {
+				t.Errorf("schema version = %d, want 3", v)
+			}
+			// The headers of the delivery are sent again on a retry, so they must not change.
+			if v, ok := msg.Headers[HeaderSchemaVersion]; ok && v != tt.version {
+				t.Errorf("delivery header changed to %v", v)
+			}
+		})
+	}
+}
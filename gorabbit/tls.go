@@ -0,0 +1,89 @@
+package gorabbit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// GoRabbitTLSConfiguration is a struct that represents the TLS configuration of the connection.
+// It is used to connect with AMQPS and to authenticate with a client certificate.
+// The CA and the client certificate and key are read from CAFile, CertFile, and KeyFile, or given as PEM in CA,
+// Cert, and Key. The system roots are used without a CA, and ServerName is the host of the broker by default.
+// TLS is enabled when Enabled or any other setting is set.
+type GoRabbitTLSConfiguration struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"caFile"`
+	CertFile           string `mapstructure:"certFile"`
+	KeyFile            string `mapstructure:"keyFile"`
+	CA                 string `mapstructure:"ca"`
+	Cert               string `mapstructure:"cert"`
+	Key                string `mapstructure:"key"`
+	ServerName         string `mapstructure:"serverName"`
+	InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+}
+
+// enabled is a function that reports whether TLS is set.
+// It takes nothing and returns a bool.
+func (c GoRabbitTLSConfiguration) enabled() bool {
+	return c.Enabled ||
+		c.CAFile != "" ||
+		c.CertFile != "" ||
+		c.KeyFile != "" ||
+		c.CA != "" ||
+		c.Cert != "" ||
+		c.Key != "" ||
+		c.ServerName != "" ||
+		c.InsecureSkipVerify
+}
+
+// config is a function that builds the TLS configuration.
+// It takes nothing and returns a pointer to a tls.Config and an error.
+// It returns nil when TLS is not set, so the TLS settings of an amqps URL are used, and an error wrapping
+// ErrInvalidConfig when the CA or the client certificate cannot be loaded.
+func (c GoRabbitTLSConfiguration) config() (*tls.Config, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+
+	conf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	ca := []byte(c.CA)
+	if c.CAFile != "" {
+		b, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: tls ca: %w", ErrInvalidConfig, err)
+		}
+		ca = b
+	}
+
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%w: tls ca: no certificate found", ErrInvalidConfig)
+		}
+		conf.RootCAs = pool
+	}
+
+	switch {
+	case c.CertFile != "" || c.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: tls client certificate: %w", ErrInvalidConfig, err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	case c.Cert != "" || c.Key != "":
+		cert, err := tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+		if err != nil {
+			return nil, fmt.Errorf("%w: tls client certificate: %w", ErrInvalidConfig, err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
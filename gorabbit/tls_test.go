@@ -0,0 +1,122 @@
+package gorabbit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate is a function that creates a self-signed certificate and its key as PEM.
+func testCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gorabbit"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return string(cert), string(keyPem)
+}
+
+func TestTLSEnabled(t *testing.T) {
+	tests := []struct {
+		name string
+		conf GoRabbitTLSConfiguration
+		want bool
+	}{
+		{name: "none", conf: GoRabbitTLSConfiguration{}, want: false},
+		{name: "enabled", conf: GoRabbitTLSConfiguration{Enabled: true}, want: true},
+		{name: "ca file", conf: GoRabbitTLSConfiguration{CAFile: "ca.pem"}, want: true},
+		{name: "server name", conf: GoRabbitTLSConfiguration{ServerName: "broker"}, want: true},
+		{name: "insecure", conf: GoRabbitTLSConfiguration{InsecureSkipVerify: true}, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.conf.enabled(); got != tt.want {
+			t.Errorf("%s: enabled = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	cert, key := testCertificate(t)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, []byte(cert), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, []byte(key), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		conf      GoRabbitTLSConfiguration
+		wantRoots bool
+		wantCerts int
+		wantErr   error
+	}{
+		{name: "enabled", conf: GoRabbitTLSConfiguration{Enabled: true}},
+		{name: "pem", conf: GoRabbitTLSConfiguration{CA: cert, Cert: cert, Key: key}, wantRoots: true, wantCerts: 1},
+		{name: "files", conf: GoRabbitTLSConfiguration{CAFile: certFile, CertFile: certFile, KeyFile: keyFile}, wantRoots: true, wantCerts: 1},
+		{name: "missing ca file", conf: GoRabbitTLSConfiguration{CAFile: filepath.Join(dir, "none.pem")}, wantErr: ErrInvalidConfig},
+		{name: "no certificate in ca", conf: GoRabbitTLSConfiguration{CA: "not a certificate"}, wantErr: ErrInvalidConfig},
+		{name: "cert without key", conf: GoRabbitTLSConfiguration{Cert: cert}, wantErr: ErrInvalidConfig},
+		{name: "missing key file", conf: GoRabbitTLSConfiguration{CertFile: certFile}, wantErr: ErrInvalidConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := tt.conf.config()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("config error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if conf.MinVersion != tls.VersionTLS12 {
+				t.Errorf("min version = %x, want TLS 1.2", conf.MinVersion)
+			}
+			if (conf.RootCAs != nil) != tt.wantRoots || len(conf.Certificates) != tt.wantCerts {
+				t.Errorf("roots = %v, certificates = %d", conf.RootCAs != nil, len(conf.Certificates))
+			}
+		})
+	}
+
+	// The TLS settings of an amqps URL are used when TLS is not set.
+	if conf, err := (GoRabbitTLSConfiguration{}).config(); conf != nil || err != nil {
+		t.Fatalf("config without TLS = %v, %v", conf, err)
+	}
+}
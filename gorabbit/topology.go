@@ -0,0 +1,256 @@
+package gorabbit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultExchange     = "exchange"
+	defaultExchangeKind = amqp091.ExchangeTopic
+
+	// QueueTypeClassic is the classic queue type.
+	QueueTypeClassic = "classic"
+	// QueueTypeQuorum is the quorum queue type.
+	QueueTypeQuorum = "quorum"
+
+	// OverflowDropHead drops the oldest messages when the queue is full.
+	OverflowDropHead = "drop-head"
+	// OverflowRejectPublish rejects the new messages when the queue is full.
+	OverflowRejectPublish = "reject-publish"
+	// OverflowRejectPublishDLX rejects and dead-letters the new messages when the queue is full.
+	OverflowRejectPublishDLX = "reject-publish-dlx"
+)
+
+// GoRabbitExchange is a struct that represents an exchange declaration.
+// It is used to declare the exchanges the messages are published to.
+// Kind is one of topic, direct, fanout, or headers, and defaults to topic.
+type GoRabbitExchange struct {
+	Name       string        `mapstructure:"name"`
+	Kind       string        `mapstructure:"kind"`
+	Transient  bool          `mapstructure:"transient"`
+	AutoDelete bool          `mapstructure:"autoDelete"`
+	Internal   bool          `mapstructure:"internal"`
+	Args       amqp091.Table `mapstructure:"args"`
+}
+
+// GoRabbitQueue is a struct that represents a queue declaration.
+// It is used to declare a queue of GoRabbitConsumerMessages and to bind its topics.
+// Exchange defaults to the configured exchange, Type to classic, and Retry to the global retry configuration.
+// Concurrency is the number of handlers running in parallel and defaults to 1, and PrefetchCount defaults to twice
+// the concurrency. Messages with the same OrderingKey, or the same OrderingHeader value, are handled one at a time in order.
+// Middlewares wrap the consumers of every topic of the queue, inside the global middlewares.
+// MaxPriority makes a classic priority queue, delivering the messages with a higher Priority first.
+type GoRabbitQueue struct {
+	Exchange       string                      `mapstructure:"exchange"`
+	Type           string                      `mapstructure:"type"`
+	Transient      bool                        `mapstructure:"transient"`
+	Exclusive      bool                        `mapstructure:"exclusive"`
+	AutoDelete     bool                        `mapstructure:"autoDelete"`
+	TTL            time.Duration               `mapstructure:"ttl"`
+	MaxLength      int                         `mapstructure:"maxLength"`
+	MaxLengthBytes int                         `mapstructure:"maxLengthBytes"`
+	Overflow       string                      `mapstructure:"overflow"`
+	MaxPriority    uint8                       `mapstructure:"maxPriority"`
+	Args           amqp091.Table               `mapstructure:"args"`
+	BindingArgs    amqp091.Table               `mapstructure:"bindingArgs"`
+	Retry          *GoRabbitRetryConfiguration `mapstructure:"retry"`
+
+	Concurrency    int                               `mapstructure:"concurrency"`
+	PrefetchCount  int                               `mapstructure:"prefetchCount"`
+	PrefetchSize   int                               `mapstructure:"prefetchSize"`
+	OrderingHeader string                            `mapstructure:"orderingHeader"`
+	OrderingKey    func(msg amqp091.Delivery) string `mapstructure:"-"`
+
+	Middlewares []ConsumerMiddleware `mapstructure:"-"`
+}
+
+// arguments is a function that builds the arguments of the queue declaration.
+// It takes nothing and returns an amqp091.Table.
+// The typed fields take precedence over the same keys in Args.
+func (q GoRabbitQueue) arguments() amqp091.Table {
+	args := amqp091.Table{}
+	for k, v := range q.Args {
+		args[k] = v
+	}
+
+	if q.Type != "" {
+		args["x-queue-type"] = q.Type
+	}
+	if q.TTL > 0 {
+		args["x-message-ttl"] = q.TTL.Milliseconds()
+	}
+	if q.MaxLength > 0 {
+		args["x-max-length"] = int64(q.MaxLength)
+	}
+	if q.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = int64(q.MaxLengthBytes)
+	}
+	if q.Overflow != "" {
+		args["x-overflow"] = q.Overflow
+	}
+	if q.MaxPriority > 0 {
+		args["x-max-priority"] = int64(q.MaxPriority)
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
+
+// validate is a function that validates the queue declaration.
+// It takes a queue name and returns an error.
+// This is used to fail early on declarations the broker would refuse.
+func (q GoRabbitQueue) validate(name string) error {
+	if q.Type == QueueTypeQuorum && (q.Transient || q.Exclusive || q.AutoDelete) {
+		return fmt.Errorf("quorum queue '%s' must be durable, non-exclusive, and non-auto-delete", name)
+	}
+	if q.Type == QueueTypeQuorum && q.MaxPriority > 0 {
+		return fmt.Errorf("quorum queue '%s' does not support a max priority", name)
+	}
+	return nil
+}
+
+// exchangeName is a function that returns the name of the default exchange.
+// It takes nothing and returns a string.
+// This is used when neither the publisher option nor the queue names an exchange.
+func (r *rbt) exchangeName() string {
+	if r.conf.Exchange != "" {
+		return r.conf.Exchange
+	}
+	return defaultExchange
+}
+
+// queueConfig is a function that returns the declaration of a queue.
+// It takes a queue name and returns a GoRabbitQueue.
+// It returns the zero declaration when the queue is not configured.
+func (r *rbt) queueConfig(name string) GoRabbitQueue {
+	return r.conf.Queues[name]
+}
+
+// declareExchanges is a function that declares the configured exchanges.
+// It takes a pointer to an amqp091.Channel and returns an error.
+// The default exchange is declared as a durable topic exchange unless it is configured.
+func (r *rbt) declareExchanges(ch *amqp091.Channel) error {
+	exchanges := r.conf.Exchanges
+
+	found := false
+	for _, e := range exchanges {
+		if e.Name == r.exchangeName() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		exchanges = append([]GoRabbitExchange{{Name: r.exchangeName()}}, exchanges...)
+	}
+
+	for _, e := range exchanges {
+		kind := e.Kind
+		if kind == "" {
+			kind = defaultExchangeKind
+		}
+
+		if err := ch.ExchangeDeclare(
+			e.Name,
+			kind,
+			!e.Transient,
+			e.AutoDelete,
+			e.Internal,
+			false,
+			e.Args,
+		); err != nil {
+			return fmt.Errorf("error declaring exchange: '%s': %w", e.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// declareQueue is a function that declares a queue from its configuration.
+// It takes a pointer to an amqp091.Channel and a queue name and returns an amqp091.Queue and an error.
+// This is used to declare the queues of GoRabbitConsumerMessages.
+func (r *rbt) declareQueue(ch *amqp091.Channel, name string) (amqp091.Queue, error) {
+	conf := r.queueConfig(name)
+
+	if err := conf.validate(name); err != nil {
+		return amqp091.Queue{}, err
+	}
+
+	q, err := ch.QueueDeclare(
+		name,
+		!conf.Transient,
+		conf.AutoDelete,
+		conf.Exclusive,
+		false,
+		conf.arguments(),
+	)
+	if err != nil {
+		return q, fmt.Errorf("error declaring queue: '%s': %w", name, err)
+	}
+
+	return q, nil
+}
+
+// bindQueue is a function that binds a queue to its exchange with a topic.
+// It takes a pointer to an amqp091.Channel, a queue name, and a topic and returns an error.
+// This is used to bind the topics of GoRabbitConsumerMessages.
+func (r *rbt) bindQueue(ch *amqp091.Channel, name string, topic string) error {
+	conf := r.queueConfig(name)
+
+	exchange := conf.Exchange
+	if exchange == "" {
+		exchange = r.exchangeName()
+	}
+
+	if err := ch.QueueBind(
+		name,
+		topic,
+		exchange,
+		false,
+		conf.BindingArgs,
+	); err != nil {
+		return fmt.Errorf(
+			"error binding queue: '%s' -> '%s' on '%s': %w",
+			name,
+			topic,
+			exchange,
+			err,
+		)
+	}
+
+	return nil
+}
+
+// topicMatch is a function that reports whether a routing key matches a binding pattern of a topic exchange.
+// It takes a pattern and a routing key and returns a bool.
+// The words are separated by dots, * matches exactly one word, and # matches zero or more words.
+func topicMatch(pattern string, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+// matchWords is a function that matches the words of a routing key against the words of a pattern.
+// It takes the words of the pattern and the words of the routing key and returns a bool.
+func matchWords(pattern []string, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchWords(pattern[1:], key[1:])
+	}
+
+	return len(key) > 0 && pattern[0] == key[0] && matchWords(pattern[1:], key[1:])
+}
@@ -0,0 +1,125 @@
+package gorabbit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestQueueArguments(t *testing.T) {
+	tests := []struct {
+		name  string
+		queue GoRabbitQueue
+		want  amqp091.Table
+	}{
+		{name: "none", queue: GoRabbitQueue{}, want: nil},
+		{
+			name: "typed fields",
+			queue: GoRabbitQueue{
+				Type:           QueueTypeQuorum,
+				TTL:            time.Minute,
+				MaxLength:      100,
+				MaxLengthBytes: 1024,
+				Overflow:       OverflowRejectPublish,
+			},
+			want: amqp091.Table{
+				"x-queue-type":       QueueTypeQuorum,
+				"x-message-ttl":      int64(60000),
+				"x-max-length":       int64(100),
+				"x-max-length-bytes": int64(1024),
+				"x-overflow":         OverflowRejectPublish,
+			},
+		},
+		{
+			name: "typed fields win over args",
+			queue: GoRabbitQueue{
+				MaxPriority: 10,
+				Args:        amqp091.Table{"x-max-priority": int64(5), "x-single-active-consumer": true},
+			},
+			want: amqp091.Table{
+				"x-max-priority":           int64(10),
+				"x-single-active-consumer": true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.queue.arguments(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("arguments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The configured arguments are not changed.
+	q := GoRabbitQueue{MaxPriority: 10, Args: amqp091.Table{"x-max-priority": int64(5)}}
+	_ = q.arguments()
+	if q.Args["x-max-priority"] != int64(5) {
+		t.Errorf("Args changed to %v", q.Args)
+	}
+}
+
+func TestQueueValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		queue   GoRabbitQueue
+		wantErr bool
+	}{
+		{name: "classic", queue: GoRabbitQueue{Transient: true, AutoDelete: true, MaxPriority: 10}},
+		{name: "quorum", queue: GoRabbitQueue{Type: QueueTypeQuorum}},
+		{name: "transient quorum", queue: GoRabbitQueue{Type: QueueTypeQuorum, Transient: true}, wantErr: true},
+		{name: "exclusive quorum", queue: GoRabbitQueue{Type: QueueTypeQuorum, Exclusive: true}, wantErr: true},
+		{name: "auto-delete quorum", queue: GoRabbitQueue{Type: QueueTypeQuorum, AutoDelete: true}, wantErr: true},
+		{name: "priority quorum", queue: GoRabbitQueue{Type: QueueTypeQuorum, MaxPriority: 10}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.queue.validate("orders"); (err != nil) != tt.wantErr {
+				t.Fatalf("validate error = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListenInvalidQueue(t *testing.T) {
+	m := NewMemory(GoRabbitConfiguration{
+		Queues: map[string]GoRabbitQueue{"orders": {Type: QueueTypeQuorum, Exclusive: true}},
+	}, nil)
+
+	if err := m.Listen(context.Background(), GoRabbitConsumerMessages{"orders": {"order.created": {}}}); err == nil {
+		t.Fatal("Listen accepted a queue the broker would refuse")
+	}
+}
+
+func TestExchangeName(t *testing.T) {
+	if got := (&rbt{}).exchangeName(); got != defaultExchange {
+		t.Errorf("exchangeName = %q, want %q", got, defaultExchange)
+	}
+	if got := (&rbt{conf: GoRabbitConfiguration{Exchange: "events"}}).exchangeName(); got != "events" {
+		t.Errorf("exchangeName = %q, want events", got)
+	}
+}
+
+func TestMemoryQueueExchange(t *testing.T) {
+	m := NewMemory(GoRabbitConfiguration{
+		Exchanges: []GoRabbitExchange{{Name: "audit"}},
+		Queues:    map[string]GoRabbitQueue{"audit": {Exchange: "audit"}},
+	}, nil)
+	m.Bind("audit", "#")
+	m.Bind("orders", "#")
+
+	// Each queue is bound on its own exchange.
+	if err := m.Publish(context.Background(), GoRabbitPublisherOption{Exchange: "audit", Topic: "order.created", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(m.Queued("audit")); n != 1 {
+		t.Errorf("audit has %d messages, want 1", n)
+	}
+	if n := len(m.Queued("orders")); n != 0 {
+		t.Errorf("orders has %d messages, want 0", n)
+	}
+}
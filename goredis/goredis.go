@@ -3,6 +3,7 @@ package goredis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -54,9 +55,28 @@ type rds struct {
 	withDebug bool
 }
 
+// ErrConnectionFailed is returned by Connect when Redis cannot be reached.
+var ErrConnectionFailed = errors.New("error connecting to redis")
+
+// Connect is a function that creates a new GoRedis and checks the connection.
+// It takes a context, a GoRedisConfig, a pointer to a logrus.Logger, and a bool and returns a GoRedis and an error.
+// Unlike New, Redis is pinged with the context, and an error wrapping ErrConnectionFailed is returned when
+// it cannot be reached.
+func Connect(ctx context.Context, conf GoRedisConfig, log *logrus.Logger, withDebug bool) (GoRedis, error) {
+	r := New(conf, log, withDebug).(*rds)
+
+	if err := r.rdb.Ping(ctx).Err(); err != nil {
+		_ = r.rdb.Close()
+		return nil, fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+	}
+
+	return r, nil
+}
+
 // New is a function that creates a new GoRedis.
 // It takes a GoRedisConfig, a pointer to a logrus.Logger, and a bool and returns a GoRedis.
 // This is used to create a new GoRedis.
+// The connection is not checked, see Connect for the version returning an error when Redis cannot be reached.
 func New(conf GoRedisConfig, log *logrus.Logger, withDebug bool) GoRedis {
 	if log == nil {
 		gologger.New(
@@ -102,6 +122,21 @@ func (r *rds) Save(ctx context.Context, key string, value any, ttl time.Duration
 	return r.rdb.Set(ctx, key, data, ttl).Err()
 }
 
+// SaveIfNotExists is a function that saves the value to the redis unless the key exists.
+// It takes a context, a string, a any, and a time.Duration and returns a bool and an error.
+// It returns false when the key already exists. This is used to claim a key, e.g. as a lock.
+func (r *rds) SaveIfNotExists(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	r.log.Infof("[GoRedis] Saving to Redis if not exists %s...", key)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	if r.withDebug {
+		r.log.Debug(string(data))
+	}
+	return r.rdb.SetNX(ctx, key, data, ttl).Result()
+}
+
 // Get is a function that gets the value from the redis.
 // It takes a context, a string, and a pointer to a any and returns an error.
 // This is used to get the value from the redis.
@@ -129,6 +164,31 @@ func (r *rds) Delete(ctx context.Context, key string) error {
 	return r.rdb.Del(ctx, key).Err()
 }
 
+// deleteIfEquals is the script that deletes a key only when it holds the value.
+var deleteIfEquals = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// DeleteIfEquals is a function that deletes the value from the redis when it equals a value.
+// It takes a context, a string, and a any and returns a bool and an error.
+// The value is compared and deleted in a script, so no other client can change the key in between. It returns
+// false when the key holds another value or does not exist. This is used to release a claim only when it is still held.
+func (r *rds) DeleteIfEquals(ctx context.Context, key string, value any) (bool, error) {
+	r.log.Infof("[GoRedis] Deleting from Redis if equals %s...", key)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	n, err := deleteIfEquals.Run(ctx, r.rdb, []string{key}, data).Int()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
 // DeleteByPattern is a function that deletes the value from the redis using a pattern.
 // It takes a context, a string, and a int64 and returns an error.
 // This is used to delete the value from the redis using a pattern.